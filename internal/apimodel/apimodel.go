@@ -6,6 +6,15 @@ import "time"
 
 var _ = time.Now
 
+type EffectiveRoles struct {
+	// The subject of the caller. Empty for api keys.
+	Subject string `json:"subject"`
+	// The application roles of the caller, sorted by name.
+	Roles []string `json:"roles"`
+	// The permissions granted to the caller by all their roles, sorted.
+	Permissions []string `json:"permissions"`
+}
+
 type Error struct {
 	// The time at which the error occurred.
 	Timestamp time.Time `json:"timestamp"`
@@ -23,6 +32,69 @@ type Example struct {
 }
 
 type Health struct {
-	// the status of this service. If you get a response at all, status will be \"OK\".
+	// the status of this service. \"OK\" with status 200, or \"DATABASE_UNAVAILABLE\" with status 503 if the database cannot be reached.
 	Status string `json:"status"`
 }
+
+type LogLevelChange struct {
+	// The new global log level. One of TRACE, DEBUG, INFO, WARN, ERROR, FATAL, PANIC, SILENT.
+	Level string `json:"level"`
+	// If set, the level reverts to the previous permanent level after this many seconds.
+	DurationSeconds int64 `json:"duration_seconds,omitempty"`
+}
+
+type LogLevelOverride struct {
+	// Assigned when the override is created. Use it to remove the override.
+	Id string `json:"id,omitempty"`
+	// Only apply to requests by this subject, including requests made while impersonating someone else.
+	Subject string `json:"subject,omitempty"`
+	// Only apply to requests for this route, given as method and route pattern. The method may be *.
+	Route string `json:"route,omitempty"`
+	// Only apply to the request with this request id.
+	RequestId string `json:"request_id,omitempty"`
+	// The log level for matching requests. Overrides can only make logging more verbose than the global level.
+	Level string `json:"level"`
+	// If set when creating the override, it expires after this many seconds.
+	DurationSeconds int64 `json:"duration_seconds,omitempty"`
+	// The time at which the override expires. Not set if it never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type LogLevelSettings struct {
+	// The current global log level.
+	Level string `json:"level"`
+	// The time at which the global log level reverts to its permanent value. Not set if the current level is permanent.
+	RevertAt *time.Time `json:"revert_at,omitempty"`
+	// The log level overrides for specific requests that have not expired.
+	Overrides []LogLevelOverride `json:"overrides"`
+}
+
+type OutboxEvent struct {
+	// Identifies the delivery of the event to one subscriber. Use it to replay the event.
+	Id string `json:"id"`
+	// Identifies the event. The same for all subscribers, and sent to them as the id of the event.
+	EventId string `json:"event_id"`
+	// The type of the event.
+	Type string `json:"type"`
+	// The name of the subscriber the event is delivered to.
+	Subscriber string `json:"subscriber"`
+	// The delivery status. One of pending, delivered, dead.
+	Status string `json:"status"`
+	// The number of delivery attempts so far.
+	Attempts int32 `json:"attempts"`
+	// Why the last delivery attempt failed. Not set if it did not fail.
+	LastError string `json:"last_error,omitempty"`
+	// The time at which the event occurred.
+	CreatedAt time.Time `json:"created_at"`
+	// The time of the next delivery attempt. Only set for pending events.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// The time at which the event was delivered. Only set for delivered events.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// The document sent to the subscriber.
+	Payload map[string]interface{} `json:"payload"`
+}
+
+type OutboxEventList struct {
+	// The events, newest first.
+	Events []OutboxEvent `json:"events"`
+}
@@ -4,11 +4,17 @@ import (
 	"context"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/server"
+	"github.com/eurofurence/reg-backend-template-test/internal/controller/adminctl"
 	"github.com/eurofurence/reg-backend-template-test/internal/controller/examplectl"
 	"github.com/eurofurence/reg-backend-template-test/internal/controller/infoctl"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/configuration"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/errorreport"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/events"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/vault"
 	"github.com/eurofurence/reg-backend-template-test/internal/service/example"
 	"github.com/go-chi/chi/v5"
@@ -25,8 +31,15 @@ import (
 // is supposed to be as easy as possible to read.
 type Application struct {
 	// repositories
-	Vault     vault.Vault
-	IDPClient idp.IdentityProviderClient
+	Vault         vault.Vault
+	IDPClient     idp.IdentityProviderClient
+	ErrorReporter errorreport.Reporter
+	Auditor       audit.Auditor
+	Database      database.Repository
+	Outbox        events.Outbox
+
+	// AdditionalIDPClients are only set up if additional issuers are configured.
+	AdditionalIDPClients []idp.IdentityProviderClient
 
 	// services
 	Example example.Example
@@ -34,6 +47,11 @@ type Application struct {
 	// controllers
 
 	// servers
+
+	// stopBackground cancels background tasks, such as identity provider rediscovery and event dispatch.
+	stopBackground context.CancelFunc
+	// backgroundDone are closed once the respective background task has stopped.
+	backgroundDone []<-chan struct{}
 }
 
 func New() *Application {
@@ -50,12 +68,13 @@ func (a *Application) Run() int {
 	if err := a.SetupRepositories(ctx); err != nil {
 		return 2
 	}
+	defer a.ShutdownRepositories(ctx)
 
 	if err := a.SetupServices(ctx); err != nil {
 		return 3
 	}
 
-	router, err := server.Router(ctx, a.IDPClient)
+	router, err := server.Router(ctx, a.ErrorReporter, a.Auditor, a.IDPClient, a.AdditionalIDPClients...)
 	if err != nil {
 		return 4
 	}
@@ -88,6 +107,32 @@ func (a *Application) SetupConfigurationAndLogging() error {
 }
 
 func (a *Application) SetupRepositories(ctx context.Context) error {
+	// background tasks run until ShutdownRepositories, even if ctx is never cancelled
+	backgroundCtx, cancel := context.WithCancel(ctx)
+	a.stopBackground = cancel
+
+	if err := tracing.Setup(ctx, tracing.OptionsFromConfig()); err != nil {
+		return err
+	}
+
+	if a.ErrorReporter == nil {
+		errorReporter, err := errorreport.New(errorreport.OptionsFromConfig())
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to set up error reporting: %s", err.Error())
+			return err
+		}
+		a.ErrorReporter = errorReporter
+	}
+
+	if a.Auditor == nil {
+		auditSink, err := audit.NewSink(audit.OptionsFromConfig())
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to set up audit log: %s", err.Error())
+			return err
+		}
+		a.Auditor = audit.New(auditSink)
+	}
+
 	if a.Vault == nil {
 		a.Vault = vault.New()
 	}
@@ -98,29 +143,112 @@ func (a *Application) SetupRepositories(ctx context.Context) error {
 	if err := a.Vault.ObtainSecrets(ctx); err != nil {
 		return err
 	}
+	// secrets from vault are only known now
+	if err := logging.SetupRedaction(); err != nil {
+		return err
+	}
+
+	// after vault, which may provide the credentials
+	if a.Database == nil {
+		db, err := database.New(database.OptionsFromConfig())
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to set up database: %s", err.Error())
+			return err
+		}
+		a.Database = db
+	}
+	if err := a.Database.Open(ctx); err != nil {
+		return err
+	}
+	if err := a.Database.Migrate(ctx); err != nil {
+		return err
+	}
+
+	eventsOptions := events.OptionsFromConfig()
+	if a.Outbox == nil {
+		a.Outbox = events.New(a.Database, eventsOptions)
+	}
+	if len(eventsOptions.Subscribers) > 0 {
+		a.backgroundDone = append(a.backgroundDone, events.DispatchInBackground(backgroundCtx, a.Outbox, eventsOptions.DispatchInterval))
+	}
 
+	idpOptions := idp.OptionsFromConfig()
 	if a.IDPClient == nil {
-		options := idp.OptionsFromConfig()
-		a.IDPClient = idp.New(options)
+		a.IDPClient = idp.New(idpOptions)
 	}
 
-	if err := a.IDPClient.SetupFromWellKnown(ctx); err != nil {
+	if err := a.discoverIdentityProvider(ctx, backgroundCtx, a.IDPClient, idpOptions); err != nil {
 		return err
 	}
 
+	if a.AdditionalIDPClients == nil {
+		for _, options := range idp.AdditionalOptionsFromConfig() {
+			a.AdditionalIDPClients = append(a.AdditionalIDPClients, idp.New(options))
+		}
+	}
+
+	for _, additionalIDPClient := range a.AdditionalIDPClients {
+		if err := a.discoverIdentityProvider(ctx, backgroundCtx, additionalIDPClient, idpOptions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ShutdownRepositories stops background tasks, flushes data that repositories have not yet sent, such as spans,
+// and closes the database and the audit log.
+func (a *Application) ShutdownRepositories(ctx context.Context) {
+	a.stopBackgroundTasks()
+	if a.Database != nil {
+		if err := a.Database.Close(); err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to close database: %s", err.Error())
+		}
+	}
+	if a.Auditor != nil {
+		if err := a.Auditor.Close(); err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to close audit log: %s", err.Error())
+		}
+	}
+	if err := tracing.Shutdown(ctx); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to flush spans: %s", err.Error())
+	}
+}
+
+// stopBackgroundTasks cancels all background tasks, and waits until they have stopped.
+func (a *Application) stopBackgroundTasks() {
+	if a.stopBackground != nil {
+		a.stopBackground()
+	}
+	for _, done := range a.backgroundDone {
+		<-done
+	}
+	a.backgroundDone = nil
+}
+
+// discoverIdentityProvider performs the initial discovery, unless degraded startup is allowed, and starts rediscovery.
+func (a *Application) discoverIdentityProvider(ctx context.Context, backgroundCtx context.Context, client idp.IdentityProviderClient, options idp.Options) error {
+	if !options.DegradedStartup {
+		if err := client.SetupFromWellKnown(ctx); err != nil {
+			return err
+		}
+	}
+
+	a.backgroundDone = append(a.backgroundDone, idp.DiscoverInBackground(backgroundCtx, client, options.RediscoveryInterval))
 	return nil
 }
 
 func (a *Application) SetupServices(ctx context.Context) error {
 	if a.Example == nil {
-		a.Example = example.New()
+		a.Example = example.New(a.Database, a.Outbox)
 	}
 
 	return nil
 }
 
 func (a *Application) SetupControllers(ctx context.Context, router chi.Router) error {
-	examplectl.InitRoutes(router, a.Example)
-	infoctl.InitRoutes(router)
-	return nil
+	adminctl.InitRoutes(router, a.Auditor, a.Outbox)
+	examplectl.InitRoutes(router, a.Example, a.Auditor)
+	infoctl.InitRoutes(router, a.Database)
+	return server.CheckRoutes(ctx, router)
 }
@@ -3,13 +3,18 @@ package common
 import (
 	"context"
 	"github.com/golang-jwt/jwt/v5"
+	"strings"
 )
 
 type (
-	CtxKeyIDToken     struct{}
-	CtxKeyAccessToken struct{}
-	CtxKeyAPIKey      struct{}
-	CtxKeyClaims      struct{}
+	CtxKeyIDToken      struct{}
+	CtxKeyAccessToken  struct{}
+	CtxKeyAPIKey       struct{}
+	CtxKeyAPIKeyGroups struct{}
+	CtxKeyClaims       struct{}
+	CtxKeyRoles        struct{}
+	CtxKeyPermissions  struct{}
+	CtxKeyImpersonator struct{}
 
 	CtxKeyRequestID struct{}
 )
@@ -19,6 +24,7 @@ type CustomClaims struct {
 	EMailVerified bool     `json:"email_verified"`
 	Groups        []string `json:"groups,omitempty"`
 	Name          string   `json:"name"`
+	Scope         string   `json:"scope,omitempty"`
 }
 
 type AllClaims struct {
@@ -57,6 +63,21 @@ func GetAccessToken(ctx context.Context) string {
 	return ""
 }
 
+// GetIDToken obtains the raw id token from the context.
+//
+// Only set if the request came with a valid id token cookie.
+func GetIDToken(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	if token, ok := ctx.Value(CtxKeyIDToken{}).(string); ok {
+		return token
+	}
+
+	return ""
+}
+
 // GetClaims extracts all jwt token claims from the context.
 func GetClaims(ctx context.Context) *AllClaims {
 	claims := ctx.Value(CtxKeyClaims{})
@@ -72,13 +93,56 @@ func GetClaims(ctx context.Context) *AllClaims {
 	return allClaims
 }
 
+// GetImpersonator extracts the claims of the real caller if they are impersonating another user.
+//
+// While impersonating, GetClaims and GetSubject return the impersonated user. Returns nil otherwise.
+func GetImpersonator(ctx context.Context) *AllClaims {
+	if ctx == nil {
+		return nil
+	}
+
+	if claims, ok := ctx.Value(CtxKeyImpersonator{}).(*AllClaims); ok {
+		return claims
+	}
+
+	return nil
+}
+
+// GetImpersonatorSubject extracts the subject of the real caller if they are impersonating another user, or "".
+func GetImpersonatorSubject(ctx context.Context) string {
+	if claims := GetImpersonator(ctx); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+// GetAPIKeyName obtains the name of the api key the request was authorized with.
+//
+// The secret itself is never placed in the context.
+func GetAPIKeyName(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	if name, ok := ctx.Value(CtxKeyAPIKey{}).(string); ok {
+		return name
+	}
+
+	return ""
+}
+
 // GetGroups extracts the groups from the jwt token that came with the request
 // or from the groups retrieved from userinfo, if using authorization token.
 //
 // In either case the list is filtered by relevant groups (if reg-auth-service is configured).
+//
+// For requests authorized by api key, these are the groups configured for the key.
 func GetGroups(ctx context.Context) []string {
 	claims := GetClaims(ctx)
 	if claims == nil || claims.Groups == nil {
+		if groups, ok := ctx.Value(CtxKeyAPIKeyGroups{}).([]string); ok && groups != nil {
+			return groups
+		}
 		return []string{}
 	}
 	return claims.Groups
@@ -94,6 +158,75 @@ func HasGroup(ctx context.Context, group string) bool {
 	return false
 }
 
+// GetRoles obtains the application roles of the caller, sorted by name.
+//
+// Roles are resolved from the groups of the caller by the security middleware, according to configuration.
+func GetRoles(ctx context.Context) []string {
+	if ctx == nil {
+		return []string{}
+	}
+
+	if roles, ok := ctx.Value(CtxKeyRoles{}).([]string); ok {
+		return roles
+	}
+
+	return []string{}
+}
+
+// HasRole checks that the caller has an application role.
+func HasRole(ctx context.Context, role string) bool {
+	for _, r := range GetRoles(ctx) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPermissions obtains the permissions granted to the caller by all their roles, sorted.
+func GetPermissions(ctx context.Context) []string {
+	if ctx == nil {
+		return []string{}
+	}
+
+	if permissions, ok := ctx.Value(CtxKeyPermissions{}).([]string); ok {
+		return permissions
+	}
+
+	return []string{}
+}
+
+// HasPermission checks that the caller has a permission, such as "attendee.read", through any of their roles.
+func HasPermission(ctx context.Context, permission string) bool {
+	for _, p := range GetPermissions(ctx) {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// GetScopes extracts the scopes from the access token.
+//
+// These are only available if the token was validated locally, or if token introspection was used.
+func GetScopes(ctx context.Context) []string {
+	claims := GetClaims(ctx)
+	if claims == nil || claims.Scope == "" {
+		return []string{}
+	}
+	return strings.Fields(claims.Scope)
+}
+
+// HasScope checks that the access token has a scope.
+func HasScope(ctx context.Context, scope string) bool {
+	for _, scp := range GetScopes(ctx) {
+		if scp == scope {
+			return true
+		}
+	}
+	return false
+}
+
 // GetSubject extracts the subject field from the jwt token or the userinfo response, if using
 // an authorization token.
 func GetSubject(ctx context.Context) string {
@@ -25,7 +25,15 @@ type ErrorMessageCode string
 const (
 	AuthUnauthorized     ErrorMessageCode = "auth.unauthorized" // token missing completely or invalid or expired
 	AuthForbidden        ErrorMessageCode = "auth.forbidden"    // permissions missing
+	AuthUnavailable      ErrorMessageCode = "auth.unavailable"  // identity provider cannot be reached yet
+	CategoryInvalid      ErrorMessageCode = "category.invalid"
+	EventNotFound        ErrorMessageCode = "event.notfound"
+	EventNotDead         ErrorMessageCode = "event.not.dead" // only dead events can be replayed
+	LogOverrideNotFound  ErrorMessageCode = "log.override.notfound"
 	RequestParseFailed   ErrorMessageCode = "request.parse.failed"
+	RequestRateLimited   ErrorMessageCode = "request.rate.limited" // too many requests, see Retry-After header
+	RequestTimeout       ErrorMessageCode = "request.timeout"      // request not processed in time
+	ServiceOverloaded    ErrorMessageCode = "service.overloaded"   // too many requests in progress, see Retry-After header
 	ValueTooHigh         ErrorMessageCode = "value.too.high"
 	ValueTooLow          ErrorMessageCode = "value.too.low"
 	InternalErrorMessage ErrorMessageCode = "error.internal"
@@ -54,6 +62,10 @@ func NewConflict(ctx context.Context, message ErrorMessageCode, details url.Valu
 	return NewAPIError(ctx, http.StatusConflict, message, details)
 }
 
+func NewTooManyRequests(ctx context.Context, message ErrorMessageCode, details url.Values) APIError {
+	return NewAPIError(ctx, http.StatusTooManyRequests, message, details)
+}
+
 func NewInternalServerError(ctx context.Context, message ErrorMessageCode, details url.Values) APIError {
 	return NewAPIError(ctx, http.StatusInternalServerError, message, details)
 }
@@ -62,6 +74,10 @@ func NewBadGateway(ctx context.Context, message ErrorMessageCode, details url.Va
 	return NewAPIError(ctx, http.StatusBadGateway, message, details)
 }
 
+func NewServiceUnavailable(ctx context.Context, message ErrorMessageCode, details url.Values) APIError {
+	return NewAPIError(ctx, http.StatusServiceUnavailable, message, details)
+}
+
 // check for API errors
 
 func IsBadRequestError(err error) bool {
@@ -88,6 +104,14 @@ func IsBadGatewayError(err error) bool {
 	return isAPIErrorWithStatus(http.StatusBadGateway, err)
 }
 
+func IsServiceUnavailableError(err error) bool {
+	return isAPIErrorWithStatus(http.StatusServiceUnavailable, err)
+}
+
+func IsTooManyRequestsError(err error) bool {
+	return isAPIErrorWithStatus(http.StatusTooManyRequests, err)
+}
+
 func IsInternalServerError(err error) bool {
 	return isAPIErrorWithStatus(http.StatusInternalServerError, err)
 }
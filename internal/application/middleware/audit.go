@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+)
+
+type AuditOptions struct {
+	// Enabled turns on auditing of all state changing requests.
+	Enabled bool
+
+	Auditor audit.Auditor
+}
+
+// AuditRequests creates a middleware that records an audit event for every POST, PUT, PATCH and DELETE request.
+//
+// The action is the method and route pattern, the resource is the request path. Handlers that know more
+// about the change should record their own events in addition.
+//
+// Place it after CheckRequestAuthorization, so the caller is known. Requests rejected there are not audited.
+func AuditRequests(conf *AuditOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !conf.Enabled || conf.Auditor == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			completed := false
+			defer func() {
+				status := ww.Status()
+				if status == 0 && completed {
+					// nothing written, net/http sends 200
+					status = http.StatusOK
+				}
+
+				ctx := r.Context()
+				action := r.Method + " " + requestRoutePattern(r)
+				if err := conf.Auditor.RecordOutcome(ctx, auditOutcome(status), action, r.URL.Path, nil, nil); err != nil {
+					aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to record audit event for %s: %s", action, err.Error())
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+			completed = true
+		})
+	}
+}
+
+func auditOutcome(status int) audit.Outcome {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return audit.OutcomeDenied
+	case status == 0 || status >= 400:
+		// no status means the handler panicked before writing a response
+		return audit.OutcomeFailure
+	default:
+		return audit.OutcomeSuccess
+	}
+}
+
+const (
+	ConfAuditRequests = "AUDIT_REQUESTS"
+)
+
+func AuditConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfAuditRequests,
+			Default:     "0",
+			Description: "record an audit event for every state changing request (POST, PUT, PATCH, DELETE). Off by default, explicit audit events for changes are always recorded. Enable by setting this to '1'.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1),
+		},
+	}
+}
+
+// AuditOptionsPartialFromConfig reads the configuration. The Auditor must be set by the caller.
+func AuditOptionsPartialFromConfig() AuditOptions {
+	return AuditOptions{
+		Enabled: auconfigenv.Get(ConfAuditRequests) == "1",
+	}
+}
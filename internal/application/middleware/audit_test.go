@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditRequests(t *testing.T) {
+	sink := audit.NewMemorySink()
+	conf := &AuditOptions{Enabled: true, Auditor: audit.New(sink)}
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), common.CtxKeyClaims{}, &common.AllClaims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "1234"},
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Use(AuditRequests(conf))
+	router.Post("/tst/{status}", func(w http.ResponseWriter, r *http.Request) {
+		switch chi.URLParam(r, "status") {
+		case "403":
+			w.WriteHeader(http.StatusForbidden)
+		case "409":
+			w.WriteHeader(http.StatusConflict)
+		case "201":
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	router.Get("/tst/{status}", func(w http.ResponseWriter, r *http.Request) {})
+
+	testcases := []struct {
+		name     string
+		method   string
+		path     string
+		expected []audit.Outcome
+	}{
+		{name: "created", method: http.MethodPost, path: "/tst/201", expected: []audit.Outcome{audit.OutcomeSuccess}},
+		{name: "nothing_written", method: http.MethodPost, path: "/tst/200", expected: []audit.Outcome{audit.OutcomeSuccess}},
+		{name: "forbidden", method: http.MethodPost, path: "/tst/403", expected: []audit.Outcome{audit.OutcomeDenied}},
+		{name: "conflict", method: http.MethodPost, path: "/tst/409", expected: []audit.Outcome{audit.OutcomeFailure}},
+		{name: "method_not_allowed", method: http.MethodDelete, path: "/tst/200", expected: []audit.Outcome{audit.OutcomeFailure}},
+		{name: "get", method: http.MethodGet, path: "/tst/200", expected: nil},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink.Reset()
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+
+			events := sink.Events()
+			require.Len(t, events, len(tc.expected))
+			for i, event := range events {
+				require.Equal(t, tc.expected[i], event.Outcome)
+				require.Equal(t, tc.path, event.Resource)
+				require.Equal(t, "1234", event.Subject)
+			}
+		})
+	}
+
+	sink.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/tst/201", nil))
+	require.Equal(t, "POST /tst/{status}", sink.Events()[0].Action)
+}
+
+func TestAuditRequests_Disabled(t *testing.T) {
+	sink := audit.NewMemorySink()
+	handler := AuditRequests(&AuditOptions{Auditor: audit.New(sink)})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	require.Empty(t, sink.Events())
+}
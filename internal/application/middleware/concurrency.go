@@ -0,0 +1,318 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ConcurrencyOptions struct {
+	// Limit caps the number of requests processed at the same time. 0 means no limit.
+	Limit int
+
+	// Groups cap the requests to some routes separately, in addition to Limit. The first matching group applies.
+	Groups []ConcurrencyGroup
+
+	// QueueTimeout is how long a request waits for a free slot before it is shed with a 503.
+	QueueTimeout time.Duration
+
+	// Adaptive lowers the limits while requests take longer than TargetLatency, and raises them back
+	// up to the configured values while they are faster.
+	Adaptive      bool
+	TargetLatency time.Duration
+}
+
+// ConcurrencyGroup is a set of routes, in the same format as SecurityOptions.OpenEndpoints, that share a limit.
+type ConcurrencyGroup struct {
+	Name   string   `json:"name"`
+	Routes []string `json:"routes"`
+	Limit  int      `json:"limit"`
+}
+
+// globalConcurrencyGroup is the group name used in metrics for the global limit.
+const globalConcurrencyGroup = "global"
+
+// ConcurrencyLimiting creates a middleware that sheds requests with a 503 when too many are in flight.
+//
+// Place it early, before CheckRequestAuthorization, so shed requests cause as little work as possible,
+// but after RequestMetrics, so they are counted.
+func ConcurrencyLimiting(conf *ConcurrencyOptions) func(http.Handler) http.Handler {
+	registerConcurrencyMetrics()
+
+	var global *concurrencyLimiter
+	if conf.Limit > 0 {
+		global = newConcurrencyLimiter(globalConcurrencyGroup, conf.Limit, conf)
+	}
+
+	groups := make([]*concurrencyLimiter, 0, len(conf.Groups))
+	matchers := make([]*routeMatcher, 0, len(conf.Groups))
+	for _, group := range conf.Groups {
+		matcher, err := newRouteMatcher(group.Routes)
+		if err != nil {
+			// config was validated, so this should only happen in tests
+			aulogging.Logger.NoCtx().Error().WithErr(err).Printf("invalid routes for concurrency group %s, ignoring it: %s", group.Name, err.Error())
+		}
+		matchers = append(matchers, matcher)
+		groups = append(groups, newConcurrencyLimiter(group.Name, group.Limit, conf))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			limiters := make([]*concurrencyLimiter, 0, 2)
+			routePattern := requestRoutePattern(r)
+			for i, matcher := range matchers {
+				if matcher.matches(r.Method, routePattern) {
+					limiters = append(limiters, groups[i])
+					break
+				}
+			}
+			if global != nil {
+				limiters = append(limiters, global)
+			}
+
+			// the specific group first, so requests waiting for a busy group do not hold a global slot
+			deadline := time.Now().Add(conf.QueueTimeout)
+			for i, limiter := range limiters {
+				if !limiter.acquire(ctx, deadline) {
+					for _, acquired := range limiters[:i] {
+						acquired.release(0)
+					}
+					aulogging.Warnf(ctx, "shedding request, concurrency limit of group %s reached", limiter.name)
+					shed.WithLabelValues(limiter.name, r.Method, routePattern).Inc()
+					w.Header().Set("Retry-After", "1")
+					web.SendServiceOverloadedResponse(ctx, w, "too many requests in progress, please try again later")
+					return
+				}
+			}
+
+			start := time.Now()
+			running := &atomic.Pointer[<-chan struct{}]{}
+			defer func() {
+				release := func() {
+					elapsed := time.Since(start)
+					for _, limiter := range limiters {
+						limiter.release(elapsed)
+					}
+				}
+				if done := running.Load(); done != nil {
+					// the handler of a timed out request keeps its slots until it has actually finished,
+					// so the work in progress stays bounded while the service is overloaded
+					go func() {
+						<-*done
+						release()
+					}()
+					return
+				}
+				release()
+			}()
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, ctxKeyHandlerRunning{}, running)))
+		})
+	}
+}
+
+type ctxKeyHandlerRunning struct{}
+
+// markHandlerStillRunning lets ConcurrencyLimiting know the handler of a request that has already been answered
+// is still running, so it keeps the slots of the request until done is closed.
+func markHandlerStillRunning(ctx context.Context, done <-chan struct{}) {
+	if running, ok := ctx.Value(ctxKeyHandlerRunning{}).(*atomic.Pointer[<-chan struct{}]); ok {
+		running.Store(&done)
+	}
+}
+
+// concurrencyLimiter is a semaphore with a fair queue and a limit that may change while in use.
+type concurrencyLimiter struct {
+	name     string
+	maxLimit int
+	adaptive bool
+	target   time.Duration
+
+	limit         float64
+	inFlight      int
+	waiters       []chan struct{}
+	lastDecreased time.Time
+	mutex         sync.Mutex
+}
+
+func newConcurrencyLimiter(name string, limit int, conf *ConcurrencyOptions) *concurrencyLimiter {
+	inFlight.WithLabelValues(name).Set(0)
+	concurrencyLimit.WithLabelValues(name).Set(float64(limit))
+	return &concurrencyLimiter{
+		name:     name,
+		maxLimit: limit,
+		adaptive: conf.Adaptive && conf.TargetLatency > 0,
+		target:   conf.TargetLatency,
+		limit:    float64(limit),
+	}
+}
+
+// acquire waits for a free slot until the deadline, returning false if there was none.
+func (l *concurrencyLimiter) acquire(ctx context.Context, deadline time.Time) bool {
+	l.mutex.Lock()
+	if l.inFlight < l.currentLimit() && len(l.waiters) == 0 {
+		l.inFlight++
+		inFlight.WithLabelValues(l.name).Inc()
+		l.mutex.Unlock()
+		return true
+	}
+	granted := make(chan struct{})
+	l.waiters = append(l.waiters, granted)
+	l.mutex.Unlock()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-granted:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, waiter := range l.waiters {
+		if waiter == granted {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return false
+		}
+	}
+	// the slot was granted just as we gave up waiting
+	return true
+}
+
+// release frees a slot, handing it to the next waiting request, and adapts the limit to the latency of the request.
+//
+// Pass 0 as latency for requests that did not run.
+func (l *concurrencyLimiter) release(latency time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if latency > 0 {
+		l.adapt(latency)
+	}
+
+	l.inFlight--
+	inFlight.WithLabelValues(l.name).Dec()
+	for len(l.waiters) > 0 && l.inFlight < l.currentLimit() {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		l.inFlight++
+		inFlight.WithLabelValues(l.name).Inc()
+	}
+}
+
+// adapt increases the limit additively while requests are fast, and decreases it multiplicatively while they
+// are slow, at most once per target latency, so one slow burst does not collapse the limit.
+//
+// Must be called with the mutex held.
+func (l *concurrencyLimiter) adapt(latency time.Duration) {
+	if !l.adaptive {
+		return
+	}
+	if latency <= l.target {
+		l.limit = math.Min(float64(l.maxLimit), l.limit+1/l.limit)
+	} else if time.Since(l.lastDecreased) >= l.target {
+		l.limit = math.Max(1, l.limit*0.9)
+		l.lastDecreased = time.Now()
+	}
+	concurrencyLimit.WithLabelValues(l.name).Set(float64(l.currentLimit()))
+}
+
+func (l *concurrencyLimiter) currentLimit() int {
+	return int(l.limit)
+}
+
+const (
+	ConfConcurrencyLimit               = "CONCURRENCY_LIMIT"
+	ConfConcurrencyGroups              = "CONCURRENCY_GROUPS"
+	ConfConcurrencyQueueTimeoutMillis  = "CONCURRENCY_QUEUE_TIMEOUT_MILLISECONDS"
+	ConfConcurrencyAdaptive            = "CONCURRENCY_ADAPTIVE"
+	ConfConcurrencyTargetLatencyMillis = "CONCURRENCY_TARGET_LATENCY_MILLISECONDS"
+)
+
+func ConcurrencyConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfConcurrencyLimit,
+			Default:     "200",
+			Description: "maximum number of requests processed at the same time. Further requests wait for up to " + ConfConcurrencyQueueTimeoutMillis + ", then they are answered with 503. Set to 0 to disable.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 100000),
+		}, {
+			Key:         ConfConcurrencyGroups,
+			Default:     "[]",
+			Description: "separate concurrency limits for groups of routes, which apply in addition to " + ConfConcurrencyLimit + ". JSON list of {\"name\": ..., \"routes\": [...], \"limit\": ...}, routes use the same format as OPEN_ENDPOINTS. The first group with a matching route applies. Names are used in metrics.",
+			Validate:    validateConcurrencyGroups,
+		}, {
+			Key:         ConfConcurrencyQueueTimeoutMillis,
+			Default:     "500",
+			Description: "how long in milliseconds a request waits for a free slot before it is answered with 503.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 60000),
+		}, {
+			Key:         ConfConcurrencyAdaptive,
+			Default:     "0",
+			Description: "adapt the concurrency limits to observed latency. Off by default. Enable by setting this to '1'. The configured limits are then maximum values, lowered while requests take longer than " + ConfConcurrencyTargetLatencyMillis + ".",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1),
+		}, {
+			Key:         ConfConcurrencyTargetLatencyMillis,
+			Default:     "1000",
+			Description: "request latency in milliseconds above which adaptive concurrency limits are lowered.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 600000),
+		},
+	}
+}
+
+var validConcurrencyGroupName = validRoleName
+
+func parseConcurrencyGroups(value string) ([]ConcurrencyGroup, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	result := make([]ConcurrencyGroup, 0)
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to parse concurrency group configuration: %w", err)
+	}
+	for _, group := range result {
+		if !validConcurrencyGroupName.MatchString(group.Name) || group.Name == globalConcurrencyGroup {
+			return result, fmt.Errorf("invalid concurrency group name '%s'", group.Name)
+		}
+		if group.Limit < 1 {
+			return result, fmt.Errorf("invalid limit for concurrency group %s, must be at least 1", group.Name)
+		}
+		if _, err := newRouteMatcher(group.Routes); err != nil {
+			return result, fmt.Errorf("failed to parse concurrency group configuration for %s: %w", group.Name, err)
+		}
+	}
+	return result, nil
+}
+
+func validateConcurrencyGroups(key string) error {
+	_, err := parseConcurrencyGroups(auconfigenv.Get(key))
+	return err
+}
+
+func ConcurrencyOptionsFromConfig() ConcurrencyOptions {
+	limit, _ := auconfigenv.AToInt(auconfigenv.Get(ConfConcurrencyLimit))
+	groups, _ := parseConcurrencyGroups(auconfigenv.Get(ConfConcurrencyGroups))
+	queueTimeout, _ := auconfigenv.AToInt(auconfigenv.Get(ConfConcurrencyQueueTimeoutMillis))
+	targetLatency, _ := auconfigenv.AToInt(auconfigenv.Get(ConfConcurrencyTargetLatencyMillis))
+	return ConcurrencyOptions{
+		Limit:         limit,
+		Groups:        groups,
+		QueueTimeout:  time.Duration(queueTimeout) * time.Millisecond,
+		Adaptive:      auconfigenv.Get(ConfConcurrencyAdaptive) == "1",
+		TargetLatency: time.Duration(targetLatency) * time.Millisecond,
+	}
+}
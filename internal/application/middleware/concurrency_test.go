@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tstConcurrencyRouter serves /slow, which blocks until release is closed, and /fast.
+func tstConcurrencyRouter(conf *ConcurrencyOptions, release chan struct{}, started *sync.WaitGroup) http.Handler {
+	router := chi.NewRouter()
+	router.Use(ConcurrencyLimiting(conf))
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+	})
+	router.Get("/fast", func(w http.ResponseWriter, r *http.Request) {})
+	return router
+}
+
+func tstServe(handler http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestConcurrencyLimiting_Shed(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	cut := tstConcurrencyRouter(&ConcurrencyOptions{Limit: 1, QueueTimeout: 20 * time.Millisecond}, release, &started)
+
+	started.Add(1)
+	done := make(chan int)
+	go func() {
+		done <- tstServe(cut, "/slow").Code
+	}()
+	started.Wait()
+
+	response := tstServe(cut, "/fast")
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	require.Equal(t, "1", response.Header().Get("Retry-After"))
+	require.Contains(t, response.Body.String(), `"message":"service.overloaded"`)
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+	require.Equal(t, http.StatusOK, tstServe(cut, "/fast").Code, "slots must be freed after requests complete")
+}
+
+func TestConcurrencyLimiting_Queue(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	cut := tstConcurrencyRouter(&ConcurrencyOptions{Limit: 1, QueueTimeout: 5 * time.Second}, release, &started)
+
+	started.Add(1)
+	done := make(chan int)
+	go func() {
+		done <- tstServe(cut, "/slow").Code
+	}()
+	started.Wait()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	require.Equal(t, http.StatusOK, tstServe(cut, "/fast").Code, "queued requests must be served once a slot is free")
+	require.Equal(t, http.StatusOK, <-done)
+}
+
+func TestConcurrencyLimiting_TimedOutHandlerKeepsSlot(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	router := chi.NewRouter()
+	router.Use(ConcurrencyLimiting(&ConcurrencyOptions{Limit: 1, QueueTimeout: 20 * time.Millisecond}))
+	router.Use(Timeout(20 * time.Millisecond))
+	router.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		<-release
+	})
+	router.Get("/fast", func(w http.ResponseWriter, r *http.Request) {})
+
+	response := tstServe(router, "/slow")
+	require.Contains(t, response.Body.String(), `"message":"request.timeout"`)
+
+	response = tstServe(router, "/fast")
+	require.Contains(t, response.Body.String(), `"message":"service.overloaded"`, "the timed out handler must keep its slot while it runs")
+
+	close(release)
+	<-finished
+	require.Eventually(t, func() bool {
+		return tstServe(router, "/fast").Code == http.StatusOK
+	}, time.Second, 5*time.Millisecond, "the slot must be freed once the handler has finished")
+}
+
+func TestConcurrencyLimiting_Groups(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	cut := tstConcurrencyRouter(&ConcurrencyOptions{
+		Limit:        10,
+		Groups:       []ConcurrencyGroup{{Name: "slow", Routes: []string{"GET /slow"}, Limit: 1}},
+		QueueTimeout: 20 * time.Millisecond,
+	}, release, &started)
+
+	started.Add(1)
+	done := make(chan int)
+	go func() {
+		done <- tstServe(cut, "/slow").Code
+	}()
+	started.Wait()
+
+	require.Equal(t, http.StatusOK, tstServe(cut, "/fast").Code, "other routes must not be limited by the group")
+	require.Equal(t, http.StatusServiceUnavailable, tstServe(cut, "/slow").Code)
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-done)
+}
+
+func TestConcurrencyLimiter_Adaptive(t *testing.T) {
+	registerConcurrencyMetrics()
+	cut := newConcurrencyLimiter("tstadaptive", 10, &ConcurrencyOptions{Adaptive: true, TargetLatency: time.Nanosecond})
+	cut.inFlight = 1
+
+	cut.release(time.Second)
+	require.Equal(t, 9, cut.currentLimit(), "slow requests must lower the limit")
+
+	cut.target = time.Hour
+	for i := 0; i < 20; i++ {
+		cut.inFlight = 1
+		cut.release(time.Millisecond)
+	}
+	require.Equal(t, 10, cut.currentLimit(), "fast requests must raise the limit back up to the configured value")
+}
+
+func TestParseConcurrencyGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "empty", value: `[]`},
+		{name: "valid", value: `[{"name":"slow","routes":["GET /slow/*"],"limit":5}]`},
+		{name: "invalid json", value: `[{"name":"slow"`, wantErr: "failed to parse concurrency group configuration"},
+		{name: "unknown field", value: `[{"name":"slow","limit":5,"burst":3}]`, wantErr: "failed to parse concurrency group configuration"},
+		{name: "invalid name", value: `[{"name":"Slow","limit":5}]`, wantErr: "invalid concurrency group name 'Slow'"},
+		{name: "reserved name", value: `[{"name":"global","limit":5}]`, wantErr: "invalid concurrency group name 'global'"},
+		{name: "zero limit", value: `[{"name":"slow","limit":0}]`, wantErr: "invalid limit for concurrency group slow"},
+		{name: "invalid route", value: `[{"name":"slow","routes":["/slow"],"limit":5}]`, wantErr: "failed to parse concurrency group configuration for slow"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConcurrencyGroups(tt.value)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -25,7 +25,7 @@ func CorsHeaders(options *CorsOptions) func(http.Handler) http.Handler {
 				aulogging.Infof(ctx, "sending headers to disable CORS from %s", options.AllowOrigin)
 				w.Header().Set(headers.AccessControlAllowOrigin, options.AllowOrigin)
 				w.Header().Set(headers.AccessControlAllowMethods, "POST, GET, OPTIONS, PUT, DELETE")
-				w.Header().Set(headers.AccessControlAllowHeaders, "content-type")
+				w.Header().Set(headers.AccessControlAllowHeaders, "content-type, "+strings.ToLower(impersonateHeader))
 				w.Header().Set(headers.AccessControlAllowCredentials, "true")
 				w.Header().Set(headers.AccessControlExposeHeaders, strings.Join(options.ExposeHeaders, ", "))
 			}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/golang-jwt/jwt/v5"
+	"regexp"
+)
+
+const impersonateHeader = "X-Impersonate-Subject"
+
+var validImpersonatedSubject = regexp.MustCompile("^[!-~]{1,255}$")
+
+// checkImpersonation replaces the claims of the caller with those of the user named in the X-Impersonate-Subject header.
+//
+// Only logged-in users in SecurityOptions.ImpersonationGroup may do this. The claims of the target user only have
+// their subject, because we cannot know their groups or profile, so the request sees what an ordinary attendee sees.
+// They also have no scopes, because the scopes of the token were granted to the real caller, not to the target user,
+// so routes that require scopes reject impersonated requests.
+// The claims of the real caller are kept, see common.GetImpersonator.
+func checkImpersonation(ctx context.Context, conf *SecurityOptions, targetSubject string) (context.Context, string, error) {
+	if targetSubject == "" {
+		return ctx, "", nil
+	}
+
+	if conf.ImpersonationGroup == "" {
+		return ctx, "impersonation is not enabled", fmt.Errorf("impersonation of %s requested, but not enabled: %w", targetSubject, errForbidden)
+	}
+
+	actor := common.GetClaims(ctx)
+	if actor == nil || actor.Subject == "" {
+		return ctx, "you must be logged in to impersonate another user", fmt.Errorf("impersonation of %s requested without a logged in user: %w", targetSubject, errForbidden)
+	}
+	if !common.HasGroup(ctx, conf.ImpersonationGroup) {
+		return ctx, "you are not allowed to impersonate other users", fmt.Errorf("impersonation of %s requested by subject %s who is not in group %s: %w", targetSubject, actor.Subject, conf.ImpersonationGroup, errForbidden)
+	}
+	if !validImpersonatedSubject.MatchString(targetSubject) {
+		return ctx, "invalid subject to impersonate", fmt.Errorf("impersonation of invalid subject requested by subject %s: %w", actor.Subject, errForbidden)
+	}
+
+	impersonated := &common.AllClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    actor.Issuer,
+			Subject:   targetSubject,
+			Audience:  actor.Audience,
+			ExpiresAt: actor.ExpiresAt,
+		},
+	}
+
+	aulogging.Infof(ctx, "subject %s is impersonating subject %s", actor.Subject, targetSubject)
+	ctx = context.WithValue(ctx, common.CtxKeyImpersonator{}, actor)
+	ctx = context.WithValue(ctx, common.CtxKeyClaims{}, impersonated)
+	return ctx, "", nil
+}
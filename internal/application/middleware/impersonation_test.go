@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCheckImpersonation(t *testing.T) {
+	withClaims := func(subject string, groups ...string) context.Context {
+		return context.WithValue(context.Background(), common.CtxKeyClaims{}, &common.AllClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: subject, Issuer: "the-issuer"},
+			CustomClaims:     common.CustomClaims{Groups: groups, Name: "Admin", Scope: "openid"},
+		})
+	}
+	enabled := &SecurityOptions{ImpersonationGroup: "admin"}
+
+	testcases := []struct {
+		name          string
+		conf          *SecurityOptions
+		ctx           context.Context
+		target        string
+		expectSubject string
+		expectActor   string
+		expectMsg     string
+	}{
+		{name: "no_header", conf: enabled, ctx: withClaims("101", "admin"), expectSubject: "101"},
+		{name: "admin", conf: enabled, ctx: withClaims("101", "admin"), target: "202", expectSubject: "202", expectActor: "101"},
+		{name: "disabled", conf: &SecurityOptions{}, ctx: withClaims("101", "admin"), target: "202", expectMsg: "impersonation is not enabled"},
+		{name: "not_admin", conf: enabled, ctx: withClaims("101", "staff"), target: "202", expectMsg: "you are not allowed to impersonate other users"},
+		{name: "anonymous", conf: enabled, ctx: context.Background(), target: "202", expectMsg: "you must be logged in to impersonate another user"},
+		{name: "invalid_target", conf: enabled, ctx: withClaims("101", "admin"), target: "20 2", expectMsg: "invalid subject to impersonate"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, msg, err := checkImpersonation(tc.ctx, tc.conf, tc.target)
+			if tc.expectMsg != "" {
+				require.True(t, errors.Is(err, errForbidden))
+				require.Equal(t, tc.expectMsg, msg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectSubject, common.GetSubject(ctx))
+			require.Equal(t, tc.expectActor, common.GetImpersonatorSubject(ctx))
+			if tc.expectActor != "" {
+				claims := common.GetClaims(ctx)
+				require.Empty(t, claims.Groups, "the groups of the real caller must not carry over")
+				require.Empty(t, claims.Name)
+				require.Equal(t, "the-issuer", claims.Issuer)
+				require.Empty(t, claims.Scope, "the scopes of the real caller must not carry over")
+			}
+		})
+	}
+}
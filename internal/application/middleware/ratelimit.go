@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+type RateLimitOptions struct {
+	// Default applies to all routes without an override. A RequestsPerMinute of 0 disables rate limiting.
+	Default RateLimit
+
+	// Routes overrides the limit for individual routes. The first matching entry applies.
+	//
+	// Each override has separate buckets, so calls to these routes do not count against the default limit.
+	Routes []RouteRateLimit
+
+	// Anonymous also limits callers that are neither logged in nor use an api key, by their client ip address.
+	Anonymous bool
+
+	// TrustedProxies are the networks of the reverse proxies in front of the service.
+	//
+	// For requests from these, the client ip address is taken from the X-Forwarded-For header instead.
+	// Without them, all anonymous requests that pass through a proxy share the proxy's address.
+	TrustedProxies []netip.Prefix
+
+	// Store keeps the token buckets. Defaults to an in-memory store.
+	Store RateLimitStore
+
+	// compiled from Routes by RateLimiting, leaving out invalid entries
+	compiledRoutes []compiledRouteRateLimit
+}
+
+type compiledRouteRateLimit struct {
+	RouteRateLimit
+	matcher *routeMatcher
+}
+
+// RouteRateLimit overrides the rate limit for a route, in the same format as SecurityOptions.OpenEndpoints.
+type RouteRateLimit struct {
+	Route string `json:"route"`
+	RateLimit
+}
+
+var (
+	RateLimitedCounterName = "http_server_requests_rate_limited_total"
+
+	rateLimited *prometheus.CounterVec
+)
+
+// rate limiting keys on the first of these that is known for the request
+const (
+	rateLimitKeySubject = "subject"
+	rateLimitKeyApiKey  = "api_key"
+	rateLimitKeyIP      = "ip"
+)
+
+// RateLimiting creates a middleware that limits the rate of requests per caller, answering 429 when exceeded.
+//
+// Place it after CheckRequestAuthorization, so the caller is known. Callers are identified by subject,
+// by api key name, or, for anonymous requests if enabled, by client ip address. Impersonating admins count as themselves.
+func RateLimiting(conf *RateLimitOptions) func(http.Handler) http.Handler {
+	if rateLimited == nil {
+		rateLimited = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: RateLimitedCounterName,
+				Help: "Number of incoming HTTP requests rejected by rate limiting, partitioned by method, HTTP path (grouped by patterns), and what the caller was identified by.",
+			},
+			[]string{"method", "uri", "key"},
+		)
+		prometheus.MustRegister(rateLimited)
+	}
+
+	if conf.Store == nil {
+		conf.Store = NewInMemoryRateLimitStore()
+	}
+	conf.compiledRoutes = make([]compiledRouteRateLimit, 0, len(conf.Routes))
+	for _, override := range conf.Routes {
+		matcher, err := newRouteMatcher([]string{override.Route})
+		if err != nil {
+			// config was validated, so this should only happen in tests
+			aulogging.Logger.NoCtx().Error().WithErr(err).Printf("invalid rate limit route, ignoring it: %s", err.Error())
+			continue
+		}
+		conf.compiledRoutes = append(conf.compiledRoutes, compiledRouteRateLimit{
+			RouteRateLimit: override,
+			matcher:        matcher,
+		})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			routePattern := requestRoutePattern(r)
+			bucketName, limit := conf.limitFor(r.Method, routePattern)
+			if limit.RequestsPerMinute <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			keyType, key := conf.rateLimitKey(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed, retryAfter, err := conf.Store.Take(ctx, fmt.Sprintf("%s %s:%s", bucketName, keyType, key), limit)
+			if err != nil {
+				// better to serve requests unlimited than to fail all of them
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("rate limit store failed, letting request through: %s", err.Error())
+			} else if !allowed {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				aulogging.Infof(ctx, "rate limit exceeded for %s %s on %s, retry after %d seconds", keyType, key, bucketName, seconds)
+				rateLimited.WithLabelValues(r.Method, routePattern, keyType).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				web.SendTooManyRequestsResponse(ctx, w, "rate limit exceeded, please slow down")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitFor finds the limit for a route, and the name of the buckets it uses.
+func (conf *RateLimitOptions) limitFor(method string, routePattern string) (string, RateLimit) {
+	for _, override := range conf.compiledRoutes {
+		if override.matcher.matches(method, routePattern) {
+			return override.Route, override.RateLimit
+		}
+	}
+	return "default", conf.Default
+}
+
+// rateLimitKey identifies the caller. The key is empty for anonymous callers unless they are limited.
+func (conf *RateLimitOptions) rateLimitKey(r *http.Request) (string, string) {
+	ctx := r.Context()
+	if subject := common.GetImpersonatorSubject(ctx); subject != "" {
+		return rateLimitKeySubject, subject
+	}
+	if subject := common.GetSubject(ctx); subject != "" {
+		return rateLimitKeySubject, subject
+	}
+	if name := common.GetAPIKeyName(ctx); name != "" {
+		return rateLimitKeyApiKey, name
+	}
+	if !conf.Anonymous {
+		return rateLimitKeyIP, ""
+	}
+	return rateLimitKeyIP, conf.clientIP(r)
+}
+
+// clientIP is the address of the peer, or, if the peer is a trusted proxy, the address the proxies forwarded the request for.
+//
+// X-Forwarded-For is read from the right, skipping trusted proxies, because clients can send the header with
+// any addresses they like, and proxies append to it.
+func (conf *RateLimitOptions) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !conf.trusted(host) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		host = address
+		if !conf.trusted(address) {
+			break
+		}
+	}
+	return host
+}
+
+func (conf *RateLimitOptions) trusted(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range conf.TrustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ConfRateLimitRequestsPerMinute = "RATE_LIMIT_REQUESTS_PER_MINUTE"
+	ConfRateLimitBurst             = "RATE_LIMIT_BURST"
+	ConfRateLimitRoutes            = "RATE_LIMIT_ROUTES"
+	ConfRateLimitAnonymous         = "RATE_LIMIT_ANONYMOUS"
+	ConfRateLimitTrustedProxies    = "RATE_LIMIT_TRUSTED_PROXIES"
+)
+
+func RateLimitConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfRateLimitRequestsPerMinute,
+			Default:     "600",
+			Description: "number of requests per minute each caller may make on average. Callers are identified by subject, api key name, or, if " + ConfRateLimitAnonymous + " is enabled, client ip address. Set to 0 to disable rate limiting.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1000000),
+		}, {
+			Key:         ConfRateLimitBurst,
+			Default:     "100",
+			Description: "number of requests each caller may make in quick succession before the rate limit applies.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 1000000),
+		}, {
+			Key:         ConfRateLimitRoutes,
+			Default:     "[]",
+			Description: "rate limits for individual routes. JSON list of {\"route\": \"METHOD PATTERN\", \"requests_per_minute\": ..., \"burst\": ...}, routes use the same format as OPEN_ENDPOINTS. The first matching entry applies, and requests to it do not count against the default limit. A requests_per_minute of 0 disables rate limiting for the route.",
+			Validate:    validateRateLimitRoutes,
+		}, {
+			Key:         ConfRateLimitAnonymous,
+			Default:     "0",
+			Description: "also rate limit anonymous callers, by client ip address. Off by default. Enable by setting this to '1'. Behind a reverse proxy or load balancer, also set " + ConfRateLimitTrustedProxies + ", or all anonymous callers share the address of the proxy, and a single busy client gets everyone else rejected.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1),
+		}, {
+			Key:         ConfRateLimitTrustedProxies,
+			Default:     "",
+			Description: "space separated list of networks in CIDR notation (such as 10.0.0.0/8) of the reverse proxies in front of the service. For requests from these, the client ip address for rate limiting is the rightmost address in the X-Forwarded-For header that is not a trusted proxy. If empty, the address of the peer is used.",
+			Validate:    validateTrustedProxies,
+		},
+	}
+}
+
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	result := make([]netip.Prefix, 0)
+	for _, network := range strings.Fields(value) {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return result, fmt.Errorf("failed to parse trusted proxy configuration: %w", err)
+		}
+		result = append(result, prefix.Masked())
+	}
+	return result, nil
+}
+
+func validateTrustedProxies(key string) error {
+	_, err := parseTrustedProxies(auconfigenv.Get(key))
+	return err
+}
+
+func parseRateLimitRoutes(value string) ([]RouteRateLimit, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	result := make([]RouteRateLimit, 0)
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to parse rate limit route configuration: %w", err)
+	}
+	for _, override := range result {
+		if _, err := newRouteMatcher([]string{override.Route}); err != nil {
+			return result, fmt.Errorf("failed to parse rate limit route configuration: %w", err)
+		}
+		if override.RequestsPerMinute < 0 || (override.RequestsPerMinute > 0 && override.Burst < 1) {
+			return result, fmt.Errorf("invalid rate limit for route '%s', burst must be at least 1", override.Route)
+		}
+	}
+	return result, nil
+}
+
+func validateRateLimitRoutes(key string) error {
+	_, err := parseRateLimitRoutes(auconfigenv.Get(key))
+	return err
+}
+
+func RateLimitOptionsFromConfig() RateLimitOptions {
+	requestsPerMinute, _ := auconfigenv.AToInt(auconfigenv.Get(ConfRateLimitRequestsPerMinute))
+	burst, _ := auconfigenv.AToInt(auconfigenv.Get(ConfRateLimitBurst))
+	routes, _ := parseRateLimitRoutes(auconfigenv.Get(ConfRateLimitRoutes))
+	trustedProxies, _ := parseTrustedProxies(auconfigenv.Get(ConfRateLimitTrustedProxies))
+	return RateLimitOptions{
+		Default: RateLimit{
+			RequestsPerMinute: requestsPerMinute,
+			Burst:             burst,
+		},
+		Routes:         routes,
+		Anonymous:      auconfigenv.Get(ConfRateLimitAnonymous) == "1",
+		TrustedProxies: trustedProxies,
+	}
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInMemoryRateLimitStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cut := NewInMemoryRateLimitStore().(*inMemoryRateLimitStore)
+	cut.now = func() time.Time {
+		return now
+	}
+	limit := RateLimit{RequestsPerMinute: 60, Burst: 2}
+
+	for range 2 {
+		allowed, _, err := cut.Take(context.Background(), "a", limit)
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	allowed, retryAfter, err := cut.Take(context.Background(), "a", limit)
+	require.NoError(t, err)
+	require.False(t, allowed, "the burst is used up")
+	require.Equal(t, time.Second, retryAfter)
+
+	allowed, _, _ = cut.Take(context.Background(), "b", limit)
+	require.True(t, allowed, "other keys must have their own bucket")
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, retryAfter, _ = cut.Take(context.Background(), "a", limit)
+	require.False(t, allowed)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _, _ = cut.Take(context.Background(), "a", limit)
+	require.True(t, allowed, "the bucket refills over time")
+}
+
+func TestInMemoryRateLimitStore_Sweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cut := NewInMemoryRateLimitStore().(*inMemoryRateLimitStore)
+	cut.now = func() time.Time {
+		return now
+	}
+	cut.sweepAt = 2
+	limit := RateLimit{RequestsPerMinute: 60, Burst: 1}
+
+	_, _, _ = cut.Take(context.Background(), "a", limit)
+	_, _, _ = cut.Take(context.Background(), "b", limit)
+	now = now.Add(time.Minute)
+	_, _, _ = cut.Take(context.Background(), "c", limit)
+
+	require.Len(t, cut.buckets, 1, "refilled buckets must be removed")
+}
+
+func TestRateLimiting(t *testing.T) {
+	conf := &RateLimitOptions{
+		Default:   RateLimit{RequestsPerMinute: 60, Burst: 2},
+		Anonymous: true,
+		Routes: []RouteRateLimit{
+			{Route: "POST /things/{id}", RateLimit: RateLimit{RequestsPerMinute: 60, Burst: 1}},
+			{Route: "GET /unlimited", RateLimit: RateLimit{RequestsPerMinute: 0}},
+			{Route: "GET /numbers/{id:[0-9]+}", RateLimit: RateLimit{RequestsPerMinute: 0}},
+			{Route: "GET /invalid/{id:[}", RateLimit: RateLimit{RequestsPerMinute: 60, Burst: 1}},
+		},
+	}
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if subject := r.Header.Get("Subject"); subject != "" {
+				ctx = context.WithValue(ctx, common.CtxKeyClaims{}, &common.AllClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}})
+			}
+			if name := r.Header.Get("Api-Key-Name"); name != "" {
+				ctx = context.WithValue(ctx, common.CtxKeyAPIKey{}, name)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Use(RateLimiting(conf))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Get("/things", ok)
+	router.Post("/things/{id}", ok)
+	router.Get("/unlimited", ok)
+	router.Get("/numbers/{id:[0-9]+}", ok)
+
+	perform := func(method string, path string, header string, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, caller := range [][]string{{"Subject", "101"}, {"Api-Key-Name", "backend"}, {"", ""}} {
+		for range 2 {
+			require.Equal(t, http.StatusOK, perform(http.MethodGet, "/things", caller[0], caller[1]).Code)
+		}
+		response := perform(http.MethodGet, "/things", caller[0], caller[1])
+		require.Equal(t, http.StatusTooManyRequests, response.Code, "caller %v must be limited", caller)
+		require.Equal(t, "1", response.Header().Get("Retry-After"))
+		require.Contains(t, response.Body.String(), `"message":"request.rate.limited"`)
+	}
+
+	require.Equal(t, http.StatusOK, perform(http.MethodPost, "/things/1", "Subject", "101").Code, "route overrides must have their own buckets")
+	require.Equal(t, http.StatusTooManyRequests, perform(http.MethodPost, "/things/2", "Subject", "101").Code, "route overrides apply to the route pattern")
+	require.Equal(t, http.StatusOK, perform(http.MethodPost, "/things/1", "Subject", "202").Code)
+
+	for range 5 {
+		require.Equal(t, http.StatusOK, perform(http.MethodGet, "/unlimited", "Subject", "101").Code)
+		require.Equal(t, http.StatusOK, perform(http.MethodGet, "/numbers/42", "Subject", "303").Code, "route overrides apply to routes with regex parameters")
+	}
+}
+
+func TestRateLimiting_AnonymousOffByDefault(t *testing.T) {
+	conf := &RateLimitOptions{
+		Default: RateLimit{RequestsPerMinute: 60, Burst: 1},
+	}
+	handler := RateLimiting(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for range 3 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/things", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestRateLimiting_ClientIP(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies("10.0.0.0/8 2001:db8::/32")
+	require.NoError(t, err)
+	conf := &RateLimitOptions{
+		Anonymous:      true,
+		TrustedProxies: trustedProxies,
+	}
+
+	testcases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1"},
+		{name: "untrusted_peer_ignores_header", remoteAddr: "192.0.2.1:1234", forwarded: []string{"198.51.100.7"}, expected: "192.0.2.1"},
+		{name: "trusted_proxy", remoteAddr: "10.1.2.3:1234", forwarded: []string{"198.51.100.7"}, expected: "198.51.100.7"},
+		{name: "spoofed_entries_ignored", remoteAddr: "10.1.2.3:1234", forwarded: []string{"203.0.113.9, 198.51.100.7"}, expected: "198.51.100.7"},
+		{name: "proxy_chain", remoteAddr: "10.1.2.3:1234", forwarded: []string{"198.51.100.7, 10.4.5.6", "10.7.8.9"}, expected: "198.51.100.7"},
+		{name: "ipv6_proxy", remoteAddr: "[2001:db8::1]:1234", forwarded: []string{"2001:db9::7"}, expected: "2001:db9::7"},
+		{name: "only_proxies", remoteAddr: "10.1.2.3:1234", forwarded: []string{"10.4.5.6"}, expected: "10.4.5.6"},
+		{name: "no_header", remoteAddr: "10.1.2.3:1234", expected: "10.1.2.3"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/things", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			keyType, key := conf.rateLimitKey(r)
+			require.Equal(t, rateLimitKeyIP, keyType)
+			require.Equal(t, tc.expected, key)
+		})
+	}
+
+	_, err = parseTrustedProxies("10.0.0.1")
+	require.EqualError(t, err, `failed to parse trusted proxy configuration: netip.ParsePrefix("10.0.0.1"): no '/'`)
+}
+
+func TestParseRateLimitRoutes(t *testing.T) {
+	testcases := []struct {
+		name      string
+		value     string
+		expectErr string
+	}{
+		{name: "default", value: `[]`},
+		{name: "routes", value: `[{"route": "POST /a/{b}", "requests_per_minute": 10, "burst": 2}, {"route": "GET /c", "requests_per_minute": 0}]`},
+		{name: "not_json", value: `GET /`, expectErr: "failed to parse rate limit route configuration: invalid character 'G' looking for beginning of value"},
+		{name: "unknown_field", value: `[{"route": "GET /", "rate": 1}]`, expectErr: `failed to parse rate limit route configuration: json: unknown field "rate"`},
+		{name: "invalid_route", value: `[{"route": "/a", "requests_per_minute": 10, "burst": 2}]`, expectErr: "failed to parse rate limit route configuration: invalid route '/a', format is 'METHOD PATTERN'"},
+		{name: "no_burst", value: `[{"route": "GET /a", "requests_per_minute": 10}]`, expectErr: "invalid rate limit for route 'GET /a', burst must be at least 1"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRateLimitRoutes(tc.value)
+			if tc.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: it holds up to Burst requests, and refills at RequestsPerMinute.
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+// RateLimitStore keeps the token buckets for all callers.
+//
+// The in-memory store limits each instance of the service separately. A store shared between instances
+// can be added later by implementing this interface.
+type RateLimitStore interface {
+	// Take removes one request from the bucket under key, creating a full bucket if there is none.
+	//
+	// If the bucket is empty, the request is not allowed, and retryAfter is how long until it holds a request again.
+	Take(ctx context.Context, key string, limit RateLimit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// rateLimitSweepMinimum is the number of buckets below which the in-memory store does not bother removing full buckets.
+const rateLimitSweepMinimum = 1024
+
+type bucket struct {
+	tokens    float64
+	updated   time.Time
+	perSecond float64
+	burst     int
+}
+
+type inMemoryRateLimitStore struct {
+	buckets map[string]*bucket
+	sweepAt int
+	mutex   sync.Mutex
+
+	now func() time.Time
+}
+
+func NewInMemoryRateLimitStore() RateLimitStore {
+	return &inMemoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		sweepAt: rateLimitSweepMinimum,
+		now:     timestamp.Now,
+	}
+}
+
+func (s *inMemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	perSecond := float64(limit.RequestsPerMinute) / 60
+
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= s.sweepAt {
+			s.sweep(now)
+		}
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	// remembered so sweep can tell when the bucket has refilled
+	b.perSecond = perSecond
+	b.burst = limit.Burst
+
+	b.tokens = b.refill(now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	missing := (1 - b.tokens) / perSecond
+	return false, time.Duration(math.Ceil(missing * float64(time.Second))), nil
+}
+
+func (b *bucket) refill(now time.Time) float64 {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(b.burst), b.tokens+elapsed*b.perSecond)
+}
+
+// sweep removes buckets that have refilled completely, as they are no different from a new bucket.
+//
+// Must be called with the mutex held.
+func (s *inMemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.refill(now) >= float64(b.burst) {
+			delete(s.buckets, key)
+		}
+	}
+	s.sweepAt = max(rateLimitSweepMinimum, 2*len(s.buckets))
+}
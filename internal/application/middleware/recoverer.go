@@ -1,27 +1,174 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"github.com/Roshick/go-autumn-slog/pkg/logging"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/errorreport"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
-	"runtime/debug"
+	"runtime"
+	"strings"
 )
 
-func PanicRecoverer(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			rvr := recover()
-			if rvr != nil && rvr != http.ErrAbortHandler {
+var PanicValueFieldName = "error.message"
+var PanicStackFieldName = "error.stack_trace"
+
+var (
+	PanicCounterName = "panics_total"
+
+	panics *prometheus.CounterVec
+)
+
+type RecovererOptions struct {
+	// Reporter receives a report for each recovered panic. Optional.
+	Reporter errorreport.Reporter
+}
+
+// PanicRecoverer creates a middleware that recovers from panics in later handlers.
+//
+// It logs the panic, counts it, and reports it to the configured Reporter. If the response has not
+// been started, it sends a 500 error response. Otherwise, it aborts the connection, because the
+// client has already received part of a response and could not tell it was incomplete.
+func PanicRecoverer(conf *RecovererOptions) func(http.Handler) http.Handler {
+	if panics == nil {
+		panics = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: PanicCounterName,
+				Help: "Number of panics recovered while processing incoming HTTP requests, partitioned by method and HTTP path (grouped by patterns).",
+			},
+			[]string{"method", "uri"},
+		)
+		prometheus.MustRegister(panics)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				if rvr == http.ErrAbortHandler {
+					// deliberate abort, see http.ErrAbortHandler
+					panic(rvr)
+				}
+
+				value, stack := rvr, []string(nil)
+				if p, ok := rvr.(*recoveredPanic); ok {
+					value, stack = p.value, p.stack
+				} else {
+					stack = panicStack(0)
+				}
+
 				ctx := r.Context()
-				stack := string(debug.Stack())
-				aulogging.Error(ctx, "recovered from PANIC: "+stack)
-				web.SendErrorWithStatusAndMessage(ctx, w, http.StatusInternalServerError, common.InternalErrorMessage, "")
-			}
-		}()
+				reportPanic(conf, r, value, stack)
+
+				if ww.Status() != 0 {
+					aulogging.Warnf(ctx, "response already started with status %d, aborting connection", ww.Status())
+					panic(http.ErrAbortHandler)
+				}
+				web.SendErrorWithStatusAndMessage(ctx, ww, http.StatusInternalServerError, common.InternalErrorMessage, "")
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), ctxKeyRecovererOptions{}, conf)))
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
+// reportPanic logs the panic, counts it, and reports it to the configured Reporter.
+func reportPanic(conf *RecovererOptions, r *http.Request, value any, stack []string) {
+	ctx := r.Context()
+	routePattern := requestRoutePattern(r)
+	panics.WithLabelValues(r.Method, routePattern).Inc()
+
+	logCtx := ctx
+	if logger := logging.FromContext(ctx); logger != nil {
+		logCtx = logging.ContextWithLogger(ctx, logger.With(PanicValueFieldName, fmt.Sprint(value), PanicStackFieldName, stack))
 	}
+	aulogging.Logger.Ctx(logCtx).Error().Printf("recovered from PANIC: %v", value)
 
-	return http.HandlerFunc(fn)
+	if conf != nil && conf.Reporter != nil {
+		report := errorreport.Report{
+			Timestamp: timestamp.Now(),
+			RequestID: common.GetRequestID(ctx),
+			Method:    r.Method,
+			Route:     routePattern,
+			Path:      r.URL.Path,
+			Message:   fmt.Sprint(value),
+			Stack:     stack,
+		}
+		if err := conf.Reporter.Report(ctx, report); err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to report panic: %s", err.Error())
+		}
+	}
+}
+
+type ctxKeyRecovererOptions struct{}
+
+// reportLatePanic handles a panic in a handler whose request has already been answered, like PanicRecoverer
+// would have, except that there is no response left to send.
+//
+// The panic is logged even without PanicRecoverer, but only counted and reported with it.
+func reportLatePanic(r *http.Request, rvr any) {
+	if rvr == http.ErrAbortHandler {
+		// nothing left to abort
+		return
+	}
+	p, ok := rvr.(*recoveredPanic)
+	if !ok {
+		p = &recoveredPanic{value: rvr}
+	}
+
+	// the request is over, but the report should still go out
+	r = r.WithContext(context.WithoutCancel(r.Context()))
+	conf, ok := r.Context().Value(ctxKeyRecovererOptions{}).(*RecovererOptions)
+	if !ok || panics == nil {
+		aulogging.Logger.Ctx(r.Context()).Error().Printf("recovered from PANIC after the request was answered: %v", p.value)
+		return
+	}
+	reportPanic(conf, r, p.value, p.stack)
+}
+
+// recoveredPanic carries a panic to another goroutine, keeping the stack where it happened.
+type recoveredPanic struct {
+	value any
+	stack []string
+}
+
+// wrapPanic wraps a panic so it can be passed to the request goroutine, see recoveredPanic.
+//
+// Must be called directly from the deferred function that recovered.
+func wrapPanic(rvr any) any {
+	if rvr == http.ErrAbortHandler {
+		return rvr
+	}
+	return &recoveredPanic{value: rvr, stack: panicStack(1)}
+}
+
+// panicStack lists the functions on the stack of a panicking goroutine, starting where the panic happened.
+//
+// Must be called directly from the deferred function that recovered, skipping any functions in between.
+func panicStack(skip int) []string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3+skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	result := make([]string, 0, n)
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			result = append(result, fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line))
+		}
+		if !more {
+			return result
+		}
+	}
 }
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/errorreport"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type tstReporter struct {
+	reports []errorreport.Report
+	err     error
+	mutex   sync.Mutex
+}
+
+func (r *tstReporter) Report(_ context.Context, report errorreport.Report) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.reports = append(r.reports, report)
+	return r.err
+}
+
+func tstRecovererRouter(reporter errorreport.Reporter) http.Handler {
+	router := chi.NewRouter()
+	router.Use(PanicRecoverer(&RecovererOptions{Reporter: reporter}))
+	router.Get("/tst/panic/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("oh no")
+	})
+	router.Get("/tst/panic-started", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"partial":`))
+		panic("oh no")
+	})
+	router.Get("/tst/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	return router
+}
+
+func TestPanicRecoverer_NotStarted(t *testing.T) {
+	reporter := &tstReporter{}
+	cut := tstRecovererRouter(reporter)
+	counter := panics.WithLabelValues(http.MethodGet, "/tst/panic/{id}")
+	before := testutil.ToFloat64(counter)
+
+	response := httptest.NewRecorder()
+	cut.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/tst/panic/4711", nil))
+
+	require.Equal(t, http.StatusInternalServerError, response.Code)
+	require.Contains(t, response.Body.String(), `"message":"error.internal"`)
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+
+	require.Len(t, reporter.reports, 1)
+	report := reporter.reports[0]
+	require.Equal(t, "oh no", report.Message)
+	require.Equal(t, http.MethodGet, report.Method)
+	require.Equal(t, "/tst/panic/{id}", report.Route)
+	require.Equal(t, "/tst/panic/4711", report.Path)
+	require.Contains(t, report.Stack[0], "tstRecovererRouter", "the stack must start where the panic happened")
+}
+
+func TestPanicRecoverer_Started(t *testing.T) {
+	reporter := &tstReporter{}
+	cut := tstRecovererRouter(reporter)
+
+	response := httptest.NewRecorder()
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		cut.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/tst/panic-started", nil))
+	}, "must abort the connection if the response has been started")
+
+	require.Equal(t, `{"partial":`, response.Body.String(), "must not append an error response")
+	require.Len(t, reporter.reports, 1)
+}
+
+func TestPanicRecoverer_Abort(t *testing.T) {
+	reporter := &tstReporter{}
+	cut := tstRecovererRouter(reporter)
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		cut.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tst/abort", nil))
+	})
+	require.Empty(t, reporter.reports, "deliberate aborts must not be reported")
+}
+
+func TestPanicRecoverer_ReporterFails(t *testing.T) {
+	reporter := &tstReporter{err: errors.New("reporting is down")}
+	cut := tstRecovererRouter(reporter)
+
+	response := httptest.NewRecorder()
+	cut.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/tst/panic/4711", nil))
+
+	require.Equal(t, http.StatusInternalServerError, response.Code)
+}
+
+func TestPanicRecoverer_Timeout(t *testing.T) {
+	reporter := &tstReporter{}
+	router := chi.NewRouter()
+	router.Use(PanicRecoverer(&RecovererOptions{Reporter: reporter}))
+	router.Use(Timeout(time.Second))
+	router.Get("/tst/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("oh no")
+	})
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/tst/panic", nil))
+
+	require.Equal(t, http.StatusInternalServerError, response.Code)
+	require.Len(t, reporter.reports, 1)
+	require.Equal(t, "oh no", reporter.reports[0].Message)
+	require.Equal(t, "/tst/panic", reporter.reports[0].Route)
+	require.Contains(t, reporter.reports[0].Stack[0], "TestPanicRecoverer_Timeout", "the stack must start where the panic happened")
+}
+
+func TestPanicRecoverer_TimeoutLate(t *testing.T) {
+	reporter := &tstReporter{}
+	release := make(chan struct{})
+	router := chi.NewRouter()
+	router.Use(PanicRecoverer(&RecovererOptions{Reporter: reporter}))
+	router.Use(Timeout(20 * time.Millisecond))
+	router.Get("/tst/panic-late/{id}", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		panic("oh no, too late")
+	})
+
+	counter := panics.WithLabelValues(http.MethodGet, "/tst/panic-late/{id}")
+	before := testutil.ToFloat64(counter)
+
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/tst/panic-late/42", nil))
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		reporter.mutex.Lock()
+		defer reporter.mutex.Unlock()
+		return len(reporter.reports) == 1
+	}, time.Second, 5*time.Millisecond, "panics after the timeout must still be reported")
+	require.Equal(t, "oh no, too late", reporter.reports[0].Message)
+	require.Equal(t, "/tst/panic-late/{id}", reporter.reports[0].Route)
+	require.Equal(t, "/tst/panic-late/42", reporter.reports[0].Path)
+	require.Contains(t, reporter.reports[0].Stack[0], "TestPanicRecoverer_TimeoutLate", "the stack must start where the panic happened")
+	require.Equal(t, before+1, testutil.ToFloat64(counter))
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+}
@@ -2,41 +2,121 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
 	"regexp"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
 var RequestIDHeader = "X-Request-Id"
 
-var ValidRequestIdRegex = regexp.MustCompile("^[0-9a-f]{8}$")
+// truncatedRequestIDLength is the length of request ids if RequestIDOptions.Truncate is set.
+const truncatedRequestIDLength = 8
 
-// RequestID obtains the request id from the request header, or failing that, creates a new request id,
+type RequestIDOptions struct {
+	// Accepted matches the request ids accepted from callers. Other values are replaced by a new request id.
+	Accepted *regexp.Regexp
+
+	// Truncate shortens request ids that are generated or derived from a trace id to 8 characters,
+	// which are easy to read out, but may collide after some tens of thousands of requests.
+	Truncate bool
+}
+
+// RequestID obtains the request id from the request headers, or failing that, creates a new request id,
 // and places it in the request context.
 //
-// It also adds it to the response under the same header.
+// If the request is part of a W3C trace, the request id is derived from the trace id, so it is the same
+// in all services taking part in the trace. Otherwise, an accepted X-Request-Id header is used.
+//
+// It also adds it to the response under the X-Request-Id header.
 //
 // This automatically also leads to all logging using this context to log the request id.
-func RequestID(next http.Handler) http.Handler {
-	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
-		reqUuidStr := r.Header.Get(RequestIDHeader)
-		if !ValidRequestIdRegex.MatchString(reqUuidStr) {
-			reqUuid, err := uuid.NewRandom()
-			if err == nil {
-				reqUuidStr = reqUuid.String()[:8]
-			} else {
-				// this should not normally ever happen, but continue with this fixed requestId
-				reqUuidStr = "ffffffff"
+func RequestID(conf *RequestIDOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+			reqUuidStr := conf.fromTraceparent(r)
+			if reqUuidStr == "" {
+				reqUuidStr = r.Header.Get(RequestIDHeader)
+				if reqUuidStr == "" || conf.Accepted == nil || !conf.Accepted.MatchString(reqUuidStr) {
+					reqUuidStr = conf.generate()
+				}
 			}
+			ctx := r.Context()
+			newCtx := context.WithValue(ctx, common.CtxKeyRequestID{}, reqUuidStr)
+			r = r.WithContext(newCtx)
+			w.Header().Add(RequestIDHeader, reqUuidStr)
+
+			next.ServeHTTP(w, r)
 		}
-		ctx := r.Context()
-		newCtx := context.WithValue(ctx, common.CtxKeyRequestID{}, reqUuidStr)
-		r = r.WithContext(newCtx)
-		w.Header().Add(RequestIDHeader, reqUuidStr)
+		return http.HandlerFunc(handlerFunc)
+	}
+}
+
+func (o *RequestIDOptions) fromTraceparent(r *http.Request) string {
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(r.Header))
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ""
+	}
+	return o.shorten(spanContext.TraceID().String())
+}
+
+func (o *RequestIDOptions) generate() string {
+	reqUuid, err := uuid.NewRandom()
+	if err != nil {
+		// this should not normally ever happen, but continue with this fixed requestId
+		return "ffffffff"
+	}
+	// same format as trace ids
+	return o.shorten(strings.ReplaceAll(reqUuid.String(), "-", ""))
+}
+
+func (o *RequestIDOptions) shorten(id string) string {
+	if o.Truncate {
+		return id[:truncatedRequestIDLength]
+	}
+	return id
+}
+
+const (
+	ConfRequestIDPattern  = "REQUEST_ID_PATTERN"
+	ConfRequestIDTruncate = "REQUEST_ID_TRUNCATE"
+)
+
+func RequestIDConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfRequestIDPattern,
+			Default:     "^[0-9a-zA-Z-]{8,64}$",
+			Description: "regular expression for request ids accepted from callers in the " + RequestIDHeader + " header. Other values are replaced by a new request id. Request ids derived from a traceparent header are always accepted.",
+			Validate:    validateRequestIDPattern,
+		}, {
+			Key:         ConfRequestIDTruncate,
+			Default:     "0",
+			Description: "shorten new request ids to 8 characters, which are easier to read out, but may collide. Off by default, so request ids have the same 32 characters as trace ids. Enable by setting this to '1'.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1),
+		},
+	}
+}
+
+func validateRequestIDPattern(key string) error {
+	if _, err := regexp.Compile(auconfigenv.Get(key)); err != nil {
+		return fmt.Errorf("invalid request id pattern: %w", err)
+	}
+	return nil
+}
 
-		next.ServeHTTP(w, r)
+func RequestIDOptionsFromConfig() RequestIDOptions {
+	accepted, _ := regexp.Compile(auconfigenv.Get(ConfRequestIDPattern))
+	return RequestIDOptions{
+		Accepted: accepted,
+		Truncate: auconfigenv.Get(ConfRequestIDTruncate) == "1",
 	}
-	return http.HandlerFunc(handlerFunc)
 }
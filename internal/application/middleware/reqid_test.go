@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	accepted := regexp.MustCompile("^[0-9a-zA-Z-]{8,64}$")
+	tests := []struct {
+		name        string
+		truncate    bool
+		requestID   string
+		traceparent string
+		want        string
+		wantPattern string
+	}{
+		{name: "short id", requestID: "a8b7c6d5", want: "a8b7c6d5"},
+		{name: "uuid", requestID: "5b0a4b5e-8a4e-4ef3-9c3c-43e0c6fb7a0d", want: "5b0a4b5e-8a4e-4ef3-9c3c-43e0c6fb7a0d"},
+		{name: "invalid id", requestID: "a8b7\nc6d5", wantPattern: "^[0-9a-f]{32}$"},
+		{name: "no id", wantPattern: "^[0-9a-f]{32}$"},
+		{name: "no id truncated", truncate: true, wantPattern: "^[0-9a-f]{8}$"},
+		{name: "traceparent", traceparent: traceparent, want: "0af7651916cd43dd8448eb211c80319c"},
+		{name: "traceparent truncated", truncate: true, traceparent: traceparent, want: "0af76519"},
+		{name: "traceparent wins", requestID: "a8b7c6d5", traceparent: traceparent, want: "0af7651916cd43dd8448eb211c80319c"},
+		{name: "invalid traceparent", requestID: "a8b7c6d5", traceparent: "00-00000000000000000000000000000000-b7ad6b7169203331-01", want: "a8b7c6d5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := ""
+			cut := RequestID(&RequestIDOptions{Accepted: accepted, Truncate: tt.truncate})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actual = common.GetRequestID(r.Context())
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.requestID != "" {
+				request.Header[RequestIDHeader] = []string{tt.requestID}
+			}
+			if tt.traceparent != "" {
+				request.Header.Set("traceparent", tt.traceparent)
+			}
+			response := httptest.NewRecorder()
+			cut.ServeHTTP(response, request)
+
+			if tt.want != "" {
+				require.Equal(t, tt.want, actual)
+			} else {
+				require.Regexp(t, tt.wantPattern, actual)
+			}
+			require.Equal(t, actual, response.Header().Get(RequestIDHeader))
+		})
+	}
+}
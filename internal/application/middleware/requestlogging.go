@@ -1,13 +1,18 @@
 package middleware
 
 import (
+	"context"
 	"github.com/Roshick/go-autumn-slog/pkg/logging"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	applogging "github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var NanosFieldName = "event.duration"
@@ -24,6 +29,9 @@ func RequestLogger(next http.Handler) http.Handler {
 
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
+		fields := &requestLogFields{}
+		r = r.WithContext(context.WithValue(ctx, ctxKeyRequestLogFields{}, fields))
+
 		start := time.Now()
 		aulogging.Debugf(ctx, "received request %s %s", method, path)
 
@@ -32,6 +40,7 @@ func RequestLogger(next http.Handler) http.Handler {
 			status := ww.Status()
 
 			logger := logging.FromContext(ctx)
+			logger = logger.With(fields.get()...)
 			logger = logger.With(NanosFieldName, elapsed, StatusFieldName, status)
 			newCtx := logging.ContextWithLogger(ctx, logger)
 			aulogging.Infof(newCtx, "request %s %s -> %d (%d ms)", method, path, status, elapsed/1000000)
@@ -44,12 +53,18 @@ func RequestLogger(next http.Handler) http.Handler {
 }
 
 var RequestIdFieldName = "http.request.id"
+var ApiKeyNameFieldName = "auth.api_key.name"
+var SubjectFieldName = "user.id"
+var EffectiveSubjectFieldName = "user.effective.id"
 var MethodFieldName = "http.request.method"
 var PathFieldName = "url.path"
+var TraceIdFieldName = "trace.id"
+var SpanIdFieldName = "span.id"
 
 // AddRequestScopedLoggerToContext adds a request scoped logger to the context.
 //
-// Place it below RequestIdMiddleware and the logger will include the request id.
+// Place it below RequestIdMiddleware and the logger will include the request id,
+// and below Tracing and it will include the trace and span ids.
 //
 // This ensures all intermediate log messages also list the request id, request method,
 // and path. If this middleware isn't present, then only the
@@ -67,9 +82,98 @@ func AddRequestScopedLoggerToContext(next http.Handler) http.Handler {
 			logger = logger.With(RequestIdFieldName, requestId)
 		}
 
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			logger = logger.With(
+				TraceIdFieldName, spanContext.TraceID().String(),
+				SpanIdFieldName, spanContext.SpanID().String(),
+			)
+		}
+
 		newCtx := logging.ContextWithLogger(ctx, logger)
+		newCtx = applyLogLevelOverrides(newCtx, r, false)
 
 		next.ServeHTTP(w, r.WithContext(newCtx))
 	}
 	return http.HandlerFunc(fn)
 }
+
+type ctxKeyRequestLogFields struct{}
+
+// requestLogFields collects fields that inner middlewares learn about the request, such as who made it,
+// so RequestLogger can include them in its log line, even though it only has the outer context.
+type requestLogFields struct {
+	fields []any
+	mu     sync.Mutex
+}
+
+func (f *requestLogFields) add(key string, value any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fields = append(f.fields, key, value)
+}
+
+func (f *requestLogFields) get() []any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.fields
+}
+
+// applyLogLevelOverrides lowers the minimum level of the request scoped logger if an override
+// configured through the admin endpoint matches the request.
+//
+// Overrides for a subject can only match once the request has been authenticated, so they are
+// applied separately by CheckRequestAuthorization, with authenticated set. All other overrides are
+// applied before that, so they also cover the messages logged before authentication.
+func applyLogLevelOverrides(ctx context.Context, r *http.Request, authenticated bool) context.Context {
+	logger := logging.FromContext(ctx)
+	if logger == nil {
+		return ctx
+	}
+
+	routePattern := ""
+	routePatternKnown := false
+	subject := common.GetSubject(ctx)
+	impersonator := common.GetImpersonatorSubject(ctx)
+	requestID := common.GetRequestID(ctx)
+	level, found := applogging.OverrideLevel(func(o applogging.Override) bool {
+		if (o.Subject != "") != authenticated {
+			return false
+		}
+		if o.Subject != "" && o.Subject != subject && o.Subject != impersonator {
+			return false
+		}
+		if o.RequestID != "" && o.RequestID != requestID {
+			return false
+		}
+		if o.Route != "" {
+			if !routePatternKnown {
+				routePattern = requestRoutePattern(r)
+				routePatternKnown = true
+			}
+			if o.MatchesRoute == nil || !o.MatchesRoute(r.Method, routePattern) {
+				return false
+			}
+		}
+		return true
+	})
+	if !found || logger.Enabled(ctx, level) {
+		return ctx
+	}
+	return logging.ContextWithLogger(ctx, applogging.WithLevel(logger, level))
+}
+
+// addFieldToRequestLogger adds a field to the request scoped logger, so all further log messages
+// for this request include it, and so does the log line written by RequestLogger.
+func addFieldToRequestLogger(ctx context.Context, key string, value any) context.Context {
+	if fields, ok := ctx.Value(ctxKeyRequestLogFields{}).(*requestLogFields); ok {
+		fields.add(key, value)
+	}
+
+	logger := logging.FromContext(ctx)
+	if logger == nil {
+		return ctx
+	}
+	return logging.ContextWithLogger(ctx, logger.With(key, value))
+}
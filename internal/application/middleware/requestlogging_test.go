@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"github.com/Roshick/go-autumn-slog/pkg/logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	applogging "github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddRequestScopedLoggerToContext_Overrides(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(applogging.NewLevelHandler(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	defer slog.SetDefault(previousLogger)
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), common.CtxKeyRequestID{}, "tstreqid")
+			ctx = context.WithValue(ctx, common.CtxKeyClaims{}, &common.AllClaims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "1234"},
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Use(AddRequestScopedLoggerToContext)
+	// stands in for CheckRequestAuthorization
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(applyLogLevelOverrides(r.Context(), r, true)))
+		})
+	})
+	router.Get("/tst/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Debug("tst debug")
+		logging.FromContext(r.Context()).With("tst.field", "kept").Debug("tst debug with field")
+	})
+
+	testcases := []struct {
+		name       string
+		override   applogging.Override
+		uncompiled bool
+		expected   bool
+	}{
+		{name: "none", expected: false},
+		{name: "subject", override: applogging.Override{Subject: "1234"}, expected: true},
+		{name: "other_subject", override: applogging.Override{Subject: "5678"}, expected: false},
+		{name: "route", override: applogging.Override{Route: "GET /tst/{id}"}, expected: true},
+		{name: "route_any_method", override: applogging.Override{Route: "* /tst/{id}"}, expected: true},
+		{name: "other_method", override: applogging.Override{Route: "POST /tst/{id}"}, expected: false},
+		{name: "request_id", override: applogging.Override{RequestID: "tstreqid"}, expected: true},
+		{name: "all_criteria", override: applogging.Override{Subject: "1234", Route: "GET /tst/{id}", RequestID: "tstreqid"}, expected: true},
+		{name: "one_criterion_fails", override: applogging.Override{Subject: "1234", RequestID: "other"}, expected: false},
+		{name: "less_verbose", override: applogging.Override{Subject: "1234", Level: slog.LevelWarn}, expected: false},
+		{name: "route_not_compiled", override: applogging.Override{Route: "GET /tst/{id}"}, uncompiled: true, expected: false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.Reset()
+			if tc.name != "none" {
+				override := tc.override
+				if override.Level == 0 {
+					override.Level = slog.LevelDebug
+				}
+				if override.Route != "" && !tc.uncompiled {
+					var err error
+					override.MatchesRoute, err = NewLogLevelRouteMatcher(override.Route)
+					require.NoError(t, err)
+				}
+				override = applogging.AddOverride(override)
+				defer applogging.RemoveOverride(override.ID)
+			}
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tst/4711", nil))
+
+			if tc.expected {
+				require.Contains(t, logs.String(), `"msg":"tst debug"`)
+				require.Contains(t, logs.String(), `"tst.field":"kept"`)
+			} else {
+				require.Empty(t, logs.String())
+			}
+		})
+	}
+}
+
+func TestAddRequestScopedLoggerToContext_SubjectOverrideKeepsLowerLevel(t *testing.T) {
+	logs := &bytes.Buffer{}
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(applogging.NewLevelHandler(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	defer slog.SetDefault(previousLogger)
+
+	matchesRoute, err := NewLogLevelRouteMatcher("GET /tst/{id}")
+	require.NoError(t, err)
+	byRoute := applogging.AddOverride(applogging.Override{Route: "GET /tst/{id}", MatchesRoute: matchesRoute, Level: slog.LevelDebug})
+	defer applogging.RemoveOverride(byRoute.ID)
+	bySubject := applogging.AddOverride(applogging.Override{Subject: "1234", Level: slog.LevelInfo - 1})
+	defer applogging.RemoveOverride(bySubject.ID)
+
+	router := chi.NewRouter()
+	router.Use(AddRequestScopedLoggerToContext)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), common.CtxKeyClaims{}, &common.AllClaims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "1234"},
+			})
+			next.ServeHTTP(w, r.WithContext(applyLogLevelOverrides(ctx, r, true)))
+		})
+	})
+	router.Get("/tst/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Debug("tst debug")
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tst/4711", nil))
+
+	require.Contains(t, logs.String(), `"msg":"tst debug"`, "a subject override must not raise the level set by a route override")
+}
+
+func TestNewLogLevelRouteMatcher_Invalid(t *testing.T) {
+	for _, value := range []string{"/tst", "GET tst", "GET /tst/{id", "GET /tst extra"} {
+		_, err := NewLogLevelRouteMatcher(value)
+		require.Error(t, err, value)
+	}
+}
@@ -1,12 +1,14 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,8 +16,16 @@ var (
 	RequestCounterName  = "http_server_requests_seconds_count"
 	RequestDurationName = "http_server_requests_seconds_sum"
 
+	InFlightGaugeName         = "http_server_requests_in_flight"
+	ConcurrencyLimitGaugeName = "http_server_requests_concurrency_limit"
+	ShedCounterName           = "http_server_requests_shed_total"
+
 	reqs    *prometheus.CounterVec
 	latency *prometheus.SummaryVec
+
+	inFlight         *prometheus.GaugeVec
+	concurrencyLimit *prometheus.GaugeVec
+	shed             *prometheus.CounterVec
 )
 
 func RequestMetrics() func(http.Handler) http.Handler {
@@ -44,18 +54,60 @@ func RequestMetrics() func(http.Handler) http.Handler {
 	return recordRequestMetrics
 }
 
+// registerConcurrencyMetrics sets up the metrics of ConcurrencyLimiting.
+func registerConcurrencyMetrics() {
+	if inFlight == nil {
+		inFlight = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: InFlightGaugeName,
+				Help: "Number of incoming HTTP requests currently being processed, partitioned by concurrency group (global counts all requests).",
+			},
+			[]string{"group"},
+		)
+		prometheus.MustRegister(inFlight)
+	}
+
+	if concurrencyLimit == nil {
+		concurrencyLimit = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: ConcurrencyLimitGaugeName,
+				Help: "Current concurrency limit, partitioned by concurrency group. Only changes if adaptive limits are enabled.",
+			},
+			[]string{"group"},
+		)
+		prometheus.MustRegister(concurrencyLimit)
+	}
+
+	if shed == nil {
+		shed = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: ShedCounterName,
+				Help: "Number of incoming HTTP requests rejected because too many were in progress, partitioned by the concurrency group whose limit was reached, method and HTTP path (grouped by patterns).",
+			},
+			[]string{"group", "method", "uri"},
+		)
+		prometheus.MustRegister(shed)
+	}
+}
+
 func recordRequestMetrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
-		next.ServeHTTP(ww, r)
+		timedOut := &atomic.Bool{}
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), ctxKeyRequestTimedOut{}, timedOut)))
 
 		rctx := chi.RouteContext(r.Context())
 		routePattern := strings.Join(rctx.RoutePatterns, "")
 		routePattern = strings.Replace(routePattern, "/*/", "/", -1)
 
-		reqs.WithLabelValues(r.Method, outcome(ww.Status()), fmt.Sprintf("%d", ww.Status()), routePattern).Inc()
-		latency.WithLabelValues(r.Method, outcome(ww.Status()), fmt.Sprintf("%d", ww.Status()), routePattern).Observe(float64(time.Since(start).Microseconds()) / 1000000)
+		result := outcome(ww.Status())
+		if timedOut.Load() {
+			result = "TIMEOUT"
+		}
+
+		reqs.WithLabelValues(r.Method, result, fmt.Sprintf("%d", ww.Status()), routePattern).Inc()
+		latency.WithLabelValues(r.Method, result, fmt.Sprintf("%d", ww.Status()), routePattern).Observe(float64(time.Since(start).Microseconds()) / 1000000)
 	})
 }
 
@@ -68,3 +120,13 @@ func outcome(status int) string {
 		return "SERVER_ERROR"
 	}
 }
+
+type ctxKeyRequestTimedOut struct{}
+
+// markRequestTimedOut lets RequestMetrics know the request timed out, so it can be counted
+// separately from other server errors.
+func markRequestTimedOut(ctx context.Context) {
+	if timedOut, ok := ctx.Value(ctxKeyRequestTimedOut{}).(*atomic.Bool); ok {
+		timedOut.Store(true)
+	}
+}
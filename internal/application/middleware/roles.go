@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RoleDefinition maps identity provider groups to a named application role.
+//
+// Example: {"groups": ["b0a3e1c2"], "permissions": ["attendee.read", "attendee.write"]}
+type RoleDefinition struct {
+	// Groups are the group ids from the identity provider whose members have the role.
+	//
+	// For requests authorized by api key, the groups configured for the key are used.
+	Groups []string `json:"groups"`
+
+	// Permissions are granted to everyone who has the role.
+	Permissions []string `json:"permissions"`
+}
+
+var validRoleName = regexp.MustCompile("^[a-z][a-z0-9-]*$")
+
+func parseRoles(value string) (map[string]RoleDefinition, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	result := make(map[string]RoleDefinition)
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to parse role configuration: %w", err)
+	}
+	for name, role := range result {
+		if !validRoleName.MatchString(name) {
+			return result, fmt.Errorf("invalid role name '%s'", name)
+		}
+		for _, permission := range role.Permissions {
+			if permission == "" || strings.ContainsAny(permission, " \t") {
+				return result, fmt.Errorf("invalid permission '%s' for role %s", permission, name)
+			}
+		}
+	}
+	return result, nil
+}
+
+func validateRoles(key string) error {
+	_, err := parseRoles(auconfigenv.Get(key))
+	return err
+}
+
+// resolveRoles determines the roles and permissions of the caller from their groups, and places them in the context.
+//
+// Both lists are sorted, so they read the same in every log line and response.
+func resolveRoles(ctx context.Context, roles map[string]RoleDefinition) context.Context {
+	roleNames := make([]string, 0)
+	permissionSet := make(map[string]struct{})
+	for name, role := range roles {
+		if !hasAnyGroup(ctx, role.Groups) {
+			continue
+		}
+		roleNames = append(roleNames, name)
+		for _, permission := range role.Permissions {
+			permissionSet[permission] = struct{}{}
+		}
+	}
+	permissions := make([]string, 0, len(permissionSet))
+	for permission := range permissionSet {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(roleNames)
+	sort.Strings(permissions)
+
+	ctx = context.WithValue(ctx, common.CtxKeyRoles{}, roleNames)
+	ctx = context.WithValue(ctx, common.CtxKeyPermissions{}, permissions)
+	return ctx
+}
+
+func hasAnyGroup(ctx context.Context, groups []string) bool {
+	for _, group := range groups {
+		if common.HasGroup(ctx, group) {
+			return true
+		}
+	}
+	return false
+}
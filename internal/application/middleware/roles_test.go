@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseRoles(t *testing.T) {
+	testcases := []struct {
+		name      string
+		value     string
+		expectErr string
+	}{
+		{name: "default", value: `{}`},
+		{name: "roles", value: `{"admin": {"groups": ["a1"], "permissions": ["attendee.read"]}, "reg-desk": {"groups": ["b2"]}}`},
+		{name: "not_json", value: `admin`, expectErr: "failed to parse role configuration: invalid character 'a' looking for beginning of value"},
+		{name: "unknown_field", value: `{"admin": {"group": ["a1"]}}`, expectErr: `failed to parse role configuration: json: unknown field "group"`},
+		{name: "invalid_name", value: `{"Admin": {}}`, expectErr: "invalid role name 'Admin'"},
+		{name: "invalid_permission", value: `{"admin": {"permissions": ["attendee read"]}}`, expectErr: "invalid permission 'attendee read' for role admin"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRoles(tc.value)
+			if tc.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestResolveRoles(t *testing.T) {
+	roles := map[string]RoleDefinition{
+		"admin":   {Groups: []string{"g-admin"}, Permissions: []string{"attendee.read", "attendee.write"}},
+		"staff":   {Groups: []string{"g-staff", "g-helper"}, Permissions: []string{"attendee.read"}},
+		"regdesk": {Groups: []string{"g-regdesk"}, Permissions: []string{"attendee.checkin"}},
+	}
+	withGroups := func(groups ...string) context.Context {
+		return context.WithValue(context.Background(), common.CtxKeyClaims{}, &common.AllClaims{
+			CustomClaims: common.CustomClaims{Groups: groups},
+		})
+	}
+
+	testcases := []struct {
+		name              string
+		ctx               context.Context
+		expectRoles       []string
+		expectPermissions []string
+	}{
+		{name: "no_groups", ctx: withGroups(), expectRoles: []string{}, expectPermissions: []string{}},
+		{name: "unmapped_group", ctx: withGroups("g-other"), expectRoles: []string{}, expectPermissions: []string{}},
+		{name: "one_role", ctx: withGroups("g-helper"), expectRoles: []string{"staff"}, expectPermissions: []string{"attendee.read"}},
+		{name: "combined", ctx: withGroups("g-staff", "g-admin", "g-regdesk"), expectRoles: []string{"admin", "regdesk", "staff"}, expectPermissions: []string{"attendee.checkin", "attendee.read", "attendee.write"}},
+		{
+			name:              "api_key_groups",
+			ctx:               context.WithValue(context.Background(), common.CtxKeyAPIKeyGroups{}, []string{"g-regdesk"}),
+			expectRoles:       []string{"regdesk"},
+			expectPermissions: []string{"attendee.checkin"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := resolveRoles(tc.ctx, roles)
+			require.Equal(t, tc.expectRoles, common.GetRoles(ctx))
+			require.Equal(t, tc.expectPermissions, common.GetPermissions(ctx))
+			for _, permission := range tc.expectPermissions {
+				require.True(t, common.HasPermission(ctx, permission))
+			}
+			require.False(t, common.HasPermission(ctx, "attendee.delete"))
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	applogging "github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// anyMethod in a route entry matches all http methods.
+const anyMethod = "*"
+
+// routeMatcher decides whether a request targets one of a list of configured routes, such as the
+// open endpoints, or the routes an api key may access.
+//
+// Configured entries use chi pattern syntax, and they are compared against the route pattern
+// chi matches for the request, never against the raw URL. This way, trailing slashes or url encoding
+// cannot be used to reach a protected route through an entry meant for a different one.
+//
+// Patterns are compared segment by segment: a literal only matches the same literal, a parameter
+// matches any parameter or literal (and, if it has a regular expression, only parameters with the
+// same expression or literals it matches), and a trailing '*' matches all remaining segments.
+type routeMatcher struct {
+	entries []routeEntry
+}
+
+type routeEntry struct {
+	method   string
+	pattern  string
+	segments []routeSegment
+}
+
+// routeSegment is one path segment of a configured pattern.
+type routeSegment struct {
+	literal  string
+	param    bool
+	regex    string
+	compiled *regexp.Regexp
+	wildcard bool
+}
+
+func (e routeEntry) String() string {
+	return fmt.Sprintf("%s %s", e.method, e.pattern)
+}
+
+func parseRouteEntry(value string) (routeEntry, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return routeEntry{}, fmt.Errorf("invalid route '%s', format is 'METHOD PATTERN'", value)
+	}
+
+	method := strings.ToUpper(fields[0])
+	pattern := fields[1]
+	if !strings.HasPrefix(pattern, "/") {
+		return routeEntry{}, fmt.Errorf("invalid route '%s', pattern must start with '/'", value)
+	}
+
+	entry := routeEntry{
+		method:  method,
+		pattern: normalizeRoutePattern(pattern),
+	}
+	for _, segment := range splitRoutePattern(entry.pattern) {
+		parsed, err := parseRouteSegment(segment)
+		if err != nil {
+			return routeEntry{}, fmt.Errorf("invalid route '%s': %w", value, err)
+		}
+		entry.segments = append(entry.segments, parsed)
+	}
+	return entry, nil
+}
+
+func splitRoutePattern(pattern string) []string {
+	return strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+}
+
+func parseRouteSegment(segment string) (routeSegment, error) {
+	if segment == "*" {
+		return routeSegment{wildcard: true}, nil
+	}
+	if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") || strings.Count(segment, "{") != 1 {
+		return routeSegment{literal: segment}, nil
+	}
+
+	result := routeSegment{param: true}
+	if _, regex, found := strings.Cut(segment[1:len(segment)-1], ":"); found {
+		compiled, err := regexp.Compile("^(?:" + regex + ")$")
+		if err != nil {
+			return routeSegment{}, err
+		}
+		result.regex = regex
+		result.compiled = compiled
+	}
+	return result, nil
+}
+
+// matches is true if a segment of a registered route pattern is covered by this configured segment.
+func (s routeSegment) matches(routeSegment string) bool {
+	if !s.param {
+		return s.literal == routeSegment
+	}
+	if routeSegment == "*" || routeSegment == "" {
+		return false
+	}
+	other, err := parseRouteSegment(routeSegment)
+	if err != nil {
+		return false
+	}
+	if !other.param {
+		return s.compiled == nil || s.compiled.MatchString(routeSegment)
+	}
+	return s.regex == "" || s.regex == other.regex
+}
+
+func (e routeEntry) matches(method string, routePattern string) bool {
+	if e.method != anyMethod && e.method != strings.ToUpper(method) {
+		return false
+	}
+
+	routeSegments := splitRoutePattern(routePattern)
+	for index, segment := range e.segments {
+		if index >= len(routeSegments) {
+			return false
+		}
+		// chi only allows '*' at the end
+		if segment.wildcard {
+			return true
+		}
+		if !segment.matches(routeSegments[index]) {
+			return false
+		}
+	}
+	return len(routeSegments) == len(e.segments)
+}
+
+func newRouteMatcher(values []string) (*routeMatcher, error) {
+	result := &routeMatcher{
+		entries: make([]routeEntry, 0, len(values)),
+	}
+	// chi validates methods and patterns when registering them
+	validation := chi.NewMux()
+	for _, value := range values {
+		entry, err := parseRouteEntry(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := registerRouteEntry(validation, entry); err != nil {
+			return nil, err
+		}
+		result.entries = append(result.entries, entry)
+	}
+	return result, nil
+}
+
+// NewLogLevelRouteMatcher validates a route for a log level override, in the same format as configured
+// routes, such as 'GET /api/rest/v1/example/{category}', and compiles it.
+func NewLogLevelRouteMatcher(value string) (applogging.RouteMatcher, error) {
+	matcher, err := newRouteMatcher([]string{value})
+	if err != nil {
+		return nil, err
+	}
+	return matcher.matches, nil
+}
+
+// registerRouteEntry adds the entry to the mux, converting chi's panics on invalid patterns or methods into errors.
+func registerRouteEntry(mux *chi.Mux, entry routeEntry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid route '%s': %v", entry.String(), r)
+		}
+	}()
+
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if entry.method == anyMethod {
+		mux.Handle(entry.pattern, noop)
+	} else {
+		mux.Method(entry.method, entry.pattern, noop)
+	}
+	return nil
+}
+
+// matches is true if the route pattern of a request is covered by one of the entries.
+func (m *routeMatcher) matches(method string, routePattern string) bool {
+	if m == nil || routePattern == "" {
+		return false
+	}
+	routePattern = normalizeRoutePattern(routePattern)
+	for _, entry := range m.entries {
+		if entry.matches(method, routePattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestRoutePattern determines the route pattern chi will route the request to.
+//
+// The security middleware runs before routing, so the route context is not filled in yet, and we
+// need to perform the same lookup chi will perform. Returns "" if no route matches.
+func requestRoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return ""
+	}
+
+	// same as chi, which routes on the raw path if there are encoded characters
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	lookupCtx := chi.NewRouteContext()
+	if !rctx.Routes.Match(lookupCtx, r.Method, path) {
+		return ""
+	}
+	return normalizeRoutePattern(strings.Join(lookupCtx.RoutePatterns, ""))
+}
+
+// normalizeRoutePattern brings route patterns into the same form no matter how subrouters were mounted.
+//
+// This is the same normalization chi.Context.RoutePattern() performs.
+func normalizeRoutePattern(pattern string) string {
+	for strings.Contains(pattern, "/*/") {
+		pattern = strings.Replace(pattern, "/*/", "/", -1)
+	}
+	if pattern != "/" {
+		pattern = strings.TrimSuffix(pattern, "//")
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
+
+// WarnUnmatchedOpenEndpoints logs a warning for each configured open endpoint that matches none of the
+// registered routes. These are usually typos, or leftovers from removed routes.
+//
+// Call this after all routes have been registered.
+func WarnUnmatchedOpenEndpoints(ctx context.Context, conf *SecurityOptions, routes chi.Routes) error {
+	unmatched, err := unmatchedOpenEndpoints(conf, routes)
+	if err != nil {
+		return err
+	}
+	for _, value := range unmatched {
+		aulogging.Warnf(ctx, "open endpoint '%s' does not match any registered route", value)
+	}
+	return nil
+}
+
+func unmatchedOpenEndpoints(conf *SecurityOptions, routes chi.Routes) ([]string, error) {
+	result := make([]string, 0)
+	for _, value := range conf.OpenEndpoints {
+		single, err := newRouteMatcher([]string{value})
+		if err != nil {
+			return nil, err
+		}
+
+		used := false
+		err = chi.Walk(routes, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			if single.matches(method, route) {
+				used = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !used {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	testcases := []struct {
+		name     string
+		entry    string
+		method   string
+		route    string
+		expected bool
+	}{
+		{name: "literal", entry: "GET /a/b", method: http.MethodGet, route: "/a/b", expected: true},
+		{name: "literal_other", entry: "GET /a/b", method: http.MethodGet, route: "/a/c", expected: false},
+		{name: "method_case", entry: "get /a", method: http.MethodGet, route: "/a", expected: true},
+		{name: "other_method", entry: "GET /a", method: http.MethodPost, route: "/a", expected: false},
+		{name: "any_method", entry: "* /a", method: http.MethodDelete, route: "/a", expected: true},
+		{name: "param", entry: "GET /a/{b}", method: http.MethodGet, route: "/a/{id}", expected: true},
+		{name: "param_covers_literal", entry: "GET /a/{b}", method: http.MethodGet, route: "/a/public", expected: true},
+		{name: "literal_does_not_cover_param", entry: "GET /a/public", method: http.MethodGet, route: "/a/{b}", expected: false},
+		{name: "param_does_not_cover_wildcard", entry: "GET /a/{b}", method: http.MethodGet, route: "/a/*", expected: false},
+		{name: "regex_param_itself", entry: "GET /api/{id:[0-9]+}", method: http.MethodGet, route: "/api/{id:[0-9]+}", expected: true},
+		{name: "regex_param_other_name", entry: "GET /api/{x:[0-9]+}", method: http.MethodGet, route: "/api/{id:[0-9]+}", expected: true},
+		{name: "regex_param_other_regex", entry: "GET /api/{id:[0-9]+}", method: http.MethodGet, route: "/api/{id:[a-z]+}", expected: false},
+		{name: "regex_param_plain_param", entry: "GET /api/{id:[0-9]+}", method: http.MethodGet, route: "/api/{id}", expected: false},
+		{name: "regex_param_literal", entry: "GET /api/{id:[0-9]+}", method: http.MethodGet, route: "/api/42", expected: true},
+		{name: "regex_param_other_literal", entry: "GET /api/{id:[0-9]+}", method: http.MethodGet, route: "/api/abc", expected: false},
+		{name: "plain_param_covers_regex_param", entry: "GET /api/{id}", method: http.MethodGet, route: "/api/{id:[0-9]+}", expected: true},
+		{name: "wildcard", entry: "GET /a/*", method: http.MethodGet, route: "/a/b/{c}", expected: true},
+		{name: "wildcard_itself", entry: "GET /a/*", method: http.MethodGet, route: "/a/*", expected: true},
+		{name: "wildcard_not_parent", entry: "GET /a/*", method: http.MethodGet, route: "/a", expected: false},
+		{name: "root", entry: "GET /", method: http.MethodGet, route: "/", expected: true},
+		{name: "root_not_child", entry: "GET /", method: http.MethodGet, route: "/a", expected: false},
+		{name: "longer_route", entry: "GET /a", method: http.MethodGet, route: "/a/b", expected: false},
+		{name: "trailing_slash", entry: "GET /a/", method: http.MethodGet, route: "/a/", expected: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cut, err := newRouteMatcher([]string{tc.entry})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, cut.matches(tc.method, tc.route))
+		})
+	}
+}
+
+func TestUnmatchedOpenEndpoints(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	router.Get("/api/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {})
+
+	conf := &SecurityOptions{
+		OpenEndpoints: []string{"GET /", "GET /api/{id:[0-9]+}", "* /api/*", "GET /favicon.ico", "GET /api/{id:[a-z]+}"},
+	}
+	unmatched, err := unmatchedOpenEndpoints(conf, router)
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET /favicon.ico", "GET /api/{id:[a-z]+}"}, unmatched)
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
 	"github.com/golang-jwt/jwt/v5"
 	"net/http"
 	"strings"
@@ -26,25 +28,252 @@ type SecurityOptions struct {
 	// Even if an endpoint is in this list, if a token or api key is present, they are still validated to catch
 	// expired or retracted credentials. The endpoint may behave differently if authorization is presented, after all.
 	//
-	// Format: METHOD PATH
-	// Example: GET /
+	// Format: METHOD PATTERN, where PATTERN uses chi route pattern syntax, and METHOD may be * for any method.
+	// Entries are matched against the route pattern of the request, not the raw URL.
+	// Example: GET /api/rest/v1/example/{category}
 	OpenEndpoints []string
 
 	// ApiKey is a fixed shared secret. It uses a separate header so this can be filtered in an ingress.
+	//
+	// Deprecated: use ApiKeys. If set, it is accepted as an api key named DefaultApiKeyName.
 	ApiKey string
 
+	// ApiKeys maps names to shared secrets. They use the same separate header as ApiKey.
+	//
+	// During a key rotation, a name may have more than one secret, all of which are accepted.
+	ApiKeys map[string][]string
+
+	// ApiKeyPermissions restricts what the named api keys may do.
+	//
+	// Api keys without an entry, or with an empty list of routes, may call all routes, but are not in any groups.
+	ApiKeyPermissions map[string]ApiKeyPermissions
+
+	// Roles maps role names to the identity provider groups that have them, and the permissions they grant.
+	//
+	// Roles are resolved once per request after authentication, see common.GetRoles and common.HasPermission.
+	Roles map[string]RoleDefinition
+
+	// ImpersonationGroup is the identity provider group whose members may send the X-Impersonate-Subject header
+	// to act as another user. If empty, impersonation is disabled, and the header is rejected.
+	ImpersonationGroup string
+
 	// OpenID Connect, these may be extracted from the .well-known endpoint during middleware setup
 	IDPClient idp.IdentityProviderClient
 
+	// AdditionalIDPClients are further identity providers whose tokens are accepted.
+	//
+	// Tokens are routed to the identity provider that matches their issuer. Tokens without a recognizable
+	// issuer, such as opaque tokens, are tried against IDPClient first, then all AdditionalIDPClients in order.
+	AdditionalIDPClients []idp.IdentityProviderClient
+
 	AllowedAudiences []string
 	RequiredScopes   []string
+
+	// IssuerAllowedAudiences overrides AllowedAudiences for individual identity providers, by their name.
+	IssuerAllowedAudiences map[string][]string
+
+	// IDTokenAllowedAudiences are the audiences accepted for ID tokens, usually the client ids of the browser frontends.
+	//
+	// Unlike for access tokens, an empty list rejects all ID tokens.
+	IDTokenAllowedAudiences []string
+
+	// IssuerIDTokenAllowedAudiences overrides IDTokenAllowedAudiences for individual identity providers, by their name.
+	IssuerIDTokenAllowedAudiences map[string][]string
+
+	// AccessTokenValidation selects how access tokens are validated, see the TokenValidation... constants.
+	AccessTokenValidation string
+
+	// AccessTokenCookieName is the name of the cookie browser frontends send the access token in.
+	//
+	// Only used if no Authorization header is present. If empty, cookies are not checked for an access token.
+	AccessTokenCookieName string
+
+	// IDTokenCookieName is the name of the cookie browser frontends send the ID token in.
+	//
+	// The ID token is only evaluated together with a valid access token, and must belong to the same subject.
+	// If empty, cookies are not checked for an ID token.
+	IDTokenCookieName string
+
+	// compiled from the above by CheckRequestAuthorization
+	openEndpoints *routeMatcher
+	apiKeys       []apiKey
+}
+
+// issuer is an identity provider together with the audiences allowed for its tokens.
+type issuer struct {
+	client                  idp.IdentityProviderClient
+	allowedAudiences        []string
+	idTokenAllowedAudiences []string
+}
+
+// issuers lists all discovered identity providers, in the order opaque tokens are tried.
+//
+// Identity providers whose discovery has not succeeded yet are left out.
+func (conf *SecurityOptions) issuers() []issuer {
+	clients := make([]idp.IdentityProviderClient, 0, 1+len(conf.AdditionalIDPClients))
+	if conf.IDPClient != nil {
+		clients = append(clients, conf.IDPClient)
+	}
+	clients = append(clients, conf.AdditionalIDPClients...)
+
+	result := make([]issuer, 0, len(clients))
+	for _, client := range clients {
+		if client.Issuer() == "" {
+			continue
+		}
+		audiences, ok := conf.IssuerAllowedAudiences[client.Name()]
+		if !ok {
+			audiences = conf.AllowedAudiences
+		}
+		idTokenAudiences, ok := conf.IssuerIDTokenAllowedAudiences[client.Name()]
+		if !ok {
+			idTokenAudiences = conf.IDTokenAllowedAudiences
+		}
+		result = append(result, issuer{
+			client:                  client,
+			allowedAudiences:        audiences,
+			idTokenAllowedAudiences: idTokenAudiences,
+		})
+	}
+	return result
+}
+
+// identityProviderPending is true if identity providers are configured, but none of them has been discovered yet.
+func (conf *SecurityOptions) identityProviderPending() bool {
+	configured := conf.IDPClient != nil || len(conf.AdditionalIDPClients) > 0
+	return configured && len(conf.issuers()) == 0
+}
+
+// issuersForToken selects the identity providers a token should be checked against.
+//
+// If the token is a JWT from a known issuer, that is the only candidate. Otherwise, all identity providers
+// are candidates, unless mustBeKnown is set and the token names an unknown issuer, in which case there are none.
+func (conf *SecurityOptions) issuersForToken(tokenValue string, mustBeKnown bool) []issuer {
+	all := conf.issuers()
+
+	tokenIssuer := unverifiedIssuer(tokenValue)
+	if tokenIssuer != "" {
+		for _, candidate := range all {
+			if candidate.client.Issuer() == tokenIssuer {
+				return []issuer{candidate}
+			}
+		}
+	}
+
+	if mustBeKnown && tokenIssuer != "" && len(all) > 1 {
+		return nil
+	}
+	return all
+}
+
+// unverifiedIssuer reads the issuer claim from a JWT without validating it, or returns "" for other tokens.
+//
+// This is only ever used to decide which identity provider to ask, never to trust the token.
+func unverifiedIssuer(tokenValue string) string {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenValue, &claims); err != nil {
+		return ""
+	}
+	return claims.Issuer
+}
+
+// ApiKeyPermissions lists the groups an api key counts as being in, and the routes it may call.
+type ApiKeyPermissions struct {
+	// Groups are checked by route level authorization rules, just like the groups of a user.
+	Groups []string `json:"groups"`
+
+	// Routes uses the same format as SecurityOptions.OpenEndpoints. If empty, all routes are allowed.
+	Routes []string `json:"routes"`
+}
+
+// DefaultApiKeyName is the name under which the deprecated single ApiKey is known.
+const DefaultApiKeyName = "default"
+
+type apiKey struct {
+	name    string
+	secrets [][]byte
+	groups  []string
+	routes  *routeMatcher
+}
+
+// errForbidden marks errors that should lead to a 403 instead of a 401 response.
+var errForbidden = errors.New("forbidden")
+
+// errUnavailable marks errors that should lead to a 503 instead of a 401 response.
+var errUnavailable = errors.New("identity provider discovery has not succeeded yet")
+
+// compile prepares the configured open endpoints and api keys for matching against requests.
+func (conf *SecurityOptions) compile() error {
+	openEndpoints, err := newRouteMatcher(conf.OpenEndpoints)
+	if err != nil {
+		return err
+	}
+	conf.openEndpoints = openEndpoints
+
+	secretsByName := make(map[string][]string)
+	for name, secrets := range conf.ApiKeys {
+		secretsByName[name] = append(secretsByName[name], secrets...)
+	}
+	if conf.ApiKey != "" {
+		secretsByName[DefaultApiKeyName] = append(secretsByName[DefaultApiKeyName], conf.ApiKey)
+	}
+
+	conf.apiKeys = make([]apiKey, 0, len(secretsByName))
+	for name, secrets := range secretsByName {
+		key := apiKey{
+			name: name,
+		}
+		for _, secret := range secrets {
+			if secret != "" {
+				key.secrets = append(key.secrets, []byte(secret))
+			}
+		}
+		if permissions, ok := conf.ApiKeyPermissions[name]; ok {
+			key.groups = permissions.Groups
+			if len(permissions.Routes) > 0 {
+				key.routes, err = newRouteMatcher(permissions.Routes)
+				if err != nil {
+					return fmt.Errorf("invalid routes for api key %s: %w", name, err)
+				}
+			}
+		}
+		conf.apiKeys = append(conf.apiKeys, key)
+	}
+
+	for name := range conf.ApiKeyPermissions {
+		if _, ok := secretsByName[name]; !ok {
+			aulogging.Logger.NoCtx().Warn().Printf("permissions configured for unknown api key %s", name)
+		}
+	}
+
+	return nil
 }
 
 const (
-	ConfOIDCAllowedAudiences = "OIDC_ALLOWED_AUDIENCES"
-	ConfOIDCRequiredScopes   = "OIDC_REQUIRED_SCOPES"
-	ConfApiKey               = "API_KEY"
-	ConfOpenEndpoints        = "OPEN_ENDPOINTS"
+	// TokenValidationUserinfo validates each access token by calling the userinfo endpoint of the identity provider.
+	TokenValidationUserinfo = "userinfo"
+
+	// TokenValidationJWKS validates access tokens locally, using the signing keys published by the identity provider.
+	//
+	// The access token must be a JWT for this to work.
+	TokenValidationJWKS = "jwks"
+)
+
+const (
+	ConfOIDCAllowedAudiences       = "OIDC_ALLOWED_AUDIENCES"
+	ConfOIDCIssuerAudiences        = "OIDC_ISSUER_ALLOWED_AUDIENCES"
+	ConfOIDCRequiredScopes         = "OIDC_REQUIRED_SCOPES"
+	ConfOIDCTokenValidation        = "OIDC_TOKEN_VALIDATION"
+	ConfOIDCAccessTokenCookieName  = "OIDC_ACCESS_TOKEN_COOKIE_NAME"
+	ConfOIDCIDTokenCookieName      = "OIDC_ID_TOKEN_COOKIE_NAME"
+	ConfOIDCIDTokenAudiences       = "OIDC_ID_TOKEN_ALLOWED_AUDIENCES"
+	ConfOIDCIssuerIDTokenAudiences = "OIDC_ISSUER_ID_TOKEN_ALLOWED_AUDIENCES"
+	ConfApiKey                     = "API_KEY"
+	ConfApiKeys                    = "API_KEYS"
+	ConfApiKeyPermissions          = "API_KEY_PERMISSIONS"
+	ConfOpenEndpoints              = "OPEN_ENDPOINTS"
+	ConfRoles                      = "ROLES"
+	ConfImpersonationGroup         = "IMPERSONATION_GROUP"
 )
 
 func SecurityConfigItems() []auconfigapi.ConfigItem {
@@ -54,21 +283,71 @@ func SecurityConfigItems() []auconfigapi.ConfigItem {
 			Default:     "",
 			Description: "Audiences of the token to allow through. A token is authorized if its audience is in this list. Will need a token introspection endpoint to be present in OpenID Well Known response. Accepts a space separated list. If empty, all audiences are allowed through (may not be secure).",
 			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfOIDCIssuerAudiences,
+			Default:     "{}",
+			Description: "Audiences of the token to allow through, for individual identity providers. JSON object mapping the name of the identity provider (see " + idp.ConfOIDCAdditionalIssuers + ", the name of the default one is '" + idp.DefaultName + "') to a space separated list. Identity providers without an entry use " + ConfOIDCAllowedAudiences + ".",
+			Validate:    validateIssuerAudiences,
 		}, {
 			Key:         ConfOIDCRequiredScopes,
 			Default:     "",
 			Description: "Scopes of the token to allow through. A token is authorized only if it has all scopes in this list. Will need a token introspection endpoint to be present in OpenID Well Known response. Accepts a space separated list. If empty, no scopes are checked (may not be secure).",
 			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfOIDCTokenValidation,
+			Default:     TokenValidationUserinfo,
+			Description: "How to validate access tokens. 'userinfo' calls the userinfo endpoint of the identity provider for every request (subject to IDP_CACHE_ENABLED). 'jwks' validates signature, issuer, audience and expiry locally using the keys from the jwks_uri in the OpenID Well Known response, which requires JWT access tokens.",
+			Validate:    auconfigenv.ObtainPatternValidator("^(" + TokenValidationUserinfo + "|" + TokenValidationJWKS + ")$"),
+		}, {
+			Key:         ConfOIDCAccessTokenCookieName,
+			Default:     "",
+			Description: "Name of the cookie that contains the access token, for browser frontends. Only used if no Authorization header is sent. If empty, no cookie is checked.",
+			Validate:    auconfigenv.ObtainPatternValidator("^[a-zA-Z0-9_-]*$"),
+		}, {
+			Key:         ConfOIDCIDTokenCookieName,
+			Default:     "",
+			Description: "Name of the cookie that contains the ID token, for browser frontends. The ID token signature is validated using the keys from the jwks_uri in the OpenID Well Known response, and its subject must match the access token. If empty, no cookie is checked. If set, " + ConfOIDCIDTokenAudiences + " is required.",
+			Validate:    auconfigenv.ObtainPatternValidator("^[a-zA-Z0-9_-]*$"),
+		}, {
+			Key:         ConfOIDCIDTokenAudiences,
+			Default:     "",
+			Description: "Audiences of the ID token to allow through, usually the client ids of the browser frontends. These differ from the audiences of access tokens in " + ConfOIDCAllowedAudiences + ". Accepts a space separated list. Required if " + ConfOIDCIDTokenCookieName + " is set.",
+			Validate:    validateIDTokenAudiences,
+		}, {
+			Key:         ConfOIDCIssuerIDTokenAudiences,
+			Default:     "{}",
+			Description: "Audiences of the ID token to allow through, for individual identity providers. JSON object mapping the name of the identity provider (see " + idp.ConfOIDCAdditionalIssuers + ", the name of the default one is '" + idp.DefaultName + "') to a space separated list. Identity providers without an entry use " + ConfOIDCIDTokenAudiences + ".",
+			Validate:    validateIssuerAudiences,
 		}, {
 			Key:         ConfApiKey,
 			Default:     "",
-			Description: "Shared secret API Key. Uses a separate header, which should be filtered in the ingress to prevent use from the outside.",
+			Description: "Shared secret API Key. Uses a separate header, which should be filtered in the ingress to prevent use from the outside. Deprecated, use API_KEYS instead. If set, it is accepted as the api key named '" + DefaultApiKeyName + "'.",
 			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfApiKeys,
+			Default:     "{}",
+			Description: "Named shared secret API Keys. Uses the same header as API_KEY. JSON object mapping each key name to its secret. During a key rotation, give two secrets separated by a space, both will be accepted. Individual keys can be loaded from Vault by giving 'API_KEYS.<name>' as the configKey in VAULT_SECRETS_CONFIG, which adds them to the keys listed here.",
+			Validate:    validateApiKeys,
+		}, {
+			Key:         ConfApiKeyPermissions,
+			Default:     "{}",
+			Description: "Permissions of the named API Keys. JSON object mapping key names to {\"groups\": [...], \"routes\": [...]}. Groups are used by route level authorization. Routes use the same format as OPEN_ENDPOINTS, if left empty, the key may call all routes. Keys without an entry are not in any groups.",
+			Validate:    validateApiKeyPermissions,
 		}, {
 			Key:         ConfOpenEndpoints,
-			Default:     `["GET /", "GET /favicon.ico"]`,
-			Description: "List of endpoints which can be called without authorization.",
+			Default:     `["GET /"]`,
+			Description: "List of endpoints which can be called without authorization. JSON list of 'METHOD PATTERN' entries, where PATTERN uses chi route pattern syntax (such as '/api/{category}' or '/api/*') and METHOD may be '*' for any method. Entries are matched against the route pattern the request is routed to, not the raw URL. A parameter matches any parameter or path segment of the route, but a parameter with a regular expression (such as '/api/{id:[0-9]+}') only matches parameters with exactly the same regular expression, or path segments it matches. Requests to paths without a route are never open.",
 			Validate:    validateOpenEndpoints,
+		}, {
+			Key:         ConfRoles,
+			Default:     "{}",
+			Description: "Application roles. JSON object mapping role names (such as admin, staff, regdesk) to {\"groups\": [...], \"permissions\": [...]}. Callers in any of the listed identity provider groups have the role and all its permissions. For api keys, the groups from " + ConfApiKeyPermissions + " are used.",
+			Validate:    validateRoles,
+		}, {
+			Key:         ConfImpersonationGroup,
+			Default:     "",
+			Description: "Identity provider group whose members may act as another user by sending the subject of that user in the " + impersonateHeader + " header. Requests then see only the subject of the other user, not their groups, and no scopes, so routes that require scopes reject them. Both users are logged. If empty, impersonation is disabled.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
 		},
 	}
 }
@@ -81,6 +360,9 @@ func parseOpenEndpoints(value string) ([]string, error) {
 	if err != nil {
 		return result, fmt.Errorf("failed to parse open endpoint configuration: %w", err)
 	}
+	if _, err := newRouteMatcher(result); err != nil {
+		return result, fmt.Errorf("failed to parse open endpoint configuration: %w", err)
+	}
 	return result, nil
 }
 
@@ -90,13 +372,93 @@ func validateOpenEndpoints(key string) error {
 	return err
 }
 
+func parseApiKeys(value string) (map[string][]string, error) {
+	raw := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse api key configuration: %w", err)
+	}
+	result := make(map[string][]string)
+	for name, secrets := range raw {
+		result[name] = strings.Fields(secrets)
+	}
+	return result, nil
+}
+
+func validateApiKeys(key string) error {
+	_, err := parseApiKeys(auconfigenv.Get(key))
+	return err
+}
+
+func parseApiKeyPermissions(value string) (map[string]ApiKeyPermissions, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	result := make(map[string]ApiKeyPermissions)
+	if err := decoder.Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to parse api key permission configuration: %w", err)
+	}
+	for name, permissions := range result {
+		if _, err := newRouteMatcher(permissions.Routes); err != nil {
+			return result, fmt.Errorf("failed to parse api key permission configuration for %s: %w", name, err)
+		}
+	}
+	return result, nil
+}
+
+func validateApiKeyPermissions(key string) error {
+	_, err := parseApiKeyPermissions(auconfigenv.Get(key))
+	return err
+}
+
+func parseIssuerAudiences(value string) (map[string][]string, error) {
+	raw := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse issuer audience configuration: %w", err)
+	}
+	result := make(map[string][]string)
+	for name, audiences := range raw {
+		result[name] = splitBySpaceOrEmpty(audiences)
+	}
+	return result, nil
+}
+
+func validateIssuerAudiences(key string) error {
+	_, err := parseIssuerAudiences(auconfigenv.Get(key))
+	return err
+}
+
+func validateIDTokenAudiences(key string) error {
+	if auconfigenv.Get(ConfOIDCIDTokenCookieName) != "" && auconfigenv.Get(key) == "" {
+		return fmt.Errorf("%s is required if %s is set", key, ConfOIDCIDTokenCookieName)
+	}
+	return nil
+}
+
 func SecurityOptionsPartialFromConfig() SecurityOptions {
 	openEndpoints, _ := parseOpenEndpoints(auconfigenv.Get(ConfOpenEndpoints))
+	apiKeys, _ := parseApiKeys(auconfigenv.Get(ConfApiKeys))
+	apiKeyPermissions, _ := parseApiKeyPermissions(auconfigenv.Get(ConfApiKeyPermissions))
+	issuerAudiences, _ := parseIssuerAudiences(auconfigenv.Get(ConfOIDCIssuerAudiences))
+	issuerIDTokenAudiences, _ := parseIssuerAudiences(auconfigenv.Get(ConfOIDCIssuerIDTokenAudiences))
+	roles, _ := parseRoles(auconfigenv.Get(ConfRoles))
 	return SecurityOptions{
-		ApiKey:           auconfigenv.Get(ConfApiKey),
-		AllowedAudiences: splitBySpaceOrEmpty(auconfigenv.Get(ConfOIDCAllowedAudiences)),
-		RequiredScopes:   splitBySpaceOrEmpty(auconfigenv.Get(ConfOIDCRequiredScopes)),
-		OpenEndpoints:    openEndpoints,
+		ApiKey:            auconfigenv.Get(ConfApiKey),
+		ApiKeys:           apiKeys,
+		ApiKeyPermissions: apiKeyPermissions,
+		AllowedAudiences:  splitBySpaceOrEmpty(auconfigenv.Get(ConfOIDCAllowedAudiences)),
+		RequiredScopes:    splitBySpaceOrEmpty(auconfigenv.Get(ConfOIDCRequiredScopes)),
+		OpenEndpoints:     openEndpoints,
+		Roles:             roles,
+
+		ImpersonationGroup: auconfigenv.Get(ConfImpersonationGroup),
+
+		IssuerAllowedAudiences: issuerAudiences,
+
+		IDTokenAllowedAudiences:       splitBySpaceOrEmpty(auconfigenv.Get(ConfOIDCIDTokenAudiences)),
+		IssuerIDTokenAllowedAudiences: issuerIDTokenAudiences,
+
+		AccessTokenValidation: auconfigenv.Get(ConfOIDCTokenValidation),
+		AccessTokenCookieName: auconfigenv.Get(ConfOIDCAccessTokenCookieName),
+		IDTokenCookieName:     auconfigenv.Get(ConfOIDCIDTokenCookieName),
 	}
 }
 
@@ -115,21 +477,53 @@ const (
 // CheckRequestAuthorization creates a middleware that validates authorization and adds them to the relevant
 // context values.
 func CheckRequestAuthorization(conf *SecurityOptions) func(http.Handler) http.Handler {
+	if err := conf.compile(); err != nil {
+		// config was validated, so this should only happen in tests
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("invalid security configuration, some endpoints may be unreachable: %s", err.Error())
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 
 			apiTokenHeaderValue := fromApiTokenHeader(r)
 			authHeaderValue := fromAuthHeader(r)
+			if authHeaderValue == "" {
+				authHeaderValue = fromCookie(r, conf.AccessTokenCookieName)
+			}
+			idTokenValue := fromCookie(r, conf.IDTokenCookieName)
 
-			ctx, userFacingErrorMessage, err := checkAllAuthentication(ctx, r.Method, r.URL.Path, conf, apiTokenHeaderValue, authHeaderValue)
+			routePattern := requestRoutePattern(r)
+
+			ctx, userFacingErrorMessage, err := checkAllAuthentication(ctx, r.Method, routePattern, conf, apiTokenHeaderValue, authHeaderValue, idTokenValue)
+			if err == nil {
+				ctx, userFacingErrorMessage, err = checkImpersonation(ctx, conf, r.Header.Get(impersonateHeader))
+			}
 			if err != nil {
 				subject := common.GetSubject(ctx)
 				aulogging.InfoErrf(ctx, err, "authorization failed for subject %s: %s", subject, userFacingErrorMessage)
-				web.SendUnauthorizedResponse(ctx, w, userFacingErrorMessage)
+				if errors.Is(err, errForbidden) {
+					web.SendForbiddenResponse(ctx, w, userFacingErrorMessage)
+				} else if errors.Is(err, errUnavailable) {
+					web.SendServiceUnavailableResponse(ctx, w, userFacingErrorMessage)
+				} else {
+					web.SendUnauthorizedResponse(ctx, w, userFacingErrorMessage)
+				}
 				return
 			}
 
+			if apiKeyName := common.GetAPIKeyName(ctx); apiKeyName != "" {
+				ctx = addFieldToRequestLogger(ctx, ApiKeyNameFieldName, apiKeyName)
+			}
+			if impersonator := common.GetImpersonator(ctx); impersonator != nil {
+				ctx = addFieldToRequestLogger(ctx, SubjectFieldName, impersonator.Subject)
+				ctx = addFieldToRequestLogger(ctx, EffectiveSubjectFieldName, common.GetSubject(ctx))
+			} else if subject := common.GetSubject(ctx); subject != "" {
+				ctx = addFieldToRequestLogger(ctx, SubjectFieldName, subject)
+			}
+			ctx = resolveRoles(ctx, conf.Roles)
+			ctx = applyLogLevelOverrides(ctx, r, true)
+
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 		})
@@ -154,36 +548,62 @@ func fromApiTokenHeader(r *http.Request) string {
 	return r.Header.Get(apiKeyHeader)
 }
 
+func fromCookie(r *http.Request, cookieName string) string {
+	if cookieName == "" {
+		return ""
+	}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
 // --- top level ---.
 
-func checkAllAuthentication(ctx context.Context, method string, urlPath string, conf *SecurityOptions, apiTokenHeaderValue string, authHeaderValue string) (context.Context, string, error) {
+func checkAllAuthentication(ctx context.Context, method string, routePattern string, conf *SecurityOptions, apiTokenHeaderValue string, authHeaderValue string, idTokenValue string) (context.Context, string, error) {
 	var success bool
 	var err error
 
 	// try api token first
-	ctx, success, err = checkApiToken(ctx, conf, apiTokenHeaderValue)
+	ctx, success, err = checkApiToken(ctx, conf, method, routePattern, apiTokenHeaderValue)
 	if err != nil {
+		if errors.Is(err, errForbidden) {
+			return ctx, "api key not authorized for this operation", err
+		}
 		return ctx, "invalid api token", err
 	}
 	if success {
 		return ctx, "", nil
 	}
 
-	// now try authorization header (gives only access token, so MUST use userinfo/tokeninfo endpoint)
+	if conf.identityProviderPending() {
+		// open endpoints still work, but as if no token had been presented, because we cannot check it
+		if conf.openEndpoints.matches(method, routePattern) {
+			return ctx, "", nil
+		}
+		return ctx, "identity provider not available yet, please try again later", errUnavailable
+	}
+
+	// now try authorization header or access token cookie (gives only access token, so MUST use userinfo/tokeninfo endpoint or local validation)
 	ctx, success, err = checkAccessToken(ctx, conf, authHeaderValue)
 	if err != nil {
 		return ctx, "invalid bearer token", err
 	}
 	if success {
+		// an id token is optional, but if present it must be valid and belong to the same user
+		ctx, err = checkIdToken(ctx, conf, idTokenValue)
+		if err != nil {
+			return ctx, "invalid id token", err
+		}
 		return ctx, "", nil
 	}
 
 	// allow through (but still AFTER auth processing)
-	currentEndpoint := fmt.Sprintf("%s %s", method, urlPath)
-	for _, publicEndpoint := range conf.OpenEndpoints {
-		if currentEndpoint == publicEndpoint {
-			return ctx, "", nil
-		}
+	if conf.openEndpoints.matches(method, routePattern) {
+		return ctx, "", nil
 	}
 
 	return ctx, "you must be logged in for this operation", errors.New("no authorization presented")
@@ -193,75 +613,231 @@ func checkAllAuthentication(ctx context.Context, method string, urlPath string,
 
 // important - if any of these return an error, you must abort processing via "return" and log the error message
 
-func checkApiToken(ctx context.Context, conf *SecurityOptions, apiTokenValue string) (context.Context, bool, error) {
+func checkApiToken(ctx context.Context, conf *SecurityOptions, method string, routePattern string, apiTokenValue string) (context.Context, bool, error) {
 	if apiTokenValue != "" {
 		// ignore jwt if set (may still need to pass it through to other service)
-		if apiTokenValue == conf.ApiKey {
-			ctx = context.WithValue(ctx, common.CtxKeyAPIKey{}, apiTokenValue)
-			return ctx, true, nil
-		} else {
-			return ctx, false, errors.New("token doesn't match the configured value")
+		key, ok := findApiKey(conf.apiKeys, apiTokenValue)
+		if !ok {
+			return ctx, false, errors.New("token doesn't match any configured value")
 		}
+
+		ctx = context.WithValue(ctx, common.CtxKeyAPIKey{}, key.name)
+		ctx = context.WithValue(ctx, common.CtxKeyAPIKeyGroups{}, key.groups)
+
+		if key.routes != nil && !key.routes.matches(method, routePattern) {
+			return ctx, false, fmt.Errorf("%w: api key %s may not call %s %s", errForbidden, key.name, method, routePattern)
+		}
+		return ctx, true, nil
 	}
 	return ctx, false, nil
 }
 
+// findApiKey compares the presented value against all configured secrets in constant time.
+//
+// All secrets are always compared, so the response time does not reveal which key was close.
+func findApiKey(keys []apiKey, value string) (apiKey, bool) {
+	presented := []byte(value)
+	found := -1
+	for index, key := range keys {
+		for _, secret := range key.secrets {
+			if subtle.ConstantTimeCompare(presented, secret) == 1 {
+				found = index
+			}
+		}
+	}
+	if found < 0 {
+		return apiKey{}, false
+	}
+	return keys[found], true
+}
+
 func checkAccessToken(ctx context.Context, conf *SecurityOptions, accessTokenValue string) (context.Context, bool, error) {
+	if conf.AccessTokenValidation == TokenValidationJWKS {
+		return checkAccessTokenLocally(ctx, conf, accessTokenValue)
+	}
+
 	if accessTokenValue != "" {
-		if conf.IDPClient != nil {
-			authCtx := context.WithValue(ctx, common.CtxKeyAccessToken{}, accessTokenValue) // need this set for userinfo call
+		candidates := conf.issuersForToken(accessTokenValue, false)
+		if len(candidates) == 0 {
+			return ctx, false, errors.New("request failed access token check, denying: no userinfo endpoint configured")
+		}
 
-			userInfo, status, err := conf.IDPClient.UserInfo(authCtx)
-			if err != nil {
-				return ctx, false, fmt.Errorf("request failed access token check, denying: %s", err.Error())
+		authCtx := context.WithValue(ctx, common.CtxKeyAccessToken{}, accessTokenValue) // need this set for userinfo call
+
+		var err error
+		for _, candidate := range candidates {
+			var claims *common.AllClaims
+			claims, err = checkAccessTokenWithUserinfo(authCtx, conf, candidate, accessTokenValue)
+			if err == nil {
+				ctx = context.WithValue(authCtx, common.CtxKeyClaims{}, claims)
+				return ctx, true, nil
 			}
-			if status != http.StatusOK {
-				return ctx, false, fmt.Errorf("request failed access token check with status %d, denying", status)
+			if len(candidates) > 1 {
+				aulogging.Debugf(ctx, "access token not accepted by identity provider %s: %s", candidate.client.Name(), err.Error())
 			}
+		}
+		return ctx, false, err
+	}
+	return ctx, false, nil
+}
 
-			if len(conf.AllowedAudiences) > 0 {
-				if !listsIntersect(conf.AllowedAudiences, userInfo.Audience) {
-					return ctx, false, errors.New("token audience does not contain a match")
-				}
+// checkAccessTokenWithUserinfo asks a single identity provider about the access token in authCtx.
+func checkAccessTokenWithUserinfo(authCtx context.Context, conf *SecurityOptions, candidate issuer, accessTokenValue string) (*common.AllClaims, error) {
+	userInfo, status, err := candidate.client.UserInfo(authCtx)
+	if err != nil {
+		return nil, fmt.Errorf("request failed access token check, denying: %s", err.Error())
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("request failed access token check with status %d, denying", status)
+	}
+
+	if len(candidate.allowedAudiences) > 0 {
+		if !listsIntersect(candidate.allowedAudiences, userInfo.Audience) {
+			return nil, errors.New("token audience does not contain a match")
+		}
+	}
+
+	scope := ""
+	if len(conf.RequiredScopes) > 0 {
+		tokenInfo, status, err := candidate.client.TokenIntrospection(authCtx)
+		if err != nil {
+			return nil, fmt.Errorf("request failed access token introspection, denying: %s", err.Error())
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("request failed access token introspection with status %d, denying", status)
+		}
+		if !tokenInfo.Active {
+			return nil, errors.New("token introspection reports token as not active")
+		}
+
+		if !listsContained(strings.Split(tokenInfo.Scope, " "), conf.RequiredScopes) {
+			return nil, errors.New("token does not have all required scopes")
+		}
+		scope = tokenInfo.Scope
+	}
+
+	return &common.AllClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   candidate.client.Issuer(),
+			Subject:  userInfo.Subject,
+			Audience: jwt.ClaimStrings(userInfo.Audience),
+		},
+		CustomClaims: common.CustomClaims{
+			EMail:         userInfo.Email,
+			EMailVerified: userInfo.EmailVerified,
+			Groups:        userInfo.Groups,
+			Name:          userInfo.Name,
+			Scope:         scope,
+		},
+	}, nil
+}
+
+// jwtSigningMethods are the asymmetric signature algorithms we accept for locally validated tokens.
+//
+// Symmetric algorithms and "none" must never be accepted here.
+var jwtSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// parseSignedToken verifies signature, issuer, expiry, not before, and audience of a JWT issued by one of our identity providers.
+//
+// ID tokens are checked against the ID token audiences, which must not be empty.
+func parseSignedToken(ctx context.Context, conf *SecurityOptions, tokenValue string, idToken bool) (*common.AllClaims, error) {
+	candidates := conf.issuersForToken(tokenValue, true)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("token issuer '%s' is not accepted", unverifiedIssuer(tokenValue))
+	}
+	// there is more than one candidate only if there is a single identity provider, or the token is not a JWT
+	candidate := candidates[0]
+
+	claims := common.AllClaims{}
+	_, err := jwt.ParseWithClaims(tokenValue, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return candidate.client.SigningKey(ctx, kid)
+	},
+		jwt.WithValidMethods(jwtSigningMethods),
+		jwt.WithIssuer(candidate.client.Issuer()),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(timestamp.Now),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken {
+		if !listsIntersect(candidate.idTokenAllowedAudiences, claims.Audience) {
+			return nil, errors.New("token audience does not contain a match")
+		}
+	} else if len(candidate.allowedAudiences) > 0 {
+		if !listsIntersect(candidate.allowedAudiences, claims.Audience) {
+			return nil, errors.New("token audience does not contain a match")
+		}
+	}
+
+	return &claims, nil
+}
+
+func checkAccessTokenLocally(ctx context.Context, conf *SecurityOptions, accessTokenValue string) (context.Context, bool, error) {
+	if accessTokenValue != "" {
+		if len(conf.issuers()) > 0 {
+			claims, err := parseSignedToken(ctx, conf, accessTokenValue, false)
+			if err != nil {
+				return ctx, false, fmt.Errorf("request failed access token check, denying: %s", err.Error())
 			}
 
 			if len(conf.RequiredScopes) > 0 {
-				tokenInfo, status, err := conf.IDPClient.TokenIntrospection(ctx)
-				if err != nil {
-					return ctx, false, fmt.Errorf("request failed access token introspection, denying: %s", err.Error())
-				}
-				if status != http.StatusOK {
-					return ctx, false, fmt.Errorf("request failed access token introspection with status %d, denying", status)
-				}
-
-				if !listsContained(strings.Split(tokenInfo.Scope, " "), conf.RequiredScopes) {
+				if !listsContained(strings.Split(claims.Scope, " "), conf.RequiredScopes) {
 					return ctx, false, errors.New("token does not have all required scopes")
 				}
 			}
 
-			overwriteClaims := common.AllClaims{
-				RegisteredClaims: jwt.RegisteredClaims{
-					Issuer:   conf.IDPClient.Issuer(),
-					Subject:  userInfo.Subject,
-					Audience: jwt.ClaimStrings(userInfo.Audience),
-				},
-				CustomClaims: common.CustomClaims{
-					EMail:         userInfo.Email,
-					EMailVerified: userInfo.EmailVerified,
-					Groups:        userInfo.Groups,
-					Name:          userInfo.Name,
-				},
-			}
-
-			ctx = context.WithValue(authCtx, common.CtxKeyClaims{}, &overwriteClaims)
+			ctx = context.WithValue(ctx, common.CtxKeyAccessToken{}, accessTokenValue)
+			ctx = context.WithValue(ctx, common.CtxKeyClaims{}, claims)
 			return ctx, true, nil
 		} else {
-			return ctx, false, errors.New("request failed access token check, denying: no userinfo endpoint configured")
+			return ctx, false, errors.New("request failed access token check, denying: no identity provider configured")
 		}
 	}
 	return ctx, false, nil
 }
 
+// checkIdToken validates an id token presented alongside an already validated access token.
+//
+// On success, the claims from the id token replace the claims obtained for the access token, except for
+// scope and groups. Id tokens carry no scope, and the groups of the access token may come from userinfo.
+func checkIdToken(ctx context.Context, conf *SecurityOptions, idTokenValue string) (context.Context, error) {
+	if idTokenValue != "" {
+		if len(conf.issuers()) > 0 {
+			claims, err := parseSignedToken(ctx, conf, idTokenValue, true)
+			if err != nil {
+				return ctx, fmt.Errorf("request failed id token check, denying: %s", err.Error())
+			}
+
+			accessTokenClaims := common.GetClaims(ctx)
+			if accessTokenClaims == nil {
+				accessTokenClaims = &common.AllClaims{}
+			}
+			if claims.Issuer != accessTokenClaims.Issuer {
+				return ctx, fmt.Errorf("id token issuer '%s' does not match access token issuer '%s'", claims.Issuer, accessTokenClaims.Issuer)
+			}
+
+			if claims.Subject == "" || claims.Subject != accessTokenClaims.Subject {
+				return ctx, fmt.Errorf("id token subject '%s' does not match access token subject '%s'", claims.Subject, accessTokenClaims.Subject)
+			}
+
+			claims.Scope = accessTokenClaims.Scope
+			if accessTokenClaims.Groups != nil {
+				claims.Groups = accessTokenClaims.Groups
+			}
+
+			ctx = context.WithValue(ctx, common.CtxKeyIDToken{}, idTokenValue)
+			ctx = context.WithValue(ctx, common.CtxKeyClaims{}, claims)
+			return ctx, nil
+		} else {
+			return ctx, errors.New("request failed id token check, denying: no identity provider configured")
+		}
+	}
+	return ctx, nil
+}
+
 // listsIntersect is true if at least one common element exists.
 //
 // If either list is empty, they do not intersect.
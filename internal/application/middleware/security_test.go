@@ -2,9 +2,21 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
+	"github.com/eurofurence/reg-backend-template-test/test/mocks/idpmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCheckAllAuthentication(t *testing.T) {
@@ -20,19 +32,33 @@ func TestCheckAllAuthentication(t *testing.T) {
 
 	configNoIDP := SecurityOptions{
 		OpenEndpoints: []string{
-			"POST a/b/open",
-			"PUT open/a/b",
+			"POST /a/b/open",
+			"PUT /open/a/b",
+		},
+		ApiKey: "api-key",
+		ApiKeys: map[string][]string{
+			"rotating": {"old-key", "new-key"},
+			"limited":  {"limited-key"},
+		},
+		ApiKeyPermissions: map[string]ApiKeyPermissions{
+			"rotating": {Groups: []string{"staff"}},
+			"limited":  {Routes: []string{"GET /a/b"}},
 		},
-		ApiKey:           "api-key",
 		IDPClient:        nil,
 		AllowedAudiences: nil,
 		RequiredScopes:   nil,
 	}
+	require.NoError(t, configNoIDP.compile())
+	checkApiKey := func(name string, groups ...string) func(context.Context) bool {
+		return func(ctx context.Context) bool {
+			return checkOrigCtx(ctx) && common.GetAPIKeyName(ctx) == name && listsContained(groups, common.GetGroups(ctx))
+		}
+	}
 
 	testcases := []struct {
 		name       string
 		method     string
-		urlPath    string
+		route      string
 		conf       *SecurityOptions
 		apiToken   string
 		authHeader string
@@ -43,7 +69,7 @@ func TestCheckAllAuthentication(t *testing.T) {
 		{
 			name:       "no_idp_none_provided",
 			method:     http.MethodGet,
-			urlPath:    "a/b/c",
+			route:      "/a/b",
 			conf:       &configNoIDP,
 			apiToken:   "",
 			authHeader: "",
@@ -51,11 +77,88 @@ func TestCheckAllAuthentication(t *testing.T) {
 			expectMsg:  "you must be logged in for this operation",
 			expectErr:  compareErr("no authorization presented"),
 		},
+		{
+			name:       "no_idp_open_endpoint",
+			method:     http.MethodPut,
+			route:      "/open/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "",
+			authHeader: "",
+			expectCtx:  checkOrigCtx,
+			expectMsg:  "",
+			expectErr:  func(err error) bool { return err == nil },
+		},
+		{
+			name:       "api_key_legacy",
+			method:     http.MethodGet,
+			route:      "/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "api-key",
+			authHeader: "",
+			expectCtx:  checkApiKey(DefaultApiKeyName),
+			expectMsg:  "",
+			expectErr:  func(err error) bool { return err == nil },
+		},
+		{
+			name:       "api_key_rotation_old",
+			method:     http.MethodPost,
+			route:      "/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "old-key",
+			authHeader: "",
+			expectCtx:  checkApiKey("rotating", "staff"),
+			expectMsg:  "",
+			expectErr:  func(err error) bool { return err == nil },
+		},
+		{
+			name:       "api_key_rotation_new",
+			method:     http.MethodPost,
+			route:      "/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "new-key",
+			authHeader: "",
+			expectCtx:  checkApiKey("rotating", "staff"),
+			expectMsg:  "",
+			expectErr:  func(err error) bool { return err == nil },
+		},
+		{
+			name:       "api_key_unknown",
+			method:     http.MethodGet,
+			route:      "/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "api-key-but-longer",
+			authHeader: "",
+			expectCtx:  checkApiKey(""),
+			expectMsg:  "invalid api token",
+			expectErr:  compareErr("token doesn't match any configured value"),
+		},
+		{
+			name:       "api_key_route_allowed",
+			method:     http.MethodGet,
+			route:      "/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "limited-key",
+			authHeader: "",
+			expectCtx:  checkApiKey("limited"),
+			expectMsg:  "",
+			expectErr:  func(err error) bool { return err == nil },
+		},
+		{
+			name:       "api_key_route_forbidden",
+			method:     http.MethodPost,
+			route:      "/a/b",
+			conf:       &configNoIDP,
+			apiToken:   "limited-key",
+			authHeader: "",
+			expectCtx:  checkApiKey("limited"),
+			expectMsg:  "api key not authorized for this operation",
+			expectErr:  func(err error) bool { return errors.Is(err, errForbidden) },
+		},
 		// TODO more test cases with mocked idp client now
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			ctx, msg, err := checkAllAuthentication(origCtx, tc.method, tc.urlPath, tc.conf, tc.apiToken, tc.authHeader)
+			ctx, msg, err := checkAllAuthentication(origCtx, tc.method, tc.route, tc.conf, tc.apiToken, tc.authHeader, "")
 			require.True(t, tc.expectCtx(ctx))
 			require.Equal(t, tc.expectMsg, msg)
 			require.True(t, tc.expectErr(err))
@@ -63,6 +166,484 @@ func TestCheckAllAuthentication(t *testing.T) {
 	}
 }
 
+func TestCheckAllAuthentication_JWKS(t *testing.T) {
+	idpClient := idpmock.New()
+	require.NoError(t, idpClient.SetupFromWellKnown(context.Background()))
+
+	configJWKS := SecurityOptions{
+		IDPClient:             idpClient,
+		AllowedAudiences:      []string{"my-audience"},
+		RequiredScopes:        []string{"example"},
+		AccessTokenValidation: TokenValidationJWKS,
+	}
+
+	validClaims := func() common.AllClaims {
+		return common.AllClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "1234",
+				Audience:  jwt.ClaimStrings{"my-audience"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+			CustomClaims: common.CustomClaims{
+				EMail:  "demouser@example.com",
+				Groups: []string{"staff"},
+				Name:   "John Doe",
+				Scope:  "openid example",
+			},
+		}
+	}
+	signedWith := func(modify func(*common.AllClaims)) string {
+		claims := validClaims()
+		modify(&claims)
+		return idpmock.SignToken(idpClient, claims)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	foreignToken := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims())
+	foreignToken.Header["kid"] = idpmock.KeyID
+	foreignSigned, err := foreignToken.SignedString(otherKey)
+	require.NoError(t, err)
+
+	hmacSigned, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name        string
+		authHeader  string
+		expectErr   string
+		expectClaim string
+	}{
+		{
+			name:        "valid",
+			authHeader:  signedWith(func(c *common.AllClaims) {}),
+			expectClaim: "1234",
+		},
+		{
+			name:       "expired",
+			authHeader: signedWith(func(c *common.AllClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }),
+			expectErr:  "token is expired",
+		},
+		{
+			name:       "no_expiry",
+			authHeader: signedWith(func(c *common.AllClaims) { c.ExpiresAt = nil }),
+			expectErr:  "token is missing required claim: exp claim is required",
+		},
+		{
+			name:       "not_yet_valid",
+			authHeader: signedWith(func(c *common.AllClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) }),
+			expectErr:  "token is not valid yet",
+		},
+		{
+			name:       "wrong_issuer",
+			authHeader: signedWith(func(c *common.AllClaims) { c.Issuer = "https://evil.example.com" }),
+			expectErr:  "token has invalid issuer",
+		},
+		{
+			name:       "wrong_audience",
+			authHeader: signedWith(func(c *common.AllClaims) { c.Audience = jwt.ClaimStrings{"other-audience"} }),
+			expectErr:  "token audience does not contain a match",
+		},
+		{
+			name:       "missing_scope",
+			authHeader: signedWith(func(c *common.AllClaims) { c.Scope = "openid" }),
+			expectErr:  "token does not have all required scopes",
+		},
+		{
+			name:       "foreign_signature",
+			authHeader: foreignSigned,
+			expectErr:  "token signature is invalid",
+		},
+		{
+			name:       "symmetric_algorithm",
+			authHeader: hmacSigned,
+			expectErr:  "signing method HS256 is invalid",
+		},
+		{
+			name:       "not_a_jwt",
+			authHeader: "opaque-token",
+			expectErr:  "token is malformed",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, msg, err := checkAllAuthentication(context.Background(), http.MethodGet, "/a", &configJWKS, "", tc.authHeader, "")
+			if tc.expectErr != "" {
+				require.Equal(t, "invalid bearer token", msg)
+				require.Error(t, err)
+				require.True(t, strings.Contains(err.Error(), tc.expectErr), "unexpected error: %s", err.Error())
+				require.Nil(t, common.GetClaims(ctx))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectClaim, common.GetSubject(ctx))
+				require.Equal(t, "mock-issuer", common.GetClaims(ctx).Issuer)
+				require.Equal(t, tc.authHeader, common.GetAccessToken(ctx))
+				require.True(t, common.HasGroup(ctx, "staff"))
+			}
+		})
+	}
+}
+
+func TestCheckAllAuthentication_Introspection(t *testing.T) {
+	idpClient := idpmock.New()
+	require.NoError(t, idpClient.SetupFromWellKnown(context.Background()))
+	userInfo := func(sub string) idp.UserinfoResponse {
+		return idp.UserinfoResponse{Audience: []string{"my-audience"}, Subject: sub}
+	}
+	idpmock.SetupResponse(idpClient, "active-token", userInfo("1"), idp.TokenIntrospectionResponse{Active: true, Scope: "openid example"})
+	idpmock.SetupResponse(idpClient, "inactive-token", userInfo("2"), idp.TokenIntrospectionResponse{Active: false, Scope: "openid example"})
+	idpmock.SetupResponse(idpClient, "narrow-token", userInfo("3"), idp.TokenIntrospectionResponse{Active: true, Scope: "openid"})
+
+	conf := SecurityOptions{
+		IDPClient:             idpClient,
+		AllowedAudiences:      []string{"my-audience"},
+		RequiredScopes:        []string{"example"},
+		AccessTokenValidation: TokenValidationUserinfo,
+	}
+
+	testcases := []struct {
+		name       string
+		authHeader string
+		expectErr  string
+	}{
+		{
+			name:       "active",
+			authHeader: "active-token",
+		},
+		{
+			name:       "inactive",
+			authHeader: "inactive-token",
+			expectErr:  "token introspection reports token as not active",
+		},
+		{
+			name:       "missing_scope",
+			authHeader: "narrow-token",
+			expectErr:  "token does not have all required scopes",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, msg, err := checkAllAuthentication(context.Background(), http.MethodGet, "/a", &conf, "", tc.authHeader, "")
+			if tc.expectErr != "" {
+				require.Equal(t, "invalid bearer token", msg)
+				require.EqualError(t, err, tc.expectErr)
+				require.Nil(t, common.GetClaims(ctx))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "1", common.GetSubject(ctx))
+				require.True(t, common.HasScope(ctx, "example"))
+			}
+		})
+	}
+}
+
+func TestCheckAllAuthentication_MultiIssuer(t *testing.T) {
+	oldIDP := idpmock.New()
+	require.NoError(t, oldIDP.SetupFromWellKnown(context.Background()))
+	newIDP := idpmock.NewNamed("new", "new-issuer")
+	require.NoError(t, newIDP.SetupFromWellKnown(context.Background()))
+
+	idpmock.SetupResponse(newIDP, "new-opaque-token", idp.UserinfoResponse{
+		Audience: []string{"new-audience"},
+		Subject:  "5678",
+	}, idp.TokenIntrospectionResponse{})
+
+	conf := SecurityOptions{
+		IDPClient:            oldIDP,
+		AdditionalIDPClients: []idp.IdentityProviderClient{newIDP},
+		AllowedAudiences:     []string{"old-audience"},
+		IssuerAllowedAudiences: map[string][]string{
+			"new": {"new-audience"},
+		},
+	}
+
+	signed := func(signer idp.IdentityProviderClient, issuer string, audience string) string {
+		return idpmock.SignToken(signer, common.AllClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				Subject:   "1234",
+				Audience:  jwt.ClaimStrings{audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		})
+	}
+
+	testcases := []struct {
+		name         string
+		validation   string
+		authHeader   string
+		expectIssuer string
+		expectErr    string
+	}{
+		{
+			name:         "old_issuer",
+			validation:   TokenValidationJWKS,
+			authHeader:   signed(oldIDP, "", "old-audience"),
+			expectIssuer: "mock-issuer",
+		},
+		{
+			name:         "new_issuer",
+			validation:   TokenValidationJWKS,
+			authHeader:   signed(newIDP, "", "new-audience"),
+			expectIssuer: "new-issuer",
+		},
+		{
+			name:       "new_issuer_audience_of_old",
+			validation: TokenValidationJWKS,
+			authHeader: signed(newIDP, "", "old-audience"),
+			expectErr:  "token audience does not contain a match",
+		},
+		{
+			name:       "new_issuer_signed_by_old",
+			validation: TokenValidationJWKS,
+			authHeader: signed(oldIDP, "new-issuer", "new-audience"),
+			expectErr:  "token signature is invalid",
+		},
+		{
+			name:       "unknown_issuer",
+			validation: TokenValidationJWKS,
+			authHeader: signed(newIDP, "other-issuer", "new-audience"),
+			expectErr:  "token issuer 'other-issuer' is not accepted",
+		},
+		{
+			name:         "opaque_tried_in_order",
+			validation:   TokenValidationUserinfo,
+			authHeader:   "new-opaque-token",
+			expectIssuer: "new-issuer",
+		},
+		{
+			name:       "opaque_unknown",
+			validation: TokenValidationUserinfo,
+			authHeader: "unknown-opaque-token",
+			expectErr:  "unknown token",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf.AccessTokenValidation = tc.validation
+			ctx, msg, err := checkAllAuthentication(context.Background(), http.MethodGet, "/a", &conf, "", tc.authHeader, "")
+			if tc.expectErr != "" {
+				require.Equal(t, "invalid bearer token", msg)
+				require.Error(t, err)
+				require.True(t, strings.Contains(err.Error(), tc.expectErr), "unexpected error: %s", err.Error())
+				require.Nil(t, common.GetClaims(ctx))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectIssuer, common.GetClaims(ctx).Issuer)
+			}
+		})
+	}
+}
+
+func TestCheckAllAuthentication_IDToken(t *testing.T) {
+	idpClient := idpmock.New()
+	require.NoError(t, idpClient.SetupFromWellKnown(context.Background()))
+	idpmock.SetupResponse(idpClient, "access-token", idp.UserinfoResponse{
+		Audience: []string{"my-audience"},
+		Subject:  "1234",
+		Groups:   []string{"staff"},
+	}, idp.TokenIntrospectionResponse{})
+
+	conf := SecurityOptions{
+		IDPClient:               idpClient,
+		AllowedAudiences:        []string{"my-audience"},
+		IDTokenAllowedAudiences: []string{"my-frontend"},
+		AccessTokenValidation:   TokenValidationUserinfo,
+	}
+
+	idToken := func(subject string, audience string) string {
+		return idpmock.SignToken(idpClient, common.AllClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   subject,
+				Audience:  jwt.ClaimStrings{audience},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			CustomClaims: common.CustomClaims{
+				EMail: "demouser@example.com",
+				Name:  "John Doe",
+			},
+		})
+	}
+
+	testcases := []struct {
+		name        string
+		accessToken string
+		idToken     string
+		expectMsg   string
+		expectErr   string
+		expectEMail string
+	}{
+		{
+			name:        "access_token_only",
+			accessToken: "access-token",
+		},
+		{
+			name:        "matching_id_token",
+			accessToken: "access-token",
+			idToken:     idToken("1234", "my-frontend"),
+			expectEMail: "demouser@example.com",
+		},
+		{
+			name:        "subject_mismatch",
+			accessToken: "access-token",
+			idToken:     idToken("5678", "my-frontend"),
+			expectMsg:   "invalid id token",
+			expectErr:   "id token subject '5678' does not match access token subject '1234'",
+		},
+		{
+			name:        "wrong_audience",
+			accessToken: "access-token",
+			idToken:     idToken("1234", "other-audience"),
+			expectMsg:   "invalid id token",
+			expectErr:   "request failed id token check, denying: token audience does not contain a match",
+		},
+		{
+			name:        "access_token_audience",
+			accessToken: "access-token",
+			idToken:     idToken("1234", "my-audience"),
+			expectMsg:   "invalid id token",
+			expectErr:   "request failed id token check, denying: token audience does not contain a match",
+		},
+		{
+			name:        "not_signed",
+			accessToken: "access-token",
+			idToken:     "not-a-jwt",
+			expectMsg:   "invalid id token",
+			expectErr:   "request failed id token check, denying: token is malformed",
+		},
+		{
+			name:      "id_token_without_access_token",
+			idToken:   idToken("1234", "my-frontend"),
+			expectMsg: "you must be logged in for this operation",
+			expectErr: "no authorization presented",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, msg, err := checkAllAuthentication(context.Background(), http.MethodGet, "/a", &conf, "", tc.accessToken, tc.idToken)
+			require.Equal(t, tc.expectMsg, msg)
+			if tc.expectErr != "" {
+				require.Error(t, err)
+				require.True(t, strings.HasPrefix(err.Error(), tc.expectErr), "unexpected error: %s", err.Error())
+				require.Equal(t, "", common.GetIDToken(ctx))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "1234", common.GetSubject(ctx))
+				require.Equal(t, tc.idToken, common.GetIDToken(ctx))
+				require.Equal(t, tc.expectEMail, common.GetClaims(ctx).EMail)
+				require.Equal(t, []string{"staff"}, common.GetGroups(ctx), "groups from userinfo are kept")
+			}
+		})
+	}
+}
+
+func TestOpenEndpoints(t *testing.T) {
+	conf := SecurityOptions{
+		OpenEndpoints: []string{
+			"GET /",
+			"GET /api/rest/v1/example",
+			"POST /api/rest/v1/example/public",
+			"* /api/rest/v1/info/*",
+			"PUT /api/rest/v1/things/{id}",
+			"GET /api/rest/v1/numbers/{id:[0-9]+}",
+			"GET /api/rest/v1/letters/{id:[0-9]+}",
+		},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	router := chi.NewRouter()
+	router.Use(CheckRequestAuthorization(&conf))
+	router.Get("/", ok)
+	router.Route("/api/rest/v1/example", func(sr chi.Router) {
+		sr.Get("/", ok)
+		sr.Post("/{category}", ok)
+	})
+	router.Get("/api/rest/v1/info/health", ok)
+	router.Delete("/api/rest/v1/info/cache/{key}", ok)
+	router.Put("/api/rest/v1/things/{id}", ok)
+	router.Delete("/api/rest/v1/things/{id}", ok)
+	router.Get("/api/rest/v1/numbers/{id:[0-9]+}", ok)
+	router.Get("/api/rest/v1/letters/{id:[a-z]+}", ok)
+
+	testcases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{name: "root", method: http.MethodGet, path: "/", expectedStatus: http.StatusOK},
+		{name: "exact", method: http.MethodGet, path: "/api/rest/v1/example", expectedStatus: http.StatusOK},
+		{name: "trailing_slash", method: http.MethodGet, path: "/api/rest/v1/example/", expectedStatus: http.StatusOK},
+		{name: "wrong_method", method: http.MethodPost, path: "/api/rest/v1/example", expectedStatus: http.StatusUnauthorized},
+		{name: "param_route_not_opened_by_literal", method: http.MethodPost, path: "/api/rest/v1/example/public", expectedStatus: http.StatusUnauthorized},
+		{name: "param_route_encoded", method: http.MethodPost, path: "/api/rest/v1/example/pub%6Cic", expectedStatus: http.StatusUnauthorized},
+		{name: "wildcard_any_method", method: http.MethodGet, path: "/api/rest/v1/info/health", expectedStatus: http.StatusOK},
+		{name: "wildcard_any_method_nested", method: http.MethodDelete, path: "/api/rest/v1/info/cache/abc", expectedStatus: http.StatusOK},
+		{name: "wildcard_encoded_slash", method: http.MethodDelete, path: "/api/rest/v1/info/cache/a%2Fb", expectedStatus: http.StatusOK},
+		{name: "param", method: http.MethodPut, path: "/api/rest/v1/things/42", expectedStatus: http.StatusOK},
+		{name: "param_encoded", method: http.MethodPut, path: "/api/rest/v1/things/4%2F2", expectedStatus: http.StatusOK},
+		{name: "param_trailing_slash_is_other_route", method: http.MethodPut, path: "/api/rest/v1/things/42/", expectedStatus: http.StatusUnauthorized},
+		{name: "param_other_method", method: http.MethodDelete, path: "/api/rest/v1/things/42", expectedStatus: http.StatusUnauthorized},
+		{name: "path_traversal", method: http.MethodPut, path: "/api/rest/v1/info/../things/42/x", expectedStatus: http.StatusUnauthorized},
+		{name: "encoded_traversal", method: http.MethodDelete, path: "/api/rest/v1/info%2F..%2Fthings/42", expectedStatus: http.StatusUnauthorized},
+		{name: "unknown_route", method: http.MethodGet, path: "/api/rest/v1/unknown", expectedStatus: http.StatusUnauthorized},
+		{name: "regex_param", method: http.MethodGet, path: "/api/rest/v1/numbers/42", expectedStatus: http.StatusOK},
+		{name: "regex_param_not_routed", method: http.MethodGet, path: "/api/rest/v1/numbers/abc", expectedStatus: http.StatusUnauthorized},
+		{name: "regex_param_other_regex", method: http.MethodGet, path: "/api/rest/v1/letters/abc", expectedStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest(tc.method, tc.path, nil)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+			require.Equal(t, tc.expectedStatus, recorder.Code)
+		})
+	}
+}
+
+func TestParseOpenEndpoints(t *testing.T) {
+	testcases := []struct {
+		name      string
+		value     string
+		expectErr string
+	}{
+		{name: "default", value: `["GET /"]`},
+		{name: "patterns", value: `["GET /a/{b}", "* /c/*", "delete /d"]`},
+		{name: "not_json", value: `GET /`, expectErr: "failed to parse open endpoint configuration: invalid character 'G' looking for beginning of value"},
+		{name: "no_method", value: `["/a"]`, expectErr: "failed to parse open endpoint configuration: invalid route '/a', format is 'METHOD PATTERN'"},
+		{name: "relative", value: `["GET a/b"]`, expectErr: "failed to parse open endpoint configuration: invalid route 'GET a/b', pattern must start with '/'"},
+		{name: "unknown_method", value: `["FETCH /a"]`, expectErr: "failed to parse open endpoint configuration: invalid route 'FETCH /a': chi: 'FETCH' http method is not supported."},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseOpenEndpoints(tc.value)
+			if tc.expectErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestValidateIDTokenAudiences(t *testing.T) {
+	_ = auconfigenv.Setup(nil, nil)
+	defer auconfigenv.Set(ConfOIDCIDTokenCookieName, "")
+
+	auconfigenv.Set(ConfOIDCIDTokenCookieName, "")
+	auconfigenv.Set(ConfOIDCIDTokenAudiences, "")
+	require.NoError(t, validateIDTokenAudiences(ConfOIDCIDTokenAudiences))
+
+	auconfigenv.Set(ConfOIDCIDTokenCookieName, "AUTH")
+	require.EqualError(t, validateIDTokenAudiences(ConfOIDCIDTokenAudiences), "OIDC_ID_TOKEN_ALLOWED_AUDIENCES is required if OIDC_ID_TOKEN_COOKIE_NAME is set")
+
+	auconfigenv.Set(ConfOIDCIDTokenAudiences, "my-frontend")
+	require.NoError(t, validateIDTokenAudiences(ConfOIDCIDTokenAudiences))
+}
+
 func TestListsIntersect(t *testing.T) {
 	testcases := []struct {
 		name     string
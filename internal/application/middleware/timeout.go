@@ -1,11 +1,30 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/go-chi/chi/v5"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 )
 
+// Timeout creates a middleware that sends a standardized error response if a request is not
+// processed within the timeout.
+//
+// The handler runs with a context that expires after the timeout. Its output is buffered, and
+// only sent once it has completed in time. Anything it writes after the timeout is discarded,
+// so handlers that ignore the context cannot delay the response until the server timeouts hit.
+//
+// Such handlers keep running after the response, and keep their ConcurrencyLimiting slots until they finish.
+// If they panic, the panic is logged and reported from their goroutine, because PanicRecoverer has already returned.
+//
+// Place it after ConcurrencyLimiting, but before CheckRequestAuthorization, so the timeout also bounds
+// calls to the identity provider, and any other work the later middlewares do.
 func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -14,7 +33,141 @@ func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
 			ctx, cancel := context.WithTimeout(ctx, timeout)
 			defer cancel()
 
-			next.ServeHTTP(w, r.WithContext(ctx))
+			// the handler gets its own routing context, so it does not touch ours after a timeout
+			outer := chi.RouteContext(ctx)
+			if outer != nil {
+				ctx = context.WithValue(ctx, chi.RouteCtxKey, copyRouteContext(outer))
+			}
+
+			tw := &timeoutWriter{
+				header: make(http.Header),
+			}
+
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			// closed however the handler ends, unlike done
+			finished := make(chan struct{})
+			handlerRequest := r.WithContext(ctx)
+			go func() {
+				defer close(finished)
+				defer func() {
+					if p := recover(); p != nil {
+						p = wrapPanic(p)
+
+						tw.mutex.Lock()
+						late := tw.timedOut
+						if !late {
+							panicked <- p
+						}
+						tw.mutex.Unlock()
+
+						if late {
+							// nobody is waiting for the panic any more
+							reportLatePanic(handlerRequest, p)
+						}
+					}
+				}()
+				next.ServeHTTP(tw, handlerRequest)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				// re-panic on the request goroutine, so PanicRecoverer can handle it
+				panic(p)
+			case <-done:
+				tw.mutex.Lock()
+				defer tw.mutex.Unlock()
+
+				if outer != nil {
+					inner := chi.RouteContext(ctx)
+					outer.RoutePatterns = inner.RoutePatterns
+					outer.URLParams = inner.URLParams
+				}
+				tw.writeTo(w)
+			case <-ctx.Done():
+				tw.mutex.Lock()
+				defer tw.mutex.Unlock()
+
+				tw.timedOut = true
+				if outer != nil {
+					// so RequestMetrics can still label the request
+					outer.RoutePatterns = []string{requestRoutePattern(r)}
+				}
+				markRequestTimedOut(r.Context())
+				markHandlerStillRunning(r.Context(), finished)
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(ctx.Err()).Printf("request timed out after %s, discarding any response", timeout)
+				web.SendRequestTimeoutResponse(ctx, w, fmt.Sprintf("request was not processed within %s", timeout))
+
+				select {
+				case p := <-panicked:
+					// the handler panicked just as the request timed out
+					reportLatePanic(handlerRequest, p)
+				default:
+				}
+			}
 		})
 	}
 }
+
+func copyRouteContext(rctx *chi.Context) *chi.Context {
+	result := chi.NewRouteContext()
+	result.Routes = rctx.Routes
+	result.RoutePath = rctx.RoutePath
+	result.RouteMethod = rctx.RouteMethod
+	result.URLParams.Keys = slices.Clone(rctx.URLParams.Keys)
+	result.URLParams.Values = slices.Clone(rctx.URLParams.Values)
+	result.RoutePatterns = slices.Clone(rctx.RoutePatterns)
+	return result
+}
+
+// timeoutWriter buffers the response, so it can be discarded if the request times out.
+type timeoutWriter struct {
+	header   http.Header
+	status   int
+	buffer   bytes.Buffer
+	timedOut bool
+	mutex    sync.Mutex
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buffer.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// writeTo sends the buffered response.
+//
+// Must be called with the mutex held.
+func (tw *timeoutWriter) writeTo(w http.ResponseWriter) {
+	dst := w.Header()
+	for key, values := range tw.header {
+		dst[key] = values
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	w.WriteHeader(tw.status)
+	_, _ = w.Write(tw.buffer.Bytes())
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout_InTime(t *testing.T) {
+	cut := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/somewhere")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+
+	response := httptest.NewRecorder()
+	cut.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	require.Equal(t, http.StatusCreated, response.Code)
+	require.Equal(t, "/somewhere", response.Header().Get("Location"))
+	require.Equal(t, "created", response.Body.String())
+}
+
+func TestTimeout_TimedOut(t *testing.T) {
+	release := make(chan struct{})
+	lateWrite := make(chan error)
+	cut := Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Location", "/somewhere")
+		w.WriteHeader(http.StatusCreated)
+		_, err := w.Write([]byte("created"))
+		lateWrite <- err
+	}))
+
+	response := httptest.NewRecorder()
+	cut.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	require.Equal(t, http.StatusServiceUnavailable, response.Code)
+	require.Contains(t, response.Body.String(), `"message":"request.timeout"`)
+
+	close(release)
+	require.ErrorIs(t, <-lateWrite, http.ErrHandlerTimeout)
+	require.Equal(t, http.StatusServiceUnavailable, response.Code, "late writes must be discarded")
+	require.Empty(t, response.Header().Get("Location"), "late headers must be discarded")
+	require.NotContains(t, response.Body.String(), "created", "late writes must be discarded")
+}
+
+func TestTimeout_Panic(t *testing.T) {
+	cut := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oh no")
+	}))
+
+	defer func() {
+		p, ok := recover().(*recoveredPanic)
+		require.True(t, ok, "panics must reach the request goroutine, so PanicRecoverer can handle them")
+		require.Equal(t, "oh no", p.value)
+		require.Contains(t, p.stack[0], "TestTimeout_Panic", "the stack must show where the panic happened")
+	}()
+	cut.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Fail(t, "panic was swallowed")
+}
+
+func TestTimeout_Metrics(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(RequestMetrics())
+	router.Use(Timeout(20 * time.Millisecond))
+	router.Get("/tst/timeout/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+	})
+	router.Get("/tst/timeout/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	timedOut := reqs.WithLabelValues(http.MethodGet, "TIMEOUT", "503", "/tst/timeout/slow")
+	failed := reqs.WithLabelValues(http.MethodGet, "SERVER_ERROR", "503", "/tst/timeout/slow")
+	broken := reqs.WithLabelValues(http.MethodGet, "SERVER_ERROR", "503", "/tst/timeout/broken")
+	timedOutBefore, failedBefore, brokenBefore := testutil.ToFloat64(timedOut), testutil.ToFloat64(failed), testutil.ToFloat64(broken)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tst/timeout/slow", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tst/timeout/broken", nil))
+
+	require.Equal(t, timedOutBefore+1, testutil.ToFloat64(timedOut))
+	require.Equal(t, failedBefore, testutil.ToFloat64(failed))
+	require.Equal(t, brokenBefore+1, testutil.ToFloat64(broken), "other 503 responses must not count as timeouts")
+}
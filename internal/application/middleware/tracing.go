@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Tracing records each incoming request as a server span, continuing the caller's trace if there is one.
+//
+// The span is named after the route pattern, so requests to the same endpoint can be grouped.
+//
+// Place it below RequestID, so the span includes the request id, and above AddRequestScopedLoggerToContext,
+// so log messages include the trace and span ids.
+func Tracing(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		name := r.Method
+		routePattern := requestRoutePattern(r)
+		if routePattern != "" {
+			name = r.Method + " " + routePattern
+		}
+
+		ctx, span := tracing.Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", routePattern),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String(RequestIdFieldName, common.GetRequestID(ctx)),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", ww.Status()))
+		if ww.Status() >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
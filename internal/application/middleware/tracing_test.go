@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"bytes"
+	"github.com/Roshick/go-autumn-slog/pkg/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(previousProvider)
+
+	logs := &bytes.Buffer{}
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	defer slog.SetDefault(previousLogger)
+
+	router := chi.NewRouter()
+	router.Use(Tracing)
+	router.Use(AddRequestScopedLoggerToContext)
+	router.Get("/tst/trace/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("tst message")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/tst/trace/4711", nil)
+	request.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	require.Equal(t, "GET /tst/trace/{id}", span.Name())
+	require.Equal(t, trace.SpanKindServer, span.SpanKind())
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String(), "must continue the caller's trace")
+	require.Equal(t, "b7ad6b7169203331", span.Parent().SpanID().String())
+	require.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusServiceUnavailable))
+	require.Equal(t, "Error", span.Status().Code.String())
+
+	require.Contains(t, logs.String(), `"trace.id":"0af7651916cd43dd8448eb211c80319c"`)
+	require.Contains(t, logs.String(), `"span.id":"`+span.SpanContext().SpanID().String()+`"`)
+}
@@ -6,16 +6,19 @@ import (
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/errorreport"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 	"time"
 )
 
-func Router(ctx context.Context, idpClient idp.IdentityProviderClient) (chi.Router, error) {
+func Router(ctx context.Context, errorReporter errorreport.Reporter, auditor audit.Auditor, idpClient idp.IdentityProviderClient, additionalIDPClients ...idp.IdentityProviderClient) (chi.Router, error) {
 	router := chi.NewMux()
 
-	err := setupMiddlewareStack(ctx, router, idpClient)
+	err := setupMiddlewareStack(ctx, router, errorReporter, auditor, idpClient, additionalIDPClients)
 	if err != nil {
 		return nil, err
 	}
@@ -23,6 +26,19 @@ func Router(ctx context.Context, idpClient idp.IdentityProviderClient) (chi.Rout
 	return router, nil
 }
 
+// CheckRoutes lists all routes with their route level authorization rules, and warns about
+// open endpoints that match none of them.
+//
+// Call this after all controllers have registered their routes.
+func CheckRoutes(ctx context.Context, router chi.Router) error {
+	securityOptions := middleware.SecurityOptionsPartialFromConfig()
+	if err := middleware.WarnUnmatchedOpenEndpoints(ctx, &securityOptions, router); err != nil {
+		return err
+	}
+
+	return web.LogRouteAuthorization(ctx, router)
+}
+
 func Serve(ctx context.Context, handler http.Handler) error {
 	options := Options{
 		BaseCtx:      ctx,
@@ -121,24 +137,38 @@ func ConfigItems() []auconfigapi.ConfigItem {
 	}
 }
 
-func setupMiddlewareStack(ctx context.Context, router chi.Router, idpClient idp.IdentityProviderClient) error {
-	router.Use(middleware.RequestID)
+func setupMiddlewareStack(ctx context.Context, router chi.Router, errorReporter errorreport.Reporter, auditor audit.Auditor, idpClient idp.IdentityProviderClient, additionalIDPClients []idp.IdentityProviderClient) error {
+	requestIDOptions := middleware.RequestIDOptionsFromConfig()
+	router.Use(middleware.RequestID(&requestIDOptions))
+	router.Use(middleware.Tracing)
 
 	router.Use(middleware.AddRequestScopedLoggerToContext)
 	router.Use(middleware.RequestLogger)
 
-	router.Use(middleware.PanicRecoverer)
+	recovererOptions := middleware.RecovererOptions{Reporter: errorReporter}
+	router.Use(middleware.PanicRecoverer(&recovererOptions))
 
 	corsOptions := middleware.CorsOptionsFromConfig()
 	router.Use(middleware.CorsHeaders(&corsOptions))
 
 	router.Use(middleware.RequestMetrics())
 
+	concurrencyOptions := middleware.ConcurrencyOptionsFromConfig()
+	router.Use(middleware.ConcurrencyLimiting(&concurrencyOptions))
+
+	router.Use(middleware.Timeout(aToSeconds(auconfigenv.Get(ConfRequestTimeoutSeconds))))
+
 	securityOptions := middleware.SecurityOptionsPartialFromConfig()
 	securityOptions.IDPClient = idpClient
+	securityOptions.AdditionalIDPClients = additionalIDPClients
 	router.Use(middleware.CheckRequestAuthorization(&securityOptions))
 
-	router.Use(middleware.Timeout(aToSeconds(auconfigenv.Get(ConfRequestTimeoutSeconds))))
+	auditOptions := middleware.AuditOptionsPartialFromConfig()
+	auditOptions.Auditor = auditor
+	router.Use(middleware.AuditRequests(&auditOptions))
+
+	rateLimitOptions := middleware.RateLimitOptionsFromConfig()
+	router.Use(middleware.RateLimiting(&rateLimitOptions))
 
 	return nil
 }
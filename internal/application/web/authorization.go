@@ -0,0 +1,152 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+)
+
+// AuthorizationRule is a route level permission check.
+//
+// Rules run after the security middleware, so the claims of the caller are available in the context.
+type AuthorizationRule struct {
+	// Description is listed during startup, so it should say in a few words what the rule requires.
+	Description string
+
+	// Allowed returns true if the caller may access the route.
+	Allowed func(ctx context.Context) bool
+}
+
+// Authorize creates a middleware that enforces the given rule, answering 403 if the rule is not met.
+//
+// Attach it when registering a route, for example
+//
+//	router.With(web.RequireGroups("admin")).Method(http.MethodPost, "/", handler)
+func Authorize(rule AuthorizationRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &authorizedHandler{
+			rule: rule,
+			next: next,
+		}
+	}
+}
+
+// RequireGroups allows access if the caller is in at least one of the given groups.
+//
+// Requests authorized by api key use the groups configured for the key.
+func RequireGroups(groups ...string) func(http.Handler) http.Handler {
+	return Authorize(AuthorizationRule{
+		Description: fmt.Sprintf("any of groups [%s]", strings.Join(groups, ", ")),
+		Allowed: func(ctx context.Context) bool {
+			for _, group := range groups {
+				if common.HasGroup(ctx, group) {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
+
+// RequireRoles allows access if the caller has at least one of the given application roles.
+func RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return Authorize(AuthorizationRule{
+		Description: fmt.Sprintf("any of roles [%s]", strings.Join(roles, ", ")),
+		Allowed: func(ctx context.Context) bool {
+			for _, role := range roles {
+				if common.HasRole(ctx, role) {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
+
+// RequirePermissions allows access if the caller has all the given permissions through their roles.
+func RequirePermissions(permissions ...string) func(http.Handler) http.Handler {
+	return Authorize(AuthorizationRule{
+		Description: fmt.Sprintf("all of permissions [%s]", strings.Join(permissions, ", ")),
+		Allowed: func(ctx context.Context) bool {
+			for _, permission := range permissions {
+				if !common.HasPermission(ctx, permission) {
+					return false
+				}
+			}
+			return true
+		},
+	})
+}
+
+// RequireScopes allows access if the token of the caller has all the given scopes.
+//
+// Scopes are only known if access tokens are validated locally, or if token introspection is in use.
+//
+// Requests authorized by api key are not subject to scope checks.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return Authorize(AuthorizationRule{
+		Description: fmt.Sprintf("all of scopes [%s]", strings.Join(scopes, ", ")),
+		Allowed: func(ctx context.Context) bool {
+			if common.GetAPIKeyName(ctx) != "" {
+				return true
+			}
+			for _, scope := range scopes {
+				if !common.HasScope(ctx, scope) {
+					return false
+				}
+			}
+			return true
+		},
+	})
+}
+
+type authorizedHandler struct {
+	rule AuthorizationRule
+	next http.Handler
+}
+
+func (h *authorizedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if !h.rule.Allowed(ctx) {
+		aulogging.Infof(ctx, "access denied for subject %s api key %s: requires %s", common.GetSubject(ctx), common.GetAPIKeyName(ctx), h.rule.Description)
+		SendForbiddenResponse(ctx, w, "you are not authorized for this operation")
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// RouteAuthorization describes the route level authorization rules among the given middlewares.
+//
+// Middlewares are identified by applying them to a dummy handler, so this relies on middleware
+// constructors not having side effects, which is true for everything in this application.
+func RouteAuthorization(middlewares ...func(http.Handler) http.Handler) []string {
+	result := make([]string, 0)
+	probe := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, middleware := range middlewares {
+		if authorized, ok := middleware(probe).(*authorizedHandler); ok {
+			result = append(result, authorized.rule.Description)
+		}
+	}
+	return result
+}
+
+// LogRouteAuthorization lists all registered routes together with their route level authorization rules.
+//
+// Call this after all routes have been registered.
+func LogRouteAuthorization(ctx context.Context, router chi.Routes) error {
+	return chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		rules := RouteAuthorization(middlewares...)
+		if len(rules) == 0 {
+			aulogging.Infof(ctx, "route %s %s: authentication only", method, route)
+		} else {
+			aulogging.Infof(ctx, "route %s %s: requires %s", method, route, strings.Join(rules, " and "))
+		}
+		return nil
+	})
+}
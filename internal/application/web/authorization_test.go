@@ -0,0 +1,160 @@
+package web
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizationRules(t *testing.T) {
+	withClaims := func(claims common.AllClaims) context.Context {
+		return context.WithValue(context.Background(), common.CtxKeyClaims{}, &claims)
+	}
+	withApiKey := func(name string, groups ...string) context.Context {
+		ctx := context.WithValue(context.Background(), common.CtxKeyAPIKey{}, name)
+		return context.WithValue(ctx, common.CtxKeyAPIKeyGroups{}, groups)
+	}
+
+	withRoles := func(roles []string, permissions ...string) context.Context {
+		ctx := context.WithValue(context.Background(), common.CtxKeyRoles{}, roles)
+		return context.WithValue(ctx, common.CtxKeyPermissions{}, permissions)
+	}
+
+	staff := withClaims(common.AllClaims{CustomClaims: common.CustomClaims{Groups: []string{"staff"}, Scope: "openid example"}})
+	admin := withClaims(common.AllClaims{CustomClaims: common.CustomClaims{Groups: []string{"staff", "admin"}, Scope: "openid"}})
+
+	testcases := []struct {
+		name           string
+		rule           func(http.Handler) http.Handler
+		ctx            context.Context
+		expectedStatus int
+	}{
+		{
+			name:           "group_present",
+			rule:           RequireGroups("admin"),
+			ctx:            admin,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "any_group_suffices",
+			rule:           RequireGroups("admin", "staff"),
+			ctx:            staff,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "group_missing",
+			rule:           RequireGroups("admin"),
+			ctx:            staff,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "group_anonymous",
+			rule:           RequireGroups("admin"),
+			ctx:            context.Background(),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "group_api_key",
+			rule:           RequireGroups("admin"),
+			ctx:            withApiKey("backend", "admin"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "group_api_key_without_group",
+			rule:           RequireGroups("admin"),
+			ctx:            withApiKey("backend"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "role_present",
+			rule:           RequireRoles("admin", "regdesk"),
+			ctx:            withRoles([]string{"regdesk"}),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "role_missing",
+			rule:           RequireRoles("admin"),
+			ctx:            withRoles([]string{"regdesk", "staff"}),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "permissions_present",
+			rule:           RequirePermissions("attendee.read", "attendee.write"),
+			ctx:            withRoles([]string{"admin"}, "attendee.read", "attendee.write"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "permission_missing",
+			rule:           RequirePermissions("attendee.read", "attendee.write"),
+			ctx:            withRoles([]string{"staff"}, "attendee.read"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "permission_anonymous",
+			rule:           RequirePermissions("attendee.read"),
+			ctx:            context.Background(),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "scopes_api_key",
+			rule:           RequireScopes("openid", "example"),
+			ctx:            withApiKey("backend"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "scopes_present",
+			rule:           RequireScopes("openid", "example"),
+			ctx:            staff,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "scope_missing",
+			rule:           RequireScopes("openid", "example"),
+			ctx:            admin,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := tc.rule(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(tc.ctx)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			require.Equal(t, tc.expectedStatus, recorder.Code)
+			if tc.expectedStatus == http.StatusForbidden {
+				require.Contains(t, recorder.Body.String(), `"message":"auth.forbidden"`)
+			}
+		})
+	}
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler { return next })
+	router.Get("/open", noop)
+	router.With(RequireGroups("admin")).Post("/admin", noop)
+	router.With(RequireGroups("staff", "admin"), RequireScopes("example")).Put("/both", noop)
+
+	found := make(map[string][]string)
+	err := chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		found[method+" "+route] = RouteAuthorization(middlewares...)
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, map[string][]string{
+		"GET /open":   {},
+		"POST /admin": {"any of groups [admin]"},
+		"PUT /both":   {"any of groups [staff, admin]", "all of scopes [example]"},
+	}, found)
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
@@ -68,17 +69,50 @@ func SendErrorWithStatusAndMessage(ctx context.Context, w http.ResponseWriter, s
 // If the encoding fails, the http status will not be written to the response writer
 // and the function will return an error instead.
 func EncodeWithStatus[T any](status int, value *T, w http.ResponseWriter) error {
-	err := json.NewEncoder(w).Encode(value)
+	buffer := &bytes.Buffer{}
+	err := json.NewEncoder(buffer).Encode(value)
 	if err != nil {
 		return errors.Wrap(err, "could not encode type into response buffer")
 	}
 
 	w.WriteHeader(status)
-
-	return nil
+	_, err = w.Write(buffer.Bytes())
+	return err
 }
 
 // SendUnauthorizedResponse sends a standardized StatusUnauthorized response to the client.
 func SendUnauthorizedResponse(ctx context.Context, w http.ResponseWriter, details string) {
 	SendErrorWithStatusAndMessage(ctx, w, http.StatusUnauthorized, common.AuthUnauthorized, details)
 }
+
+// SendForbiddenResponse sends a standardized StatusForbidden response to the client.
+func SendForbiddenResponse(ctx context.Context, w http.ResponseWriter, details string) {
+	SendErrorWithStatusAndMessage(ctx, w, http.StatusForbidden, common.AuthForbidden, details)
+}
+
+// SendServiceUnavailableResponse sends a standardized StatusServiceUnavailable response to the client,
+// for requests that cannot be authenticated because the identity provider cannot be reached.
+func SendServiceUnavailableResponse(ctx context.Context, w http.ResponseWriter, details string) {
+	SendErrorWithStatusAndMessage(ctx, w, http.StatusServiceUnavailable, common.AuthUnavailable, details)
+}
+
+// SendTooManyRequestsResponse sends a standardized StatusTooManyRequests response to the client.
+//
+// Set the Retry-After header before calling this.
+func SendTooManyRequestsResponse(ctx context.Context, w http.ResponseWriter, details string) {
+	SendErrorWithStatusAndMessage(ctx, w, http.StatusTooManyRequests, common.RequestRateLimited, details)
+}
+
+// SendServiceOverloadedResponse sends a StatusServiceUnavailable response to the client, for requests
+// that are shed because too many are in progress.
+//
+// Set the Retry-After header before calling this.
+func SendServiceOverloadedResponse(ctx context.Context, w http.ResponseWriter, details string) {
+	SendErrorWithStatusAndMessage(ctx, w, http.StatusServiceUnavailable, common.ServiceOverloaded, details)
+}
+
+// SendRequestTimeoutResponse sends a StatusServiceUnavailable response to the client, for requests
+// that were not processed in time.
+func SendRequestTimeoutResponse(ctx context.Context, w http.ResponseWriter, details string) {
+	SendErrorWithStatusAndMessage(ctx, w, http.StatusServiceUnavailable, common.RequestTimeout, details)
+}
@@ -0,0 +1,27 @@
+package web
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEncodeWithStatus(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	err := EncodeWithStatus(http.StatusCreated, &testResponse{Counter: 3}, recorder)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, recorder.Code, "must write the status before the body")
+	require.JSONEq(t, `{"Counter":3}`, recorder.Body.String())
+}
+
+func TestEncodeWithStatus_EncodingFails(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	err := EncodeWithStatus(http.StatusOK, &map[string]interface{}{"invalid": make(chan int)}, recorder)
+
+	require.Error(t, err)
+	require.Empty(t, recorder.Body.String(), "must not write a partial body")
+}
@@ -0,0 +1,100 @@
+package adminctl
+
+import (
+	"fmt"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/events"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+const (
+	overrideIDParam = "id"
+	eventIDParam    = "id"
+)
+
+// permissionLogging allows viewing and changing log levels. Grant it to roles in the ROLES configuration.
+const permissionLogging = "logging.manage"
+
+// permissionEvents allows listing and replaying domain events. Grant it to roles in the ROLES configuration.
+const permissionEvents = "events.manage"
+
+type Controller struct {
+	auditor audit.Auditor
+	outbox  events.Outbox
+}
+
+func InitRoutes(router chi.Router, auditor audit.Auditor, outbox events.Outbox) {
+	h := &Controller{
+		auditor: auditor,
+		outbox:  outbox,
+	}
+
+	router.Route("/api/rest/v1/admin", func(sr chi.Router) {
+		initLoggingRoutes(sr, h)
+		initEventRoutes(sr, h)
+	})
+}
+
+func initLoggingRoutes(router chi.Router, h *Controller) {
+	router = router.With(web.RequirePermissions(permissionLogging))
+	router.Method(
+		http.MethodGet,
+		"/logging",
+		web.CreateHandler(
+			h.GetLogging,
+			h.GetLoggingRequest,
+			h.GetLoggingResponse,
+		),
+	)
+	router.Method(
+		http.MethodPut,
+		"/logging/level",
+		web.CreateHandler(
+			h.SetLogLevel,
+			h.SetLogLevelRequest,
+			h.SetLogLevelResponse,
+		),
+	)
+	router.Method(
+		http.MethodPost,
+		"/logging/overrides",
+		web.CreateHandler(
+			h.AddLogLevelOverride,
+			h.AddLogLevelOverrideRequest,
+			h.AddLogLevelOverrideResponse,
+		),
+	)
+	router.Method(
+		http.MethodDelete,
+		fmt.Sprintf("/logging/overrides/{%s}", overrideIDParam),
+		web.CreateHandler(
+			h.RemoveLogLevelOverride,
+			h.RemoveLogLevelOverrideRequest,
+			h.RemoveLogLevelOverrideResponse,
+		),
+	)
+}
+
+func initEventRoutes(router chi.Router, h *Controller) {
+	router = router.With(web.RequirePermissions(permissionEvents))
+	router.Method(
+		http.MethodGet,
+		"/events",
+		web.CreateHandler(
+			h.ListEvents,
+			h.ListEventsRequest,
+			h.ListEventsResponse,
+		),
+	)
+	router.Method(
+		http.MethodPost,
+		fmt.Sprintf("/events/{%s}/replay", eventIDParam),
+		web.CreateHandler(
+			h.ReplayEvent,
+			h.ReplayEventRequest,
+			h.ReplayEventResponse,
+		),
+	)
+}
@@ -0,0 +1,139 @@
+package adminctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/events"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	statusParam = "status"
+	limitParam  = "limit"
+
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// --- list events ---
+
+type RequestListEvents struct {
+	status database.OutboxStatus
+	limit  int
+}
+
+func (c *Controller) ListEvents(ctx context.Context, req *RequestListEvents, w http.ResponseWriter) (*apimodel.OutboxEventList, error) {
+	found, err := c.outbox.Events(ctx, req.status, req.limit)
+	if err != nil {
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	result := apimodel.OutboxEventList{
+		Events: make([]apimodel.OutboxEvent, 0, len(found)),
+	}
+	for _, event := range found {
+		result.Events = append(result.Events, mapEvent(ctx, event))
+	}
+	return &result, nil
+}
+
+func (c *Controller) ListEventsRequest(r *http.Request, w http.ResponseWriter) (*RequestListEvents, error) {
+	ctx := r.Context()
+
+	status := database.OutboxStatus(r.URL.Query().Get(statusParam))
+	switch status {
+	case "", database.OutboxPending, database.OutboxDelivered, database.OutboxDead:
+	default:
+		err := common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{statusParam: []string{"must be one of pending, delivered, dead"}})
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	limit := defaultEventLimit
+	if value := r.URL.Query().Get(limitParam); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxEventLimit {
+			err := common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{limitParam: []string{fmt.Sprintf("must be between 1 and %d", maxEventLimit)}})
+			web.SendErrorResponse(ctx, w, err)
+			return nil, err
+		}
+	}
+
+	return &RequestListEvents{
+		status: status,
+		limit:  limit,
+	}, nil
+}
+
+func (c *Controller) ListEventsResponse(ctx context.Context, res *apimodel.OutboxEventList, w http.ResponseWriter) error {
+	return web.EncodeWithStatus(http.StatusOK, res, w)
+}
+
+// --- replay event ---
+
+type RequestReplayEvent struct {
+	id string
+}
+
+func (c *Controller) ReplayEvent(ctx context.Context, req *RequestReplayEvent, w http.ResponseWriter) (*apimodel.OutboxEvent, error) {
+	replayed, err := c.outbox.Replay(ctx, req.id)
+	if errors.Is(err, database.ErrNotFound) {
+		err = common.NewNotFound(ctx, common.EventNotFound, url.Values{"id": []string{"no event with this id"}})
+	} else if errors.Is(err, events.ErrNotDead) {
+		err = common.NewConflict(ctx, common.EventNotDead, url.Values{"id": []string{"only dead events can be replayed"}})
+	}
+	if err != nil {
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	result := mapEvent(ctx, replayed)
+	c.recordAudit(ctx, "event.replay", "events/"+req.id, nil, result)
+
+	return &result, nil
+}
+
+func (c *Controller) ReplayEventRequest(r *http.Request, w http.ResponseWriter) (*RequestReplayEvent, error) {
+	return &RequestReplayEvent{
+		id: chi.URLParam(r, eventIDParam),
+	}, nil
+}
+
+func (c *Controller) ReplayEventResponse(ctx context.Context, res *apimodel.OutboxEvent, w http.ResponseWriter) error {
+	return web.EncodeWithStatus(http.StatusOK, res, w)
+}
+
+// --- helpers ---
+
+func mapEvent(ctx context.Context, event database.OutboxEvent) apimodel.OutboxEvent {
+	result := apimodel.OutboxEvent{
+		Id:          event.ID,
+		EventId:     event.EventID,
+		Type:        event.EventType,
+		Subscriber:  event.Subscriber,
+		Status:      string(event.Status),
+		Attempts:    int32(event.Attempts),
+		LastError:   event.LastError,
+		CreatedAt:   event.CreatedAt,
+		DeliveredAt: event.DeliveredAt,
+	}
+	if event.Status == database.OutboxPending {
+		result.NextAttemptAt = &event.NextAttemptAt
+	}
+	if err := json.Unmarshal([]byte(event.Payload), &result.Payload); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("invalid payload of event %s: %s", event.ID, err.Error())
+	}
+	return result
+}
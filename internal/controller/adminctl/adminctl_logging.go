@@ -0,0 +1,261 @@
+package adminctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Roshick/go-autumn-slog/pkg/level"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	applogging "github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxDurationSeconds limits timed log level changes to one day.
+const maxDurationSeconds = 24 * 60 * 60
+
+type ResponseEmpty struct{}
+
+// --- get settings ---
+
+type RequestGetLogging struct{}
+
+func (c *Controller) GetLogging(ctx context.Context, req *RequestGetLogging, w http.ResponseWriter) (*apimodel.LogLevelSettings, error) {
+	lvl, revertAt := applogging.Level()
+
+	return &apimodel.LogLevelSettings{
+		Level:     level.LevelToString(lvl),
+		RevertAt:  optionalTime(revertAt),
+		Overrides: mapOverrides(applogging.Overrides()),
+	}, nil
+}
+
+func (c *Controller) GetLoggingRequest(r *http.Request, w http.ResponseWriter) (*RequestGetLogging, error) {
+	return &RequestGetLogging{}, nil
+}
+
+func (c *Controller) GetLoggingResponse(ctx context.Context, res *apimodel.LogLevelSettings, w http.ResponseWriter) error {
+	return web.EncodeWithStatus(http.StatusOK, res, w)
+}
+
+// --- change global level ---
+
+type RequestSetLogLevel struct {
+	level    slog.Level
+	duration time.Duration
+}
+
+func (c *Controller) SetLogLevel(ctx context.Context, req *RequestSetLogLevel, w http.ResponseWriter) (*apimodel.LogLevelSettings, error) {
+	oldLevel, oldRevertAt := applogging.Level()
+	revertAt := applogging.SetLevel(req.level, req.duration)
+
+	before := apimodel.LogLevelSettings{
+		Level:    level.LevelToString(oldLevel),
+		RevertAt: optionalTime(oldRevertAt),
+	}
+	after := apimodel.LogLevelSettings{
+		Level:     level.LevelToString(req.level),
+		RevertAt:  optionalTime(revertAt),
+		Overrides: mapOverrides(applogging.Overrides()),
+	}
+	c.recordAudit(ctx, "log.level.change", "logging/level", before, after)
+
+	return &after, nil
+}
+
+func (c *Controller) SetLogLevelRequest(r *http.Request, w http.ResponseWriter) (*RequestSetLogLevel, error) {
+	body := apimodel.LogLevelChange{}
+	if err := parseBody(r, &body); err != nil {
+		web.SendErrorResponse(r.Context(), w, err)
+		return nil, err
+	}
+
+	lvl, err := parseLevel(r.Context(), body.Level)
+	if err != nil {
+		web.SendErrorResponse(r.Context(), w, err)
+		return nil, err
+	}
+	duration, err := parseDuration(r.Context(), body.DurationSeconds)
+	if err != nil {
+		web.SendErrorResponse(r.Context(), w, err)
+		return nil, err
+	}
+
+	return &RequestSetLogLevel{
+		level:    lvl,
+		duration: duration,
+	}, nil
+}
+
+func (c *Controller) SetLogLevelResponse(ctx context.Context, res *apimodel.LogLevelSettings, w http.ResponseWriter) error {
+	return web.EncodeWithStatus(http.StatusOK, res, w)
+}
+
+// --- add override ---
+
+type RequestAddLogLevelOverride struct {
+	override applogging.Override
+}
+
+func (c *Controller) AddLogLevelOverride(ctx context.Context, req *RequestAddLogLevelOverride, w http.ResponseWriter) (*apimodel.LogLevelOverride, error) {
+	override := mapOverride(applogging.AddOverride(req.override))
+	c.recordAudit(ctx, "log.override.add", "logging/overrides/"+override.Id, nil, override)
+
+	return &override, nil
+}
+
+func (c *Controller) AddLogLevelOverrideRequest(r *http.Request, w http.ResponseWriter) (*RequestAddLogLevelOverride, error) {
+	ctx := r.Context()
+
+	body := apimodel.LogLevelOverride{}
+	if err := parseBody(r, &body); err != nil {
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	override, err := parseOverride(ctx, body)
+	if err != nil {
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	return &RequestAddLogLevelOverride{
+		override: override,
+	}, nil
+}
+
+func (c *Controller) AddLogLevelOverrideResponse(ctx context.Context, res *apimodel.LogLevelOverride, w http.ResponseWriter) error {
+	return web.EncodeWithStatus(http.StatusCreated, res, w)
+}
+
+// --- remove override ---
+
+type RequestRemoveLogLevelOverride struct {
+	id string
+}
+
+func (c *Controller) RemoveLogLevelOverride(ctx context.Context, req *RequestRemoveLogLevelOverride, w http.ResponseWriter) (*ResponseEmpty, error) {
+	removed, found := applogging.RemoveOverride(req.id)
+	if !found {
+		err := common.NewNotFound(ctx, common.LogOverrideNotFound, url.Values{"id": []string{"no log level override with this id"}})
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	c.recordAudit(ctx, "log.override.remove", "logging/overrides/"+req.id, mapOverride(removed), nil)
+
+	return &ResponseEmpty{}, nil
+}
+
+func (c *Controller) RemoveLogLevelOverrideRequest(r *http.Request, w http.ResponseWriter) (*RequestRemoveLogLevelOverride, error) {
+	return &RequestRemoveLogLevelOverride{
+		id: chi.URLParam(r, overrideIDParam),
+	}, nil
+}
+
+func (c *Controller) RemoveLogLevelOverrideResponse(ctx context.Context, res *ResponseEmpty, w http.ResponseWriter) error {
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// --- helpers ---
+
+// recordAudit records a change. The change has been made, so failing to record it is only logged.
+func (c *Controller) recordAudit(ctx context.Context, action string, resource string, before any, after any) {
+	if err := c.auditor.Record(ctx, action, resource, before, after); err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to record audit event %s for %s: %s", action, resource, err.Error())
+	}
+}
+
+func parseBody(r *http.Request, dto any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dto); err != nil {
+		return common.NewBadRequest(r.Context(), common.RequestParseFailed, url.Values{"request": []string{"request body invalid"}})
+	}
+	return nil
+}
+
+func parseLevel(ctx context.Context, value string) (slog.Level, error) {
+	lvl, err := level.ParseLogLevel(value)
+	if err != nil {
+		return lvl, common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{"level": []string{"must be one of TRACE, DEBUG, INFO, WARN, ERROR, FATAL, PANIC, SILENT"}})
+	}
+	return lvl, nil
+}
+
+func parseDuration(ctx context.Context, seconds int64) (time.Duration, error) {
+	if seconds < 0 || seconds > maxDurationSeconds {
+		return 0, common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{"duration_seconds": []string{fmt.Sprintf("must be between 0 and %d", maxDurationSeconds)}})
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func parseOverride(ctx context.Context, dto apimodel.LogLevelOverride) (applogging.Override, error) {
+	result := applogging.Override{
+		Subject:   dto.Subject,
+		Route:     dto.Route,
+		RequestID: dto.RequestId,
+	}
+
+	if dto.Id != "" || dto.ExpiresAt != nil {
+		return result, common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{"request": []string{"id and expires_at are assigned by the service"}})
+	}
+	if dto.Subject == "" && dto.Route == "" && dto.RequestId == "" {
+		return result, common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{"request": []string{"at least one of subject, route, request_id is required"}})
+	}
+	var err error
+	if dto.Route != "" {
+		result.MatchesRoute, err = middleware.NewLogLevelRouteMatcher(dto.Route)
+		if err != nil {
+			return result, common.NewBadRequest(ctx, common.RequestParseFailed, url.Values{"route": []string{"must be a method or * followed by a route pattern, such as 'GET /api/rest/v1/example'"}})
+		}
+	}
+
+	result.Level, err = parseLevel(ctx, dto.Level)
+	if err != nil {
+		return result, err
+	}
+	duration, err := parseDuration(ctx, dto.DurationSeconds)
+	if err != nil {
+		return result, err
+	}
+	if duration > 0 {
+		result.ExpiresAt = timestamp.Now().Add(duration)
+	}
+	return result, nil
+}
+
+func mapOverrides(overrides []applogging.Override) []apimodel.LogLevelOverride {
+	result := make([]apimodel.LogLevelOverride, 0, len(overrides))
+	for _, override := range overrides {
+		result = append(result, mapOverride(override))
+	}
+	return result
+}
+
+func mapOverride(override applogging.Override) apimodel.LogLevelOverride {
+	return apimodel.LogLevelOverride{
+		Id:        override.ID,
+		Subject:   override.Subject,
+		Route:     override.Route,
+		RequestId: override.RequestID,
+		Level:     level.LevelToString(override.Level),
+		ExpiresAt: optionalTime(override.ExpiresAt),
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
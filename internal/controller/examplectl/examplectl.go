@@ -3,6 +3,7 @@ package examplectl
 import (
 	"fmt"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
 	"github.com/eurofurence/reg-backend-template-test/internal/service/example"
 	"github.com/go-chi/chi/v5"
 	"net/http"
@@ -10,13 +11,18 @@ import (
 
 const categoryParam = "category"
 
+// permissionWrite allows changing example values. Grant it to roles in the ROLES configuration.
+const permissionWrite = "example.write"
+
 type Controller struct {
-	svc example.Example
+	svc     example.Example
+	auditor audit.Auditor
 }
 
-func InitRoutes(router chi.Router, svc example.Example) {
+func InitRoutes(router chi.Router, svc example.Example, auditor audit.Auditor) {
 	h := &Controller{
-		svc: svc,
+		svc:     svc,
+		auditor: auditor,
 	}
 
 	router.Route("/api/rest/v1/example", func(sr chi.Router) {
@@ -38,7 +44,7 @@ func initGetRoutes(router chi.Router, h *Controller) {
 }
 
 func initPostRoutes(router chi.Router, h *Controller) {
-	router.Method(
+	router.With(web.RequirePermissions(permissionWrite)).Method(
 		http.MethodPost,
 		fmt.Sprintf("/{%s}", categoryParam),
 		web.CreateHandler(
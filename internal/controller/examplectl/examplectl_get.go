@@ -6,6 +6,7 @@ import (
 	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/service/example"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -14,11 +15,12 @@ import (
 const minValueParam = "min_value"
 
 type RequestGetExample struct {
+	category string
 	minValue int64
 }
 
 func (c *Controller) GetExample(ctx context.Context, req *RequestGetExample, w http.ResponseWriter) (*apimodel.Example, error) {
-	val, err := c.svc.ObtainNextValue(ctx, req.minValue)
+	val, err := c.svc.ObtainNextValue(ctx, req.category, req.minValue)
 	if err != nil {
 		web.SendErrorResponse(ctx, w, err)
 		return nil, err
@@ -30,6 +32,11 @@ func (c *Controller) GetExample(ctx context.Context, req *RequestGetExample, w h
 }
 
 func (c *Controller) GetExampleRequest(r *http.Request, w http.ResponseWriter) (*RequestGetExample, error) {
+	category := r.URL.Query().Get(categoryParam)
+	if category == "" {
+		category = example.DefaultCategory
+	}
+
 	minValue, err := parseIntQueryParam(r, minValueParam)
 	if err != nil {
 		web.SendErrorResponse(r.Context(), w, err)
@@ -37,6 +44,7 @@ func (c *Controller) GetExampleRequest(r *http.Request, w http.ResponseWriter) (
 	}
 
 	return &RequestGetExample{
+		category: category,
 		minValue: minValue,
 	}, nil
 }
@@ -3,9 +3,11 @@ package examplectl
 import (
 	"context"
 	"encoding/json"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 	"net/url"
@@ -19,7 +21,21 @@ type RequestSetExample struct {
 type ResponseEmpty struct{}
 
 func (c *Controller) SetExample(ctx context.Context, req *RequestSetExample, w http.ResponseWriter) (*ResponseEmpty, error) {
-	return nil, nil
+	if err := c.svc.ProvideStartValue(ctx, req.category, req.body.Value); err != nil {
+		c.recordAudit(ctx, audit.OutcomeFailure, req)
+		web.SendErrorResponse(ctx, w, err)
+		return nil, err
+	}
+
+	c.recordAudit(ctx, audit.OutcomeSuccess, req)
+	return &ResponseEmpty{}, nil
+}
+
+// recordAudit records an attempt to set a value. Failing to record it is only logged.
+func (c *Controller) recordAudit(ctx context.Context, outcome audit.Outcome, req *RequestSetExample) {
+	if err := c.auditor.RecordOutcome(ctx, outcome, "example.set", "example/"+req.category, nil, req.body); err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to record audit event: %s", err.Error())
+	}
 }
 
 func (c *Controller) SetExampleRequest(r *http.Request, w http.ResponseWriter) (*RequestSetExample, error) {
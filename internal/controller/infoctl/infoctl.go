@@ -2,18 +2,26 @@ package infoctl
 
 import (
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 )
 
-type Controller struct{}
+type Controller struct {
+	db database.Repository
+}
 
-func InitRoutes(router chi.Router) {
-	ctl := &Controller{}
+func InitRoutes(router chi.Router, db database.Repository) {
+	ctl := &Controller{
+		db: db,
+	}
 
 	router.Route("/", func(sr chi.Router) {
 		initGetRoutes(sr, ctl)
 	})
+	router.Route("/api/rest/v1/info", func(sr chi.Router) {
+		initInfoRoutes(sr, ctl)
+	})
 }
 
 func initGetRoutes(router chi.Router, c *Controller) {
@@ -27,3 +35,15 @@ func initGetRoutes(router chi.Router, c *Controller) {
 		),
 	)
 }
+
+func initInfoRoutes(router chi.Router, c *Controller) {
+	router.Method(
+		http.MethodGet,
+		"/roles",
+		web.CreateHandler(
+			c.EffectiveRoles,
+			c.EffectiveRolesRequest,
+			c.EffectiveRolesResponse,
+		),
+	)
+}
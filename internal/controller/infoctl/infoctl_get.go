@@ -2,15 +2,32 @@ package infoctl
 
 import (
 	"context"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
 	"net/http"
+	"time"
 )
 
+const (
+	healthOK                  = "OK"
+	healthDatabaseUnavailable = "DATABASE_UNAVAILABLE"
+)
+
+// healthPingTimeout keeps the health check responsive if the database hangs.
+const healthPingTimeout = 2 * time.Second
+
 type HealthRequest struct{}
 
 func (c *Controller) Health(ctx context.Context, req *HealthRequest, w http.ResponseWriter) (*apimodel.Health, error) {
-	return &apimodel.Health{Status: "OK"}, nil
+	pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+
+	if err := c.db.Ping(pingCtx); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("health check failed to reach database: %s", err.Error())
+		return &apimodel.Health{Status: healthDatabaseUnavailable}, nil
+	}
+	return &apimodel.Health{Status: healthOK}, nil
 }
 
 func (c *Controller) HealthRequest(r *http.Request, w http.ResponseWriter) (*HealthRequest, error) {
@@ -18,5 +35,8 @@ func (c *Controller) HealthRequest(r *http.Request, w http.ResponseWriter) (*Hea
 }
 
 func (c *Controller) HealthResponse(ctx context.Context, res *apimodel.Health, w http.ResponseWriter) error {
+	if res.Status != healthOK {
+		return web.EncodeWithStatus(http.StatusServiceUnavailable, res, w)
+	}
 	return web.EncodeWithStatus(http.StatusOK, res, w)
 }
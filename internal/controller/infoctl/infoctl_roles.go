@@ -0,0 +1,27 @@
+package infoctl
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"net/http"
+)
+
+type EffectiveRolesRequest struct{}
+
+func (c *Controller) EffectiveRoles(ctx context.Context, req *EffectiveRolesRequest, w http.ResponseWriter) (*apimodel.EffectiveRoles, error) {
+	return &apimodel.EffectiveRoles{
+		Subject:     common.GetSubject(ctx),
+		Roles:       common.GetRoles(ctx),
+		Permissions: common.GetPermissions(ctx),
+	}, nil
+}
+
+func (c *Controller) EffectiveRolesRequest(r *http.Request, w http.ResponseWriter) (*EffectiveRolesRequest, error) {
+	return &EffectiveRolesRequest{}, nil
+}
+
+func (c *Controller) EffectiveRolesResponse(ctx context.Context, res *apimodel.EffectiveRoles, w http.ResponseWriter) error {
+	return web.EncodeWithStatus(http.StatusOK, res, w)
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"os"
+	"time"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+	OutcomeDenied  Outcome = "denied"
+)
+
+// Event records who did what, and how it went.
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Resource  string    `json:"resource"`
+	Outcome   Outcome   `json:"outcome"`
+
+	// Subject is the effective subject, the impersonated user while impersonating.
+	Subject      string `json:"subject,omitempty"`
+	Impersonator string `json:"impersonator,omitempty"`
+	APIKeyName   string `json:"api_key_name,omitempty"`
+	RequestID    string `json:"request_id,omitempty"`
+
+	// Before and After are the state of the resource before and after the change. Either may be nil.
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// Auditor records changes along with the caller from the context.
+type Auditor interface {
+	// Record records a change that was made successfully.
+	Record(ctx context.Context, action string, resource string, before any, after any) error
+
+	// RecordOutcome records an attempted change, which may have failed or been denied.
+	RecordOutcome(ctx context.Context, outcome Outcome, action string, resource string, before any, after any) error
+
+	// Close closes the sink. Nothing may be recorded afterwards.
+	Close() error
+}
+
+// Sink stores audit events.
+type Sink interface {
+	Write(ctx context.Context, event Event) error
+
+	// Close releases the resources of the sink, such as open files.
+	Close() error
+}
+
+type auditor struct {
+	sink Sink
+}
+
+// New creates an Auditor that writes to sink.
+func New(sink Sink) Auditor {
+	return &auditor{
+		sink: sink,
+	}
+}
+
+func (a *auditor) Record(ctx context.Context, action string, resource string, before any, after any) error {
+	return a.RecordOutcome(ctx, OutcomeSuccess, action, resource, before, after)
+}
+
+func (a *auditor) RecordOutcome(ctx context.Context, outcome Outcome, action string, resource string, before any, after any) error {
+	// masked here, so no sink can leak them
+	before = logging.Redact("audit.before", before)
+	after = logging.Redact("audit.after", after)
+
+	event := Event{
+		Timestamp:    timestamp.Now(),
+		Action:       action,
+		Resource:     resource,
+		Outcome:      outcome,
+		Subject:      common.GetSubject(ctx),
+		Impersonator: common.GetImpersonatorSubject(ctx),
+		APIKeyName:   common.GetAPIKeyName(ctx),
+		Before:       before,
+		After:        after,
+	}
+	// not common.GetRequestID, which falls back to a placeholder
+	if requestID, ok := ctx.Value(common.CtxKeyRequestID{}).(string); ok {
+		event.RequestID = requestID
+	}
+	return a.sink.Write(ctx, event)
+}
+
+func (a *auditor) Close() error {
+	return a.sink.Close()
+}
+
+const (
+	SinkNone = "none"
+	SinkLog  = "log"
+	SinkFile = "file"
+)
+
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+)
+
+type Options struct {
+	Sink     string
+	FilePath string
+
+	// LogOutput is the stream the log sink writes to, one of the Output... constants. Defaults to stderr.
+	LogOutput string
+}
+
+// NewSink creates the Sink configured in options.
+func NewSink(options Options) (Sink, error) {
+	switch options.Sink {
+	case SinkNone:
+		return &noopSink{}, nil
+	case SinkLog, "":
+		switch options.LogOutput {
+		case OutputStderr, "":
+			return NewLogSink(os.Stderr), nil
+		case OutputStdout:
+			return NewLogSink(os.Stdout), nil
+		default:
+			return nil, fmt.Errorf("invalid audit log output %s, must be one of %s (default if blank), %s", options.LogOutput, OutputStderr, OutputStdout)
+		}
+	case SinkFile:
+		return NewFileSink(options.FilePath)
+	default:
+		return nil, fmt.Errorf("invalid audit sink %s, must be one of %s (default if blank), %s, %s", options.Sink, SinkLog, SinkFile, SinkNone)
+	}
+}
+
+type noopSink struct{}
+
+func (s *noopSink) Write(_ context.Context, _ Event) error {
+	return nil
+}
+
+func (s *noopSink) Close() error {
+	return nil
+}
+
+const (
+	ConfAuditSink      = "AUDIT_SINK"
+	ConfAuditFile      = "AUDIT_FILE"
+	ConfAuditLogOutput = "AUDIT_LOG_OUTPUT"
+)
+
+func ConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfAuditSink,
+			Default:     SinkLog,
+			Description: "where to record audit events. One of log (default, JSON lines on the stream given by " + ConfAuditLogOutput + "), file, none.",
+			Validate:    auconfigenv.ObtainPatternValidator("^(|log|file|none)$"),
+		}, {
+			Key:         ConfAuditFile,
+			Default:     "audit.jsonl",
+			Description: "file to append audit events to, one JSON object per line, if " + ConfAuditSink + " is file.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfAuditLogOutput,
+			Default:     "",
+			Description: "stream the log sink writes audit events to, stdout or stderr. If empty, the one the application logs do not use, so audit events form a separate stream: stderr for the json " + logging.ConfLogStyle + " (application logs on stdout), stdout for the plain one (application logs on stderr).",
+			Validate:    auconfigenv.ObtainPatternValidator("^(|stdout|stderr)$"),
+		},
+	}
+}
+
+func OptionsFromConfig() Options {
+	logOutput := auconfigenv.Get(ConfAuditLogOutput)
+	if logOutput == "" {
+		logOutput = OutputStderr
+		if auconfigenv.Get(logging.ConfLogStyle) == logging.LogStylePlain {
+			logOutput = OutputStdout
+		}
+	}
+	return Options{
+		Sink:      auconfigenv.Get(ConfAuditSink),
+		FilePath:  auconfigenv.Get(ConfAuditFile),
+		LogOutput: logOutput,
+	}
+}
@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tstNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func tstImpersonatingContext() context.Context {
+	ctx := context.WithValue(context.Background(), common.CtxKeyRequestID{}, "a8b7c6d5")
+	ctx = context.WithValue(ctx, common.CtxKeyClaims{}, &common.AllClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "202"}})
+	return context.WithValue(ctx, common.CtxKeyImpersonator{}, &common.AllClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "101"}})
+}
+
+func TestRecord(t *testing.T) {
+	timestamp.SetFakeNow(tstNow)
+	sink := NewMemorySink()
+	cut := New(sink)
+
+	require.NoError(t, cut.Record(tstImpersonatingContext(), "example.set", "example/cat", 1, 2))
+	apiKeyCtx := context.WithValue(context.Background(), common.CtxKeyAPIKey{}, "backend")
+	require.NoError(t, cut.RecordOutcome(apiKeyCtx, OutcomeDenied, "example.set", "example/dog", nil, nil))
+
+	require.Equal(t, []Event{
+		{
+			Timestamp:    tstNow,
+			Action:       "example.set",
+			Resource:     "example/cat",
+			Outcome:      OutcomeSuccess,
+			Subject:      "202",
+			Impersonator: "101",
+			RequestID:    "a8b7c6d5",
+			Before:       1,
+			After:        2,
+		},
+		{
+			Timestamp:  tstNow,
+			Action:     "example.set",
+			Resource:   "example/dog",
+			Outcome:    OutcomeDenied,
+			APIKeyName: "backend",
+		},
+	}, sink.Events())
+
+	sink.Reset()
+	require.Empty(t, sink.Events())
+}
+
+func TestRecord_Redacted(t *testing.T) {
+	logging.SetRedactionRules(logging.DefaultRedactionRules())
+	sink := NewMemorySink()
+	cut := New(sink)
+
+	require.NoError(t, cut.Record(context.Background(), "user.update", "users/202", "mail to old@example.com", map[string]string{"nickname": "Squirrel"}))
+
+	events := sink.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, "mail to [REDACTED]", events[0].Before)
+	require.Equal(t, map[string]string{"nickname": "Squirrel"}, events[0].After, "must keep values with nothing to mask")
+}
+
+func TestFileSink(t *testing.T) {
+	timestamp.SetFakeNow(tstNow)
+	logging.SetRedactionRules(logging.DefaultRedactionRules())
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewSink(Options{Sink: SinkFile, FilePath: path})
+	require.NoError(t, err)
+	cut := New(sink)
+
+	require.NoError(t, cut.Record(tstImpersonatingContext(), "example.set", "example/cat", nil, map[string]int{"value": 42}))
+	require.NoError(t, cut.RecordOutcome(context.Background(), OutcomeFailure, "example.set", "example/dog", nil, nil))
+	require.NoError(t, cut.Record(context.Background(), "user.update", "users/202", map[string]string{"email": "old@example.com"}, map[string]string{"nickname": "Squirrel"}))
+	require.NoError(t, cut.Close())
+	require.Error(t, cut.Record(context.Background(), "example.set", "example/closed", nil, nil), "must not write after closing")
+
+	// reopening must append
+	sink, err = NewFileSink(path)
+	require.NoError(t, err)
+	cut = New(sink)
+	require.NoError(t, cut.Record(context.Background(), "example.set", "example/mouse", nil, nil))
+	require.NoError(t, cut.Close())
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, `{"timestamp":"2024-01-02T03:04:05Z","action":"example.set","resource":"example/cat","outcome":"success","subject":"202","impersonator":"101","request_id":"a8b7c6d5","after":{"value":42}}`, lines[0])
+	require.Equal(t, `{"timestamp":"2024-01-02T03:04:05Z","action":"example.set","resource":"example/dog","outcome":"failure"}`, lines[1])
+	require.Equal(t, `{"timestamp":"2024-01-02T03:04:05Z","action":"user.update","resource":"users/202","outcome":"success","before":{"email":"[REDACTED]"},"after":{"nickname":"Squirrel"}}`, lines[2], "must mask sensitive values like the log sink")
+	require.Contains(t, lines[3], `"resource":"example/mouse"`)
+}
+
+func TestLogSink(t *testing.T) {
+	timestamp.SetFakeNow(tstNow)
+	logging.SetRedactionRules(logging.DefaultRedactionRules())
+	output := &bytes.Buffer{}
+	cut := New(NewLogSink(output))
+
+	require.NoError(t, cut.Record(tstImpersonatingContext(), "user.update", "users/202", map[string]string{"email": "old@example.com"}, map[string]string{"nickname": "Squirrel"}))
+
+	actual := map[string]any{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &actual))
+	require.Equal(t, map[string]any{
+		"time":              "2024-01-02T03:04:05Z",
+		"level":             "INFO",
+		"msg":               "audit user.update users/202: success",
+		"event.kind":        "audit",
+		"event.action":      "user.update",
+		"event.outcome":     "success",
+		"audit.resource":    "users/202",
+		"user.id":           "101",
+		"user.effective.id": "202",
+		"http.request.id":   "a8b7c6d5",
+		"audit.before":      map[string]any{"email": "[REDACTED]"},
+		"audit.after":       map[string]any{"nickname": "Squirrel"},
+	}, actual)
+}
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink(Options{})
+	require.NoError(t, err)
+	require.IsType(t, &logSink{}, sink)
+
+	sink, err = NewSink(Options{Sink: SinkNone})
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(context.Background(), Event{}))
+
+	sink, err = NewSink(Options{Sink: SinkLog, LogOutput: OutputStdout})
+	require.NoError(t, err)
+	require.IsType(t, &logSink{}, sink)
+
+	_, err = NewSink(Options{Sink: SinkLog, LogOutput: "stdin"})
+	require.EqualError(t, err, "invalid audit log output stdin, must be one of stderr (default if blank), stdout")
+
+	_, err = NewSink(Options{Sink: "syslog"})
+	require.EqualError(t, err, "invalid audit sink syslog, must be one of log (default if blank), file, none")
+
+	_, err = NewSink(Options{Sink: SinkFile, FilePath: filepath.Join(t.TempDir(), "missing", "audit.jsonl")})
+	require.ErrorContains(t, err, "failed to open audit file")
+}
+
+func TestOptionsFromConfig_LogOutput(t *testing.T) {
+	_ = auconfigenv.Setup(nil, nil)
+
+	testcases := []struct {
+		name      string
+		logStyle  string
+		logOutput string
+		expected  string
+	}{
+		{name: "json_style", logStyle: logging.LogStyleJSON, expected: OutputStderr},
+		{name: "default_style", expected: OutputStderr},
+		{name: "plain_style", logStyle: logging.LogStylePlain, expected: OutputStdout},
+		{name: "configured", logStyle: logging.LogStylePlain, logOutput: OutputStderr, expected: OutputStderr},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			auconfigenv.Set(logging.ConfLogStyle, tc.logStyle)
+			auconfigenv.Set(ConfAuditLogOutput, tc.logOutput)
+			require.Equal(t, tc.expected, OptionsFromConfig().LogOutput)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+type logSink struct {
+	handler slog.Handler
+}
+
+// NewLogSink creates a Sink that writes each event as a line of JSON to w, which should not also receive the
+// application logs. Audit events are never dropped because of the log level.
+//
+// Sensitive values in the message are masked the same way as in the application logs.
+func NewLogSink(w io.Writer) Sink {
+	return &logSink{
+		handler: logging.NewRedactingHandler(slog.NewJSONHandler(w, nil)),
+	}
+}
+
+func (s *logSink) Write(ctx context.Context, event Event) error {
+	record := slog.NewRecord(event.Timestamp, slog.LevelInfo, fmt.Sprintf("audit %s %s: %s", event.Action, event.Resource, event.Outcome), 0)
+	record.AddAttrs(
+		slog.String("event.kind", "audit"),
+		slog.String("event.action", event.Action),
+		slog.String("event.outcome", string(event.Outcome)),
+		slog.String("audit.resource", event.Resource),
+	)
+	// same fields as the request logger
+	if event.Impersonator != "" {
+		record.AddAttrs(slog.String("user.id", event.Impersonator), slog.String("user.effective.id", event.Subject))
+	} else if event.Subject != "" {
+		record.AddAttrs(slog.String("user.id", event.Subject))
+	}
+	if event.APIKeyName != "" {
+		record.AddAttrs(slog.String("auth.api_key.name", event.APIKeyName))
+	}
+	if event.RequestID != "" {
+		record.AddAttrs(slog.String("http.request.id", event.RequestID))
+	}
+	if event.Before != nil {
+		record.AddAttrs(slog.Any("audit.before", event.Before))
+	}
+	if event.After != nil {
+		record.AddAttrs(slog.Any("audit.after", event.After))
+	}
+	return s.handler.Handle(ctx, record)
+}
+
+// Close does nothing, the stream belongs to the caller.
+func (s *logSink) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// NewFileSink creates a Sink that appends each event to a file as a line of JSON. The file is never truncated.
+func NewFileSink(path string) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file %s: %w", path, err)
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Write(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+// MemorySink keeps events in memory. Intended for tests.
+type MemorySink struct {
+	events []Event
+	mutex  sync.Mutex
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Write(_ context.Context, event Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// Events returns the events written so far, oldest first.
+func (s *MemorySink) Events() []Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Clone(s.events)
+}
+
+func (s *MemorySink) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = nil
+}
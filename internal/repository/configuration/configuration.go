@@ -6,8 +6,13 @@ import (
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/server"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/errorreport"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/events"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/vault"
 )
 
@@ -28,8 +33,17 @@ func ConfigItems() []auconfigapi.ConfigItem {
 	return join(
 		logging.ConfigItems(),
 		server.ConfigItems(),
+		middleware.RequestIDConfigItems(),
 		middleware.CorsConfigItems(),
 		middleware.SecurityConfigItems(),
+		middleware.RateLimitConfigItems(),
+		middleware.ConcurrencyConfigItems(),
+		middleware.AuditConfigItems(),
+		errorreport.ConfigItems(),
+		audit.ConfigItems(),
+		database.ConfigItems(),
+		events.ConfigItems(),
+		tracing.ConfigItems(),
 		vault.ConfigItems(),
 		idp.ConfigItems(),
 		// add new config item providers here
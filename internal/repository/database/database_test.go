@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tstSQLiteOptions(t *testing.T) Options {
+	return Options{
+		Type:                  TypeSQLite,
+		DSN:                   "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)",
+		MaxOpenConnections:    4,
+		MaxIdleConnections:    2,
+		ConnectionMaxLifetime: time.Minute,
+	}
+}
+
+func tstOpen(t *testing.T, options Options) Repository {
+	cut, err := New(options)
+	require.NoError(t, err)
+	require.NoError(t, cut.Open(context.Background()))
+	t.Cleanup(func() {
+		require.NoError(t, cut.Close())
+	})
+	require.NoError(t, cut.Migrate(context.Background()))
+	return cut
+}
+
+func TestExampleValues(t *testing.T) {
+	for name, options := range map[string]Options{
+		"inmemory": {Type: TypeInMemory},
+		"sqlite":   tstSQLiteOptions(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cut := tstOpen(t, options)
+
+			require.NoError(t, cut.Ping(ctx))
+
+			_, err := cut.GetExampleValue(ctx, "cat")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, cut.SetExampleValue(ctx, "cat", 42))
+			require.NoError(t, cut.SetExampleValue(ctx, "dog", 7))
+			require.NoError(t, cut.SetExampleValue(ctx, "cat", 43))
+
+			value, err := cut.GetExampleValue(ctx, "cat")
+			require.NoError(t, err)
+			require.Equal(t, int64(43), value)
+
+			value, err = cut.GetExampleValue(ctx, "dog")
+			require.NoError(t, err)
+			require.Equal(t, int64(7), value)
+		})
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	for name, options := range map[string]Options{
+		"inmemory": {Type: TypeInMemory},
+		"sqlite":   tstSQLiteOptions(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cut := tstOpen(t, options)
+			require.NoError(t, cut.SetExampleValue(ctx, "cat", 1))
+
+			failure := errors.New("failure")
+			err := cut.RunInTransaction(ctx, func(ctx context.Context) error {
+				require.NoError(t, cut.SetExampleValue(ctx, "cat", 2))
+				// joins the outer transaction
+				return cut.RunInTransaction(ctx, func(ctx context.Context) error {
+					require.NoError(t, cut.SetExampleValue(ctx, "dog", 2))
+					return failure
+				})
+			})
+			require.ErrorIs(t, err, failure)
+
+			value, err := cut.GetExampleValue(ctx, "cat")
+			require.NoError(t, err)
+			require.Equal(t, int64(1), value, "rolled back value")
+			_, err = cut.GetExampleValue(ctx, "dog")
+			require.ErrorIs(t, err, ErrNotFound, "rolled back value")
+
+			require.NoError(t, cut.RunInTransaction(ctx, func(ctx context.Context) error {
+				return cut.SetExampleValue(ctx, "cat", 3)
+			}))
+			value, err = cut.GetExampleValue(ctx, "cat")
+			require.NoError(t, err)
+			require.Equal(t, int64(3), value, "committed value")
+		})
+	}
+}
+
+func TestTransactionsSerialized(t *testing.T) {
+	for name, options := range map[string]Options{
+		"inmemory": {Type: TypeInMemory},
+		"sqlite":   tstSQLiteOptions(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cut := tstOpen(t, options)
+			require.NoError(t, cut.SetExampleValue(ctx, "cat", 0))
+
+			const increments = 20
+			var wg sync.WaitGroup
+			errs := make(chan error, increments)
+			for i := 0; i < increments; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- cut.RunInTransaction(ctx, func(ctx context.Context) error {
+						value, err := cut.GetExampleValue(ctx, "cat")
+						if err != nil {
+							return err
+						}
+						return cut.SetExampleValue(ctx, "cat", value+1)
+					})
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			value, err := cut.GetExampleValue(ctx, "cat")
+			require.NoError(t, err)
+			require.Equal(t, int64(increments), value, "no increment may be lost")
+		})
+	}
+}
+
+func TestPrepareSQLiteDSN(t *testing.T) {
+	require.Equal(t, "test.db?_txlock=immediate&_pragma=busy_timeout(5000)", prepareSQLiteDSN("test.db"))
+	require.Equal(t, "file:test.db?mode=rwc&_pragma=busy_timeout(100)&_txlock=immediate", prepareSQLiteDSN("file:test.db?mode=rwc&_pragma=busy_timeout(100)"))
+	require.Equal(t, "file:test.db?_txlock=exclusive&_pragma=busy_timeout(100)", prepareSQLiteDSN("file:test.db?_txlock=exclusive&_pragma=busy_timeout(100)"))
+}
+
+func TestSQLitePersists(t *testing.T) {
+	ctx := context.Background()
+	options := tstSQLiteOptions(t)
+
+	first := tstOpen(t, options)
+	require.NoError(t, first.SetExampleValue(ctx, "cat", 42))
+	require.NoError(t, first.Close())
+
+	// migrations already applied must be skipped
+	second := tstOpen(t, options)
+	value, err := second.GetExampleValue(ctx, "cat")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), value)
+}
+
+func TestSQLiteMigrationsRecorded(t *testing.T) {
+	cut := tstOpen(t, tstSQLiteOptions(t)).(*sqlRepository)
+
+	expected, err := loadMigrations(sqliteDialect.name)
+	require.NoError(t, err)
+	require.NotEmpty(t, expected)
+
+	applied, err := cut.appliedMigrations(context.Background())
+	require.NoError(t, err)
+	require.Len(t, applied, len(expected))
+	for _, m := range expected {
+		require.True(t, applied[m.version], "migration %s not recorded", m.name)
+	}
+}
+
+func TestSQLitePingAfterClose(t *testing.T) {
+	cut := tstOpen(t, tstSQLiteOptions(t))
+	require.NoError(t, cut.Close())
+
+	require.Error(t, cut.Ping(context.Background()))
+}
+
+func TestNewInvalidType(t *testing.T) {
+	_, err := New(Options{Type: "oracle"})
+	require.EqualError(t, err, "invalid database type oracle, must be one of inmemory (default if blank), sqlite")
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+type inMemoryData struct {
+	exampleValues map[string]int64
+	outboxEvents  map[string]OutboxEvent
+}
+
+func (d inMemoryData) clone() inMemoryData {
+	return inMemoryData{
+		exampleValues: maps.Clone(d.exampleValues),
+		outboxEvents:  maps.Clone(d.outboxEvents),
+	}
+}
+
+type inMemoryRepository struct {
+	data  inMemoryData
+	mutex sync.RWMutex
+
+	// txMutex serializes transactions.
+	txMutex sync.Mutex
+}
+
+// NewInMemory creates a Repository that keeps all data in memory. Intended for tests and local development.
+func NewInMemory() Repository {
+	return &inMemoryRepository{
+		data: inMemoryData{
+			exampleValues: make(map[string]int64),
+			outboxEvents:  make(map[string]OutboxEvent),
+		},
+	}
+}
+
+type ctxKeyInMemoryTx struct{}
+
+func (r *inMemoryRepository) Open(_ context.Context) error {
+	return nil
+}
+
+func (r *inMemoryRepository) Close() error {
+	return nil
+}
+
+func (r *inMemoryRepository) Migrate(_ context.Context) error {
+	return nil
+}
+
+func (r *inMemoryRepository) Ping(_ context.Context) error {
+	return nil
+}
+
+// RunInTransaction rolls back by restoring a copy of the data taken when the transaction started,
+// so writes made outside of transactions while it runs are lost on rollback.
+func (r *inMemoryRepository) RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	if ctx.Value(ctxKeyInMemoryTx{}) == r {
+		return f(ctx)
+	}
+
+	r.txMutex.Lock()
+	defer r.txMutex.Unlock()
+
+	r.mutex.RLock()
+	snapshot := r.data.clone()
+	r.mutex.RUnlock()
+
+	if err := f(context.WithValue(ctx, ctxKeyInMemoryTx{}, r)); err != nil {
+		r.mutex.Lock()
+		r.data = snapshot
+		r.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (r *inMemoryRepository) GetExampleValue(_ context.Context, category string) (int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	value, ok := r.data.exampleValues[category]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return value, nil
+}
+
+func (r *inMemoryRepository) SetExampleValue(_ context.Context, category string, value int64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.data.exampleValues[category] = value
+	return nil
+}
+
+func (r *inMemoryRepository) AddOutboxEvent(_ context.Context, event OutboxEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.data.outboxEvents[event.ID] = event
+	return nil
+}
+
+func (r *inMemoryRepository) GetOutboxEvent(_ context.Context, id string) (OutboxEvent, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	event, ok := r.data.outboxEvents[id]
+	if !ok {
+		return OutboxEvent{}, ErrNotFound
+	}
+	return event, nil
+}
+
+func (r *inMemoryRepository) FindOutboxEvents(_ context.Context, status OutboxStatus, limit int) ([]OutboxEvent, error) {
+	result := r.filterOutboxEvents(func(event OutboxEvent) bool {
+		return status == "" || event.Status == status
+	})
+	slices.SortFunc(result, func(a, b OutboxEvent) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return result[:min(limit, len(result))], nil
+}
+
+func (r *inMemoryRepository) DueOutboxEvents(_ context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	result := r.filterOutboxEvents(func(event OutboxEvent) bool {
+		return event.Status == OutboxPending && !event.NextAttemptAt.After(now)
+	})
+	slices.SortFunc(result, func(a, b OutboxEvent) int {
+		return cmp.Or(a.NextAttemptAt.Compare(b.NextAttemptAt), cmp.Compare(a.ID, b.ID))
+	})
+	return result[:min(limit, len(result))], nil
+}
+
+func (r *inMemoryRepository) filterOutboxEvents(keep func(event OutboxEvent) bool) []OutboxEvent {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make([]OutboxEvent, 0)
+	for _, event := range r.data.outboxEvents {
+		if keep(event) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+func (r *inMemoryRepository) UpdateOutboxEvent(_ context.Context, event OutboxEvent) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.data.outboxEvents[event.ID]; !ok {
+		return ErrNotFound
+	}
+	r.data.outboxEvents[event.ID] = event
+	return nil
+}
+
+func (r *inMemoryRepository) CountOutboxEvents(_ context.Context) (map[OutboxStatus]int64, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make(map[OutboxStatus]int64)
+	for _, event := range r.data.outboxEvents {
+		result[event.Status]++
+	}
+	return result, nil
+}
+
+func (r *inMemoryRepository) DeleteDeliveredOutboxEvents(_ context.Context, before time.Time) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var count int64
+	for id, event := range r.data.outboxEvents {
+		if event.Status == OutboxDelivered && event.DeliveredAt != nil && event.DeliveredAt.Before(before) {
+			delete(r.data.outboxEvents, id)
+			count++
+		}
+	}
+	return count, nil
+}
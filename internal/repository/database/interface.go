@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Repository stores the data of this service.
+type Repository interface {
+	// Open connects to the database. Call Migrate afterwards, before using the repository.
+	Open(ctx context.Context) error
+	Close() error
+
+	// Migrate brings the schema up to date by applying all migrations not applied yet.
+	Migrate(ctx context.Context) error
+
+	// Ping checks the database can be reached. It is used by the health check.
+	Ping(ctx context.Context) error
+
+	// RunInTransaction calls f in a transaction, which is committed if f returns nil, and rolled back otherwise.
+	//
+	// Pass the context given to f to all repository calls that should be part of the transaction. Transactions
+	// are serialized, so a value read in a transaction cannot be changed by other transactions before it commits.
+	// Calling RunInTransaction with a context that is already in a transaction joins that transaction.
+	RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error
+
+	// GetExampleValue returns ErrNotFound if no value has been stored for the category yet.
+	GetExampleValue(ctx context.Context, category string) (int64, error)
+	SetExampleValue(ctx context.Context, category string, value int64) error
+
+	// AddOutboxEvent stores an event for delivery. Call it in the transaction that makes the change the event is about.
+	AddOutboxEvent(ctx context.Context, event OutboxEvent) error
+	// GetOutboxEvent returns ErrNotFound if there is no event with the id.
+	GetOutboxEvent(ctx context.Context, id string) (OutboxEvent, error)
+	// FindOutboxEvents returns up to limit events with the status, newest first. A blank status matches all events.
+	FindOutboxEvents(ctx context.Context, status OutboxStatus, limit int) ([]OutboxEvent, error)
+	// DueOutboxEvents returns up to limit pending events whose next attempt is due at now, oldest first.
+	DueOutboxEvents(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error)
+	// UpdateOutboxEvent stores the delivery state of an event. It returns ErrNotFound if there is no event with the id.
+	UpdateOutboxEvent(ctx context.Context, event OutboxEvent) error
+	// CountOutboxEvents returns the number of events per status.
+	CountOutboxEvents(ctx context.Context) (map[OutboxStatus]int64, error)
+	// DeleteDeliveredOutboxEvents removes events delivered before the given time, and returns how many it removed.
+	DeleteDeliveredOutboxEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+const (
+	TypeInMemory = "inmemory"
+	TypeSQLite   = "sqlite"
+)
+
+type Options struct {
+	// Type is one of the Type... constants.
+	Type string
+
+	// DSN is the data source name passed to the database driver.
+	DSN string
+
+	MaxOpenConnections    int
+	MaxIdleConnections    int
+	ConnectionMaxLifetime time.Duration
+}
+
+// New creates the Repository configured in options. It still needs to be opened.
+func New(options Options) (Repository, error) {
+	switch options.Type {
+	case TypeInMemory, "":
+		return NewInMemory(), nil
+	case TypeSQLite:
+		return NewSQL(sqliteDialect, options), nil
+	default:
+		return nil, fmt.Errorf("invalid database type %s, must be one of %s (default if blank), %s", options.Type, TypeInMemory, TypeSQLite)
+	}
+}
+
+const (
+	ConfDatabaseType                  = "DATABASE_TYPE"
+	ConfDatabaseDSN                   = "DATABASE_DSN"
+	ConfDatabaseMaxOpenConnections    = "DATABASE_MAX_OPEN_CONNECTIONS"
+	ConfDatabaseMaxIdleConnections    = "DATABASE_MAX_IDLE_CONNECTIONS"
+	ConfDatabaseConnectionMaxLifetime = "DATABASE_CONNECTION_MAX_LIFETIME_SECONDS"
+)
+
+func ConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfDatabaseType,
+			Default:     TypeInMemory,
+			Description: "database to store data in. One of inmemory (default, data is lost on restart), sqlite.",
+			Validate:    auconfigenv.ObtainPatternValidator("^(|inmemory|sqlite)$"),
+		}, {
+			Key:         ConfDatabaseDSN,
+			Default:     "file:reg-backend-template-test.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+			Description: "data source name of the database. For sqlite, a file name or file: URI, see https://pkg.go.dev/modernc.org/sqlite#Driver.Open. _txlock=immediate and a busy_timeout of 5 seconds are added unless set. Can be loaded from Vault.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfDatabaseMaxOpenConnections,
+			Default:     "10",
+			Description: "maximum number of open database connections.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 1000),
+		}, {
+			Key:         ConfDatabaseMaxIdleConnections,
+			Default:     "2",
+			Description: "maximum number of idle database connections kept open for reuse.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1000),
+		}, {
+			Key:         ConfDatabaseConnectionMaxLifetime,
+			Default:     "300",
+			Description: "time in seconds after which database connections are closed and replaced. 0 keeps them open indefinitely.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 86400),
+		},
+	}
+}
+
+func OptionsFromConfig() Options {
+	return Options{
+		Type:                  auconfigenv.Get(ConfDatabaseType),
+		DSN:                   auconfigenv.Get(ConfDatabaseDSN),
+		MaxOpenConnections:    aToInt(auconfigenv.Get(ConfDatabaseMaxOpenConnections), 10),
+		MaxIdleConnections:    aToInt(auconfigenv.Get(ConfDatabaseMaxIdleConnections), 2),
+		ConnectionMaxLifetime: time.Duration(aToInt(auconfigenv.Get(ConfDatabaseConnectionMaxLifetime), 300)) * time.Second,
+	}
+}
+
+func aToInt(s string, fallback int) int {
+	value, err := auconfigenv.AToInt(s)
+	if err != nil {
+		// config was validated so should only happen in tests, but use sensible value
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds the schema migrations, one directory per dialect.
+//
+// Files are named NNNN_description.sql and applied in order of their number. Never change a migration
+// once it has been released, add a new one instead.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+type migration struct {
+	version   int
+	name      string
+	statement string
+}
+
+func loadMigrations(dialectName string) ([]migration, error) {
+	dir := path.Join("migrations", dialectName)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database %s: %w", dialectName, err)
+	}
+
+	result := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		versionStr, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s, must start with a positive version number", entry.Name())
+		}
+		statement, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, migration{
+			version:   version,
+			name:      name,
+			statement: string(statement),
+		})
+	}
+
+	slices.SortFunc(result, func(a, b migration) int {
+		return a.version - b.version
+	})
+	for i := 1; i < len(result); i++ {
+		if result[i].version == result[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", result[i].version)
+		}
+	}
+	return result, nil
+}
+
+func (r *sqlRepository) Migrate(ctx context.Context) error {
+	migrations, err := loadMigrations(r.dialect.name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)"); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied, err := r.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := r.applyMigration(ctx, m); err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to apply migration %s: %s", m.name, err.Error())
+			return err
+		}
+		aulogging.Logger.Ctx(ctx).Info().Printf("applied migration %s", m.name)
+	}
+	return nil
+}
+
+func (r *sqlRepository) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		result[version] = true
+	}
+	return result, rows.Err()
+}
+
+// applyMigration applies a migration and records it in the same transaction, so a failed migration can be retried.
+//
+// Note that MySQL commits implicitly after DDL statements, so there a failed migration may need manual cleanup.
+func (r *sqlRepository) applyMigration(ctx context.Context, m migration) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, m.statement); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, timestamp.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,5 @@
+CREATE TABLE example_values (
+    category   VARCHAR(64) NOT NULL PRIMARY KEY,
+    value      BIGINT      NOT NULL,
+    updated_at TIMESTAMP   NOT NULL
+);
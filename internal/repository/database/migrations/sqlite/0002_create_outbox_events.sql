@@ -0,0 +1,16 @@
+-- times are unix milliseconds, so they compare correctly as numbers in every database
+CREATE TABLE outbox_events (
+    id              VARCHAR(36)  NOT NULL PRIMARY KEY,
+    event_id        VARCHAR(36)  NOT NULL,
+    event_type      VARCHAR(128) NOT NULL,
+    subscriber      VARCHAR(64)  NOT NULL,
+    payload         TEXT         NOT NULL,
+    status          VARCHAR(16)  NOT NULL,
+    attempts        INTEGER      NOT NULL,
+    last_error      TEXT         NOT NULL,
+    created_at      BIGINT       NOT NULL,
+    next_attempt_at BIGINT       NOT NULL,
+    delivered_at    BIGINT       NULL
+);
+
+CREATE INDEX outbox_events_due ON outbox_events (status, next_attempt_at);
@@ -0,0 +1,35 @@
+package database
+
+import "time"
+
+type OutboxStatus string
+
+const (
+	// OutboxPending events are waiting for their next delivery attempt.
+	OutboxPending OutboxStatus = "pending"
+	// OutboxDelivered events have been accepted by their subscriber.
+	OutboxDelivered OutboxStatus = "delivered"
+	// OutboxDead events have failed too often. They are only attempted again if replayed.
+	OutboxDead OutboxStatus = "dead"
+)
+
+// OutboxEvent is the delivery of a domain event to one subscriber.
+type OutboxEvent struct {
+	// ID identifies the delivery.
+	ID string
+	// EventID identifies the event, which is the same for all its subscribers.
+	EventID    string
+	EventType  string
+	Subscriber string
+	// Payload is the JSON document sent to the subscriber.
+	Payload string
+
+	Status   OutboxStatus
+	Attempts int
+	// LastError describes why the last delivery attempt failed, empty if it did not.
+	LastError string
+
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	DeliveredAt   *time.Time
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var tstOutboxNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func tstOutboxEvent(id string, createdAt time.Time) OutboxEvent {
+	return OutboxEvent{
+		ID:            id,
+		EventID:       "event-" + id,
+		EventType:     "example.value.set",
+		Subscriber:    "mail",
+		Payload:       `{"value":42}`,
+		Status:        OutboxPending,
+		CreatedAt:     createdAt,
+		NextAttemptAt: createdAt,
+	}
+}
+
+func TestOutboxEvents(t *testing.T) {
+	for name, options := range map[string]Options{
+		"inmemory": {Type: TypeInMemory},
+		"sqlite":   tstSQLiteOptions(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cut := tstOpen(t, options)
+
+			first := tstOutboxEvent("1", tstOutboxNow)
+			second := tstOutboxEvent("2", tstOutboxNow.Add(time.Second))
+			later := tstOutboxEvent("3", tstOutboxNow.Add(time.Second))
+			later.NextAttemptAt = tstOutboxNow.Add(time.Hour)
+			for _, event := range []OutboxEvent{first, second, later} {
+				require.NoError(t, cut.AddOutboxEvent(ctx, event))
+			}
+
+			actual, err := cut.GetOutboxEvent(ctx, "1")
+			require.NoError(t, err)
+			require.Equal(t, first, actual)
+			_, err = cut.GetOutboxEvent(ctx, "unknown")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			due, err := cut.DueOutboxEvents(ctx, tstOutboxNow.Add(time.Minute), 10)
+			require.NoError(t, err)
+			require.Equal(t, []OutboxEvent{first, second}, due, "oldest first, not yet due excluded")
+			due, err = cut.DueOutboxEvents(ctx, tstOutboxNow.Add(time.Minute), 1)
+			require.NoError(t, err)
+			require.Equal(t, []OutboxEvent{first}, due, "limited")
+
+			delivered := tstOutboxNow.Add(2 * time.Second)
+			first.Status = OutboxDelivered
+			first.Attempts = 2
+			first.DeliveredAt = &delivered
+			require.NoError(t, cut.UpdateOutboxEvent(ctx, first))
+			second.Status = OutboxDead
+			second.Attempts = 5
+			second.LastError = "status 500"
+			require.NoError(t, cut.UpdateOutboxEvent(ctx, second))
+			require.ErrorIs(t, cut.UpdateOutboxEvent(ctx, tstOutboxEvent("unknown", tstOutboxNow)), ErrNotFound)
+
+			found, err := cut.FindOutboxEvents(ctx, "", 10)
+			require.NoError(t, err)
+			require.Equal(t, []OutboxEvent{later, second, first}, found, "newest first")
+			found, err = cut.FindOutboxEvents(ctx, OutboxDead, 10)
+			require.NoError(t, err)
+			require.Equal(t, []OutboxEvent{second}, found)
+
+			counts, err := cut.CountOutboxEvents(ctx)
+			require.NoError(t, err)
+			require.Equal(t, map[OutboxStatus]int64{OutboxPending: 1, OutboxDelivered: 1, OutboxDead: 1}, counts)
+
+			count, err := cut.DeleteDeliveredOutboxEvents(ctx, delivered)
+			require.NoError(t, err)
+			require.Equal(t, int64(0), count, "delivered at the given time is kept")
+			count, err = cut.DeleteDeliveredOutboxEvents(ctx, delivered.Add(time.Millisecond))
+			require.NoError(t, err)
+			require.Equal(t, int64(1), count)
+			_, err = cut.GetOutboxEvent(ctx, "1")
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestOutboxEventsRolledBack(t *testing.T) {
+	for name, options := range map[string]Options{
+		"inmemory": {Type: TypeInMemory},
+		"sqlite":   tstSQLiteOptions(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			cut := tstOpen(t, options)
+
+			failure := errors.New("failure")
+			err := cut.RunInTransaction(ctx, func(ctx context.Context) error {
+				require.NoError(t, cut.SetExampleValue(ctx, "cat", 42))
+				require.NoError(t, cut.AddOutboxEvent(ctx, tstOutboxEvent("1", tstOutboxNow)))
+				return failure
+			})
+			require.ErrorIs(t, err, failure)
+
+			_, err = cut.GetOutboxEvent(ctx, "1")
+			require.ErrorIs(t, err, ErrNotFound, "event is rolled back with the change")
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	_ "modernc.org/sqlite"
+	"strings"
+)
+
+// dialect holds what differs between the supported databases.
+type dialect struct {
+	// name is the name of the database/sql driver, and the directory holding the migrations.
+	name string
+
+	// prepareDSN adds the settings the repository relies on, unless the configuration already sets them.
+	prepareDSN func(dsn string) string
+
+	upsertExampleValue string
+}
+
+var sqliteDialect = dialect{
+	name:               "sqlite",
+	prepareDSN:         prepareSQLiteDSN,
+	upsertExampleValue: "INSERT INTO example_values (category, value, updated_at) VALUES (?, ?, ?) ON CONFLICT (category) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at",
+}
+
+// prepareSQLiteDSN makes transactions take the write lock when they begin, so they are serialized as documented,
+// and makes connections wait for the lock instead of failing immediately.
+func prepareSQLiteDSN(dsn string) string {
+	var params []string
+	if !strings.Contains(dsn, "_txlock=") {
+		params = append(params, "_txlock=immediate")
+	}
+	if !strings.Contains(dsn, "busy_timeout") {
+		params = append(params, "_pragma=busy_timeout(5000)")
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + strings.Join(params, "&")
+	}
+	return dsn + "?" + strings.Join(params, "&")
+}
+
+type sqlRepository struct {
+	dialect dialect
+	options Options
+
+	db             *sql.DB
+	statsCollector prometheus.Collector
+}
+
+// NewSQL creates a Repository backed by a relational database.
+func NewSQL(dialect dialect, options Options) Repository {
+	return &sqlRepository{
+		dialect: dialect,
+		options: options,
+	}
+}
+
+func (r *sqlRepository) Open(ctx context.Context) error {
+	db, err := sql.Open(r.dialect.name, r.dialect.prepareDSN(r.options.DSN))
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to open %s database: %s", r.dialect.name, err.Error())
+		return err
+	}
+	db.SetMaxOpenConns(r.options.MaxOpenConnections)
+	db.SetMaxIdleConns(r.options.MaxIdleConnections)
+	db.SetConnMaxLifetime(r.options.ConnectionMaxLifetime)
+
+	if err := db.PingContext(ctx); err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to connect to %s database: %s", r.dialect.name, err.Error())
+		_ = db.Close()
+		return err
+	}
+	r.db = db
+
+	// connection pool metrics, go_sql_... with label db_name
+	r.statsCollector = collectors.NewDBStatsCollector(db, r.dialect.name)
+	if err := prometheus.Register(r.statsCollector); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to register database metrics: %s", err.Error())
+		r.statsCollector = nil
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("connected to %s database", r.dialect.name)
+	return nil
+}
+
+func (r *sqlRepository) Close() error {
+	if r.statsCollector != nil {
+		prometheus.Unregister(r.statsCollector)
+		r.statsCollector = nil
+	}
+	if r.db == nil {
+		return nil
+	}
+	err := r.db.Close()
+	r.db = nil
+	return err
+}
+
+func (r *sqlRepository) Ping(ctx context.Context) error {
+	if r.db == nil {
+		return errors.New("database is not open")
+	}
+	return r.db.PingContext(ctx)
+}
+
+type ctxKeySQLTx struct{}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// querier returns the transaction in ctx, if any, so repository calls take part in it.
+func (r *sqlRepository) querier(ctx context.Context) querier {
+	if tx, ok := ctx.Value(ctxKeySQLTx{}).(*sql.Tx); ok {
+		return tx
+	}
+	return r.db
+}
+
+func (r *sqlRepository) RunInTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(ctxKeySQLTx{}).(*sql.Tx); ok {
+		return f(ctx)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		// no effect after commit
+		_ = tx.Rollback()
+	}()
+
+	if err := f(context.WithValue(ctx, ctxKeySQLTx{}, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlRepository) GetExampleValue(ctx context.Context, category string) (int64, error) {
+	var value int64
+	err := r.querier(ctx).QueryRowContext(ctx, "SELECT value FROM example_values WHERE category = ?", category).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read example value for category %s: %w", category, err)
+	}
+	return value, nil
+}
+
+func (r *sqlRepository) SetExampleValue(ctx context.Context, category string, value int64) error {
+	if _, err := r.querier(ctx).ExecContext(ctx, r.dialect.upsertExampleValue, category, value, timestamp.Now()); err != nil {
+		return fmt.Errorf("failed to write example value for category %s: %w", category, err)
+	}
+	return nil
+}
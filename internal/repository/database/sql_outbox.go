@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const outboxColumns = "id, event_id, event_type, subscriber, payload, status, attempts, last_error, created_at, next_attempt_at, delivered_at"
+
+func (r *sqlRepository) AddOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	_, err := r.querier(ctx).ExecContext(ctx, "INSERT INTO outbox_events ("+outboxColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.ID, event.EventID, event.EventType, event.Subscriber, event.Payload, string(event.Status), event.Attempts, event.LastError,
+		event.CreatedAt.UnixMilli(), event.NextAttemptAt.UnixMilli(), toUnixMilli(event.DeliveredAt))
+	if err != nil {
+		return fmt.Errorf("failed to add outbox event %s: %w", event.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlRepository) GetOutboxEvent(ctx context.Context, id string) (OutboxEvent, error) {
+	row := r.querier(ctx).QueryRowContext(ctx, "SELECT "+outboxColumns+" FROM outbox_events WHERE id = ?", id)
+	event, err := scanOutboxEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OutboxEvent{}, ErrNotFound
+	}
+	if err != nil {
+		return OutboxEvent{}, fmt.Errorf("failed to read outbox event %s: %w", id, err)
+	}
+	return event, nil
+}
+
+func (r *sqlRepository) FindOutboxEvents(ctx context.Context, status OutboxStatus, limit int) ([]OutboxEvent, error) {
+	return r.queryOutboxEvents(ctx, "SELECT "+outboxColumns+" FROM outbox_events WHERE (? = '' OR status = ?) ORDER BY created_at DESC, id DESC LIMIT ?",
+		string(status), string(status), limit)
+}
+
+func (r *sqlRepository) DueOutboxEvents(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	return r.queryOutboxEvents(ctx, "SELECT "+outboxColumns+" FROM outbox_events WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		string(OutboxPending), now.UnixMilli(), limit)
+}
+
+func (r *sqlRepository) queryOutboxEvents(ctx context.Context, query string, args ...any) ([]OutboxEvent, error) {
+	rows, err := r.querier(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]OutboxEvent, 0)
+	for rows.Next() {
+		event, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox events: %w", err)
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
+
+func (r *sqlRepository) UpdateOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	result, err := r.querier(ctx).ExecContext(ctx, "UPDATE outbox_events SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, delivered_at = ? WHERE id = ?",
+		string(event.Status), event.Attempts, event.LastError, event.NextAttemptAt.UnixMilli(), toUnixMilli(event.DeliveredAt), event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update outbox event %s: %w", event.ID, err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update outbox event %s: %w", event.ID, err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *sqlRepository) CountOutboxEvents(ctx context.Context) (map[OutboxStatus]int64, error) {
+	rows, err := r.querier(ctx).QueryContext(ctx, "SELECT status, COUNT(*) FROM outbox_events GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[OutboxStatus]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to count outbox events: %w", err)
+		}
+		result[OutboxStatus(status)] = count
+	}
+	return result, rows.Err()
+}
+
+func (r *sqlRepository) DeleteDeliveredOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.querier(ctx).ExecContext(ctx, "DELETE FROM outbox_events WHERE status = ? AND delivered_at < ?",
+		string(OutboxDelivered), before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete delivered outbox events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOutboxEvent(row scanner) (OutboxEvent, error) {
+	var event OutboxEvent
+	var status string
+	var createdAt, nextAttemptAt int64
+	var deliveredAt sql.NullInt64
+	if err := row.Scan(&event.ID, &event.EventID, &event.EventType, &event.Subscriber, &event.Payload, &status,
+		&event.Attempts, &event.LastError, &createdAt, &nextAttemptAt, &deliveredAt); err != nil {
+		return OutboxEvent{}, err
+	}
+	event.Status = OutboxStatus(status)
+	event.CreatedAt = time.UnixMilli(createdAt).UTC()
+	event.NextAttemptAt = time.UnixMilli(nextAttemptAt).UTC()
+	if deliveredAt.Valid {
+		delivered := time.UnixMilli(deliveredAt.Int64).UTC()
+		event.DeliveredAt = &delivered
+	}
+	return event, nil
+}
+
+func toUnixMilli(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
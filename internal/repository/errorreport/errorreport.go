@@ -0,0 +1,116 @@
+package errorreport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"os"
+	"sync"
+	"time"
+)
+
+// Report describes a failure that a developer should look at, such as a recovered panic.
+type Report struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Message   string    `json:"message"`
+	Stack     []string  `json:"stack,omitempty"`
+}
+
+// Reporter forwards reports to an error tracking system.
+//
+// Only a local file sink is provided, but forwarding to an external error tracker can be added
+// by implementing this interface.
+type Reporter interface {
+	Report(ctx context.Context, report Report) error
+}
+
+const (
+	SinkNone = "none"
+	SinkFile = "file"
+)
+
+type Options struct {
+	Sink     string
+	FilePath string
+}
+
+// New creates the Reporter configured in options.
+func New(options Options) (Reporter, error) {
+	switch options.Sink {
+	case SinkNone, "":
+		return &noopReporter{}, nil
+	case SinkFile:
+		return NewFileReporter(options.FilePath)
+	default:
+		return nil, fmt.Errorf("invalid error report sink %s, must be one of %s (default if blank), %s", options.Sink, SinkNone, SinkFile)
+	}
+}
+
+type noopReporter struct{}
+
+func (r *noopReporter) Report(_ context.Context, _ Report) error {
+	return nil
+}
+
+type fileReporter struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// NewFileReporter creates a Reporter that appends each report to a file as a line of JSON.
+//
+// This is intended for local development and testing.
+func NewFileReporter(path string) (Reporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open error report file %s: %w", path, err)
+	}
+	return &fileReporter{file: file}, nil
+}
+
+func (r *fileReporter) Report(_ context.Context, report Report) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+const (
+	ConfErrorReportSink = "ERROR_REPORT_SINK"
+	ConfErrorReportFile = "ERROR_REPORT_FILE"
+)
+
+func ConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfErrorReportSink,
+			Default:     SinkNone,
+			Description: "where to report errors such as recovered panics, in addition to logging them. One of none (default), file.",
+			Validate:    auconfigenv.ObtainPatternValidator("^(|none|file)$"),
+		}, {
+			Key:         ConfErrorReportFile,
+			Default:     "error-reports.jsonl",
+			Description: "file to append error reports to, one JSON object per line, if " + ConfErrorReportSink + " is file. Intended for local development and testing.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		},
+	}
+}
+
+func OptionsFromConfig() Options {
+	return Options{
+		Sink:     auconfigenv.Get(ConfErrorReportSink),
+		FilePath: auconfigenv.Get(ConfErrorReportFile),
+	}
+}
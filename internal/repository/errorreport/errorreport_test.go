@@ -0,0 +1,52 @@
+package errorreport
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+	cut, err := New(Options{Sink: SinkFile, FilePath: path})
+	require.NoError(t, err)
+
+	first := Report{
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		RequestID: "a8b7c6d5",
+		Method:    "GET",
+		Route:     "/api/rest/v1/example",
+		Path:      "/api/rest/v1/example",
+		Message:   "oh no",
+		Stack:     []string{"main.main (main.go:1)"},
+	}
+	require.NoError(t, cut.Report(context.Background(), first))
+	require.NoError(t, cut.Report(context.Background(), Report{Message: "again"}))
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 2)
+
+	actual := Report{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &actual))
+	require.Equal(t, first, actual)
+	require.Equal(t, `{"timestamp":"0001-01-01T00:00:00Z","message":"again"}`, lines[1])
+}
+
+func TestNew(t *testing.T) {
+	cut, err := New(Options{})
+	require.NoError(t, err)
+	require.NoError(t, cut.Report(context.Background(), Report{Message: "ignored"}))
+
+	_, err = New(Options{Sink: "sentry"})
+	require.EqualError(t, err, "invalid error report sink sentry, must be one of none (default if blank), file")
+
+	_, err = New(Options{Sink: SinkFile, FilePath: filepath.Join(t.TempDir(), "missing", "reports.jsonl")})
+	require.ErrorContains(t, err, "failed to open error report file")
+}
@@ -0,0 +1,159 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"net/url"
+	"time"
+)
+
+// AllTypes subscribes to events of every type.
+const AllTypes = "*"
+
+// maxSubscriberNameLength matches the size of the subscriber column in the database.
+const maxSubscriberNameLength = 64
+
+// Subscriber is a service that is sent events.
+type Subscriber struct {
+	// URL receives each event in a POST request.
+	URL string `json:"url"`
+	// Types are the event types sent to the subscriber, or AllTypes.
+	Types []string `json:"types"`
+}
+
+type Options struct {
+	// Subscribers by name.
+	Subscribers map[string]Subscriber
+
+	// APIKey is sent to subscribers in the X-Api-Key header, unless blank.
+	APIKey         string
+	RequestTimeout time.Duration
+
+	// DispatchInterval is how often due events are delivered.
+	DispatchInterval time.Duration
+	// BatchSize is the maximum number of events delivered in one go.
+	BatchSize int
+
+	// MaxAttempts is how often delivery of an event is attempted before it is dead.
+	MaxAttempts int
+	// InitialBackoff is the wait before the second attempt, doubled for every further attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// DeliveredRetention is how long delivered events are kept. 0 keeps them forever.
+	DeliveredRetention time.Duration
+}
+
+const (
+	ConfEventsSubscribers               = "EVENTS_SUBSCRIBERS"
+	ConfEventsAPIKey                    = "EVENTS_API_KEY"
+	ConfEventsRequestTimeoutSeconds     = "EVENTS_REQUEST_TIMEOUT_SECONDS"
+	ConfEventsDispatchIntervalMillis    = "EVENTS_DISPATCH_INTERVAL_MILLISECONDS"
+	ConfEventsBatchSize                 = "EVENTS_BATCH_SIZE"
+	ConfEventsMaxAttempts               = "EVENTS_MAX_ATTEMPTS"
+	ConfEventsRetryInitialBackoffMillis = "EVENTS_RETRY_INITIAL_BACKOFF_MILLISECONDS"
+	ConfEventsRetryMaxBackoffSeconds    = "EVENTS_RETRY_MAX_BACKOFF_SECONDS"
+	ConfEventsDeliveredRetentionHours   = "EVENTS_DELIVERED_RETENTION_HOURS"
+)
+
+func ConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfEventsSubscribers,
+			Default:     "{}",
+			Description: `services that are sent domain events, as a JSON object by subscriber name, e.g. {"mail": {"url": "http://mail-service/api/rest/v1/events", "types": ["example.value.set"]}}. Use the type "*" for all events. Events are only stored for the subscribers configured when they occur.`,
+			Validate:    validateSubscribers,
+		}, {
+			Key:         ConfEventsAPIKey,
+			Default:     "",
+			Description: "api key sent to subscribers in the X-Api-Key header. Not sent if blank. Can be loaded from Vault.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfEventsRequestTimeoutSeconds,
+			Default:     "10",
+			Description: "timeout in seconds for delivering an event to a subscriber.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 300),
+		}, {
+			Key:         ConfEventsDispatchIntervalMillis,
+			Default:     "1000",
+			Description: "how often due events are delivered, in milliseconds.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(10, 3600000),
+		}, {
+			Key:         ConfEventsBatchSize,
+			Default:     "50",
+			Description: "maximum number of events delivered in one go. The rest is delivered in the next run.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 1000),
+		}, {
+			Key:         ConfEventsMaxAttempts,
+			Default:     "10",
+			Description: "how often delivery of an event is attempted. After that, the event is dead and is only delivered again if replayed through the admin endpoint.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 100),
+		}, {
+			Key:         ConfEventsRetryInitialBackoffMillis,
+			Default:     "1000",
+			Description: "wait in milliseconds before the second delivery attempt of an event. Doubled for every further attempt.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 3600000),
+		}, {
+			Key:         ConfEventsRetryMaxBackoffSeconds,
+			Default:     "3600",
+			Description: "maximum wait in seconds between delivery attempts of an event.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 86400),
+		}, {
+			Key:         ConfEventsDeliveredRetentionHours,
+			Default:     "168",
+			Description: "how long delivered events are kept, in hours. 0 keeps them forever. Dead events are always kept.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 87600),
+		},
+	}
+}
+
+func validateSubscribers(key string) error {
+	_, err := parseSubscribers(auconfigenv.Get(key))
+	return err
+}
+
+func parseSubscribers(value string) (map[string]Subscriber, error) {
+	result := make(map[string]Subscriber)
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil, fmt.Errorf("invalid subscribers, must be a JSON object of subscribers by name: %w", err)
+	}
+	for name, subscriber := range result {
+		if name == "" || len(name) > maxSubscriberNameLength {
+			return nil, fmt.Errorf("invalid subscriber name '%s', must be 1 to %d characters long", name, maxSubscriberNameLength)
+		}
+		if u, err := url.Parse(subscriber.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url for subscriber %s, must be an absolute http or https url", name)
+		}
+		if len(subscriber.Types) == 0 {
+			return nil, fmt.Errorf("subscriber %s has no event types", name)
+		}
+	}
+	return result, nil
+}
+
+func OptionsFromConfig() Options {
+	// validated already
+	subscribers, _ := parseSubscribers(auconfigenv.Get(ConfEventsSubscribers))
+	return Options{
+		Subscribers:        subscribers,
+		APIKey:             auconfigenv.Get(ConfEventsAPIKey),
+		RequestTimeout:     time.Duration(aToInt(auconfigenv.Get(ConfEventsRequestTimeoutSeconds), 10)) * time.Second,
+		DispatchInterval:   time.Duration(aToInt(auconfigenv.Get(ConfEventsDispatchIntervalMillis), 1000)) * time.Millisecond,
+		BatchSize:          aToInt(auconfigenv.Get(ConfEventsBatchSize), 50),
+		MaxAttempts:        aToInt(auconfigenv.Get(ConfEventsMaxAttempts), 10),
+		InitialBackoff:     time.Duration(aToInt(auconfigenv.Get(ConfEventsRetryInitialBackoffMillis), 1000)) * time.Millisecond,
+		MaxBackoff:         time.Duration(aToInt(auconfigenv.Get(ConfEventsRetryMaxBackoffSeconds), 3600)) * time.Second,
+		DeliveredRetention: time.Duration(aToInt(auconfigenv.Get(ConfEventsDeliveredRetentionHours), 168)) * time.Hour,
+	}
+}
+
+func aToInt(s string, fallback int) int {
+	value, err := auconfigenv.AToInt(s)
+	if err != nil {
+		// config was validated so should only happen in tests, but use sensible value
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,181 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	aurestclientprometheus "github.com/StephanHCB/go-autumn-restclient-prometheus"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
+	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
+	"github.com/go-http-utils/headers"
+	"net/http"
+	"time"
+)
+
+// maxErrorLength keeps error messages of failed attempts from bloating the outbox.
+const maxErrorLength = 1000
+
+// DispatchInBackground delivers due events every interval.
+//
+// Runs until ctx is cancelled. The returned channel is closed once it has stopped, so a delivery that is
+// under way can be recorded before the database is closed.
+func DispatchInBackground(ctx context.Context, o Outbox, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+
+			if err := o.DispatchDue(ctx); err != nil && ctx.Err() == nil {
+				aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to dispatch events: %s", err.Error())
+			}
+		}
+	}()
+	return done
+}
+
+func (o *outbox) DispatchDue(ctx context.Context) error {
+	o.dispatchMutex.Lock()
+	defer o.dispatchMutex.Unlock()
+
+	due, err := o.db.DueOutboxEvents(ctx, timestamp.Now().UTC(), o.options.BatchSize)
+	if err != nil {
+		return err
+	}
+	for _, event := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := o.dispatch(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	if o.options.DeliveredRetention > 0 {
+		if _, err := o.db.DeleteDeliveredOutboxEvents(ctx, timestamp.Now().UTC().Add(-o.options.DeliveredRetention)); err != nil {
+			return err
+		}
+	}
+	return o.updateOutboxSizeMetric(ctx)
+}
+
+// dispatch attempts delivery of an event and records the outcome. It only fails if the outcome cannot be recorded.
+func (o *outbox) dispatch(ctx context.Context, event database.OutboxEvent) error {
+	// correlates the delivery with the request that caused the event, in logs and at the subscriber
+	envelope := Envelope{}
+	if err := json.Unmarshal([]byte(event.Payload), &envelope); err == nil && envelope.RequestID != "" {
+		ctx = context.WithValue(ctx, common.CtxKeyRequestID{}, envelope.RequestID)
+	}
+
+	err := o.deliver(ctx, event)
+	now := timestamp.Now().UTC()
+	event.Attempts++
+	result := resultDelivered
+	switch {
+	case err == nil:
+		event.Status = database.OutboxDelivered
+		event.LastError = ""
+		event.DeliveredAt = &now
+	case event.Attempts >= o.options.MaxAttempts:
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("giving up on event %s for subscriber %s after %d attempts: %s", event.ID, event.Subscriber, event.Attempts, err.Error())
+		event.Status = database.OutboxDead
+		event.LastError = truncate(err.Error())
+		result = resultDead
+	default:
+		wait := o.backoff(event.Attempts)
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to deliver event %s to subscriber %s, retrying in %s: %s", event.ID, event.Subscriber, wait, err.Error())
+		event.LastError = truncate(err.Error())
+		event.NextAttemptAt = now.Add(wait)
+		result = resultFailed
+	}
+	eventDeliveries.WithLabelValues(event.EventType, event.Subscriber, result).Inc()
+
+	// the outcome must be recorded even if dispatch is being stopped, or the event is delivered again
+	if err := o.db.UpdateOutboxEvent(context.WithoutCancel(ctx), event); err != nil {
+		return fmt.Errorf("failed to record delivery of event %s: %w", event.ID, err)
+	}
+	return nil
+}
+
+func (o *outbox) deliver(ctx context.Context, event database.OutboxEvent) error {
+	subscriber, ok := o.options.Subscribers[event.Subscriber]
+	if !ok {
+		return fmt.Errorf("subscriber %s is no longer configured", event.Subscriber)
+	}
+	return o.sender.send(ctx, subscriber.URL, event.Payload)
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (o *outbox) backoff(attempts int) time.Duration {
+	wait := o.options.InitialBackoff
+	for i := 1; i < attempts && wait < o.options.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, o.options.MaxBackoff)
+}
+
+func truncate(message string) string {
+	if len(message) > maxErrorLength {
+		return message[:maxErrorLength]
+	}
+	return message
+}
+
+// sender sends the payload of an event to a subscriber.
+type sender interface {
+	send(ctx context.Context, url string, payload string) error
+}
+
+type httpSender struct {
+	client aurestclientapi.Client
+	apiKey string
+}
+
+func newHTTPSender(options Options) sender {
+	s := &httpSender{
+		apiKey: options.APIKey,
+	}
+
+	httpClient, err := auresthttpclient.New(options.RequestTimeout, nil, s.requestManipulator)
+	if err != nil {
+		aulogging.Logger.NoCtx().Fatal().WithErr(err).Printf("Failed to instantiate events client - BAILING OUT: %s", err.Error())
+	}
+	aurestclientprometheus.InstrumentHttpClient(httpClient)
+
+	s.client = aurestlogging.New(tracing.NewClient(httpClient, "events"))
+	return s
+}
+
+func (s *httpSender) requestManipulator(ctx context.Context, r *http.Request) {
+	tracing.InjectHeaders(ctx, r)
+	if s.apiKey != "" {
+		r.Header.Set("X-Api-Key", s.apiKey)
+	}
+	r.Header.Set(headers.Accept, aurestclientapi.ContentTypeApplicationJson)
+}
+
+func (s *httpSender) send(ctx context.Context, url string, payload string) error {
+	// the response body is not needed, and must not fail delivery if it is not JSON
+	var body *[]byte
+	response := aurestclientapi.ParsedResponse{
+		Body: &body,
+	}
+	if err := s.client.Perform(ctx, http.MethodPost, url, payload, &response); err != nil {
+		return err
+	}
+	if response.Status < 200 || response.Status >= 300 {
+		return fmt.Errorf("subscriber responded with status %d", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrNotDead = errors.New("event is not dead")
+
+// Event is a domain event, a plain struct that is sent to subscribers encoded as JSON.
+type Event interface {
+	// EventType names the kind of event, such as example.value.set. Subscribers choose events by type.
+	EventType() string
+}
+
+// Envelope is the JSON document delivered to subscribers.
+//
+// Delivery is at least once, so subscribers should ignore events with an ID they have already processed.
+type Envelope struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// RequestID is the id of the request that caused the event, if any.
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Publisher stores domain events for delivery.
+type Publisher interface {
+	// Publish stores the event for each subscriber of its type.
+	//
+	// Call it with the context of the transaction that makes the change the event is about, see
+	// database.Repository.RunInTransaction, so the event is stored if and only if the change is.
+	Publish(ctx context.Context, event Event) error
+}
+
+// Outbox stores domain events and delivers them to subscribers.
+type Outbox interface {
+	Publisher
+
+	// DispatchDue attempts delivery of all events that are due. DispatchInBackground calls it periodically.
+	DispatchDue(ctx context.Context) error
+
+	// Events returns up to limit events with the status, newest first. A blank status matches all events.
+	Events(ctx context.Context, status database.OutboxStatus, limit int) ([]database.OutboxEvent, error)
+
+	// Replay schedules a dead event for immediate delivery, with a fresh count of attempts.
+	//
+	// Returns database.ErrNotFound if there is no such event, and ErrNotDead unless the event is dead.
+	Replay(ctx context.Context, id string) (database.OutboxEvent, error)
+}
+
+type outbox struct {
+	db      database.Repository
+	options Options
+	sender  sender
+
+	// dispatchMutex prevents overlapping dispatch runs, which would deliver events twice.
+	dispatchMutex sync.Mutex
+}
+
+// New creates an Outbox that stores events in db.
+func New(db database.Repository, options Options) Outbox {
+	setupMetrics()
+	return &outbox{
+		db:      db,
+		options: options,
+		sender:  newHTTPSender(options),
+	}
+}
+
+func (o *outbox) Publish(ctx context.Context, event Event) error {
+	eventType := event.EventType()
+	subscribers := o.options.subscribersOf(eventType)
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", eventType, err)
+	}
+	envelope := Envelope{
+		ID:         uuid.NewString(),
+		Type:       eventType,
+		OccurredAt: timestamp.Now().UTC(),
+		Data:       data,
+	}
+	// not common.GetRequestID, which falls back to a placeholder
+	if requestID, ok := ctx.Value(common.CtxKeyRequestID{}).(string); ok {
+		envelope.RequestID = requestID
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", eventType, err)
+	}
+
+	// joins the transaction of the caller, if any
+	return o.db.RunInTransaction(ctx, func(ctx context.Context) error {
+		for _, subscriber := range subscribers {
+			if err := o.db.AddOutboxEvent(ctx, database.OutboxEvent{
+				ID:            uuid.NewString(),
+				EventID:       envelope.ID,
+				EventType:     eventType,
+				Subscriber:    subscriber,
+				Payload:       string(payload),
+				Status:        database.OutboxPending,
+				CreatedAt:     envelope.OccurredAt,
+				NextAttemptAt: envelope.OccurredAt,
+			}); err != nil {
+				return err
+			}
+			eventsPublished.WithLabelValues(eventType, subscriber).Inc()
+		}
+		return nil
+	})
+}
+
+func (o *outbox) Events(ctx context.Context, status database.OutboxStatus, limit int) ([]database.OutboxEvent, error) {
+	return o.db.FindOutboxEvents(ctx, status, limit)
+}
+
+func (o *outbox) Replay(ctx context.Context, id string) (database.OutboxEvent, error) {
+	var event database.OutboxEvent
+	err := o.db.RunInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		event, err = o.db.GetOutboxEvent(ctx, id)
+		if err != nil {
+			return err
+		}
+		if event.Status != database.OutboxDead {
+			return ErrNotDead
+		}
+
+		event.Status = database.OutboxPending
+		event.Attempts = 0
+		event.NextAttemptAt = timestamp.Now().UTC()
+		return o.db.UpdateOutboxEvent(ctx, event)
+	})
+	return event, err
+}
+
+// subscribersOf returns the names of the subscribers of an event type, sorted.
+func (o Options) subscribersOf(eventType string) []string {
+	result := make([]string, 0)
+	for name, subscriber := range o.Subscribers {
+		if slices.Contains(subscriber.Types, eventType) || slices.Contains(subscriber.Types, AllTypes) {
+			result = append(result, name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
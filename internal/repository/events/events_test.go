@@ -0,0 +1,349 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var tstNow = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+type tstValueSet struct {
+	Category string `json:"category"`
+	Value    int64  `json:"value"`
+}
+
+func (e tstValueSet) EventType() string {
+	return "example.value.set"
+}
+
+type tstReceived struct {
+	envelope  Envelope
+	apiKey    string
+	requestID string
+}
+
+// tstSubscriber answers with status, and records the events it receives.
+type tstSubscriber struct {
+	server   *httptest.Server
+	status   int
+	received []tstReceived
+	mutex    sync.Mutex
+}
+
+func tstNewSubscriber(t *testing.T) *tstSubscriber {
+	s := &tstSubscriber{status: http.StatusNoContent}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		envelope := Envelope{}
+		_ = json.Unmarshal(body, &envelope)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.received = append(s.received, tstReceived{
+			envelope:  envelope,
+			apiKey:    r.Header.Get("X-Api-Key"),
+			requestID: r.Header.Get(tracing.RequestIDHeader),
+		})
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *tstSubscriber) setStatus(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = status
+}
+
+func (s *tstSubscriber) events() []tstReceived {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]tstReceived{}, s.received...)
+}
+
+func tstOptions(subscribers map[string]Subscriber) Options {
+	return Options{
+		Subscribers:      subscribers,
+		APIKey:           "events-api-key",
+		RequestTimeout:   time.Second,
+		DispatchInterval: time.Second,
+		BatchSize:        10,
+		MaxAttempts:      3,
+		InitialBackoff:   time.Second,
+		MaxBackoff:       time.Minute,
+	}
+}
+
+func tstRequestContext() context.Context {
+	return context.WithValue(context.Background(), common.CtxKeyRequestID{}, "a8b7c6d5")
+}
+
+func TestPublishAndDispatch(t *testing.T) {
+	timestamp.SetFakeNow(tstNow)
+	subscriber := tstNewSubscriber(t)
+	db := database.NewInMemory()
+	cut := New(db, tstOptions(map[string]Subscriber{
+		"mail":  {URL: subscriber.server.URL, Types: []string{"example.value.set"}},
+		"all":   {URL: subscriber.server.URL, Types: []string{AllTypes}},
+		"other": {URL: subscriber.server.URL, Types: []string{"other.event"}},
+	}))
+
+	require.NoError(t, cut.Publish(tstRequestContext(), tstValueSet{Category: "cat", Value: 42}))
+
+	pending, err := cut.Events(context.Background(), database.OutboxPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2, "one per subscriber of the type")
+	require.NotEqual(t, pending[0].ID, pending[1].ID)
+	require.Equal(t, pending[0].EventID, pending[1].EventID)
+
+	require.NoError(t, cut.DispatchDue(context.Background()))
+
+	received := subscriber.events()
+	require.Len(t, received, 2)
+	for _, r := range received {
+		require.Equal(t, "events-api-key", r.apiKey)
+		require.Equal(t, "a8b7c6d5", r.requestID)
+		require.Equal(t, pending[0].EventID, r.envelope.ID)
+		require.Equal(t, "example.value.set", r.envelope.Type)
+		require.Equal(t, tstNow, r.envelope.OccurredAt)
+		require.Equal(t, "a8b7c6d5", r.envelope.RequestID)
+		require.JSONEq(t, `{"category":"cat","value":42}`, string(r.envelope.Data))
+	}
+
+	delivered, err := cut.Events(context.Background(), database.OutboxDelivered, 10)
+	require.NoError(t, err)
+	require.Len(t, delivered, 2)
+	for _, event := range delivered {
+		require.Equal(t, 1, event.Attempts)
+		require.Equal(t, &tstNow, event.DeliveredAt)
+	}
+}
+
+func TestPublishWithoutSubscribers(t *testing.T) {
+	db := database.NewInMemory()
+	cut := New(db, tstOptions(nil))
+
+	require.NoError(t, cut.Publish(context.Background(), tstValueSet{Category: "cat", Value: 42}))
+
+	all, err := cut.Events(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Empty(t, all)
+}
+
+func TestPublishRolledBack(t *testing.T) {
+	timestamp.SetFakeNow(tstNow)
+	db := database.NewInMemory()
+	cut := New(db, tstOptions(map[string]Subscriber{
+		"mail": {URL: "http://localhost/events", Types: []string{AllTypes}},
+	}))
+
+	failure := errors.New("failure")
+	err := db.RunInTransaction(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, db.SetExampleValue(ctx, "cat", 42))
+		require.NoError(t, cut.Publish(ctx, tstValueSet{Category: "cat", Value: 42}))
+		return failure
+	})
+	require.ErrorIs(t, err, failure)
+
+	all, err := cut.Events(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Empty(t, all, "event is rolled back with the change")
+}
+
+func TestRetryDeadLetterAndReplay(t *testing.T) {
+	ctx := context.Background()
+	timestamp.SetFakeNow(tstNow)
+	subscriber := tstNewSubscriber(t)
+	subscriber.setStatus(http.StatusInternalServerError)
+	db := database.NewInMemory()
+	cut := New(db, tstOptions(map[string]Subscriber{
+		"mail": {URL: subscriber.server.URL, Types: []string{AllTypes}},
+	}))
+	require.NoError(t, cut.Publish(ctx, tstValueSet{Category: "cat", Value: 42}))
+
+	// first attempt
+	require.NoError(t, cut.DispatchDue(ctx))
+	events, err := cut.Events(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	event := events[0]
+	require.Equal(t, database.OutboxPending, event.Status)
+	require.Equal(t, 1, event.Attempts)
+	require.Equal(t, "subscriber responded with status 500", event.LastError)
+	require.Equal(t, tstNow.Add(time.Second), event.NextAttemptAt)
+
+	// not due yet
+	require.NoError(t, cut.DispatchDue(ctx))
+	require.Len(t, subscriber.events(), 1)
+
+	// second attempt, backoff doubles
+	timestamp.SetFakeNow(tstNow.Add(time.Second))
+	require.NoError(t, cut.DispatchDue(ctx))
+	event, err = db.GetOutboxEvent(ctx, event.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, event.Attempts)
+	require.Equal(t, tstNow.Add(3*time.Second), event.NextAttemptAt)
+
+	// last attempt
+	timestamp.SetFakeNow(tstNow.Add(3 * time.Second))
+	require.NoError(t, cut.DispatchDue(ctx))
+	event, err = db.GetOutboxEvent(ctx, event.ID)
+	require.NoError(t, err)
+	require.Equal(t, database.OutboxDead, event.Status)
+	require.Equal(t, 3, event.Attempts)
+
+	// dead events are not attempted again
+	timestamp.SetFakeNow(tstNow.Add(time.Hour))
+	require.NoError(t, cut.DispatchDue(ctx))
+	require.Len(t, subscriber.events(), 3)
+
+	// until replayed
+	subscriber.setStatus(http.StatusOK)
+	replayed, err := cut.Replay(ctx, event.ID)
+	require.NoError(t, err)
+	require.Equal(t, database.OutboxPending, replayed.Status)
+	require.Equal(t, 0, replayed.Attempts)
+	require.Equal(t, tstNow.Add(time.Hour), replayed.NextAttemptAt)
+
+	require.NoError(t, cut.DispatchDue(ctx))
+	require.Len(t, subscriber.events(), 4)
+	event, err = db.GetOutboxEvent(ctx, event.ID)
+	require.NoError(t, err)
+	require.Equal(t, database.OutboxDelivered, event.Status)
+	require.Equal(t, 1, event.Attempts)
+	require.Empty(t, event.LastError)
+
+	_, err = cut.Replay(ctx, event.ID)
+	require.ErrorIs(t, err, ErrNotDead)
+	_, err = cut.Replay(ctx, "unknown")
+	require.ErrorIs(t, err, database.ErrNotFound)
+}
+
+func TestDispatchInBackgroundStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timestamp.SetFakeNow(tstNow)
+	received := make(chan struct{})
+	release := make(chan struct{})
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer subscriber.Close()
+	// unlike the in-memory implementation, sqlite fails when used with a cancelled context
+	db, err := database.New(database.Options{
+		Type: database.TypeSQLite,
+		DSN:  "file:" + filepath.Join(t.TempDir(), "test.db"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Open(ctx))
+	defer db.Close()
+	require.NoError(t, db.Migrate(ctx))
+	cut := New(db, tstOptions(map[string]Subscriber{
+		"mail": {URL: subscriber.URL, Types: []string{AllTypes}},
+	}))
+	require.NoError(t, cut.Publish(ctx, tstValueSet{Category: "cat", Value: 42}))
+
+	done := DispatchInBackground(ctx, cut, 10*time.Millisecond)
+	<-received
+	cancel()
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "dispatch did not stop when cancelled")
+	}
+
+	events, err := cut.Events(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, 1, events[0].Attempts, "the attempt under way when stopped is recorded")
+}
+
+func TestDispatchRemovedSubscriber(t *testing.T) {
+	ctx := context.Background()
+	timestamp.SetFakeNow(tstNow)
+	db := database.NewInMemory()
+	publisher := New(db, tstOptions(map[string]Subscriber{
+		"mail": {URL: "http://localhost/events", Types: []string{AllTypes}},
+	}))
+	require.NoError(t, publisher.Publish(ctx, tstValueSet{Category: "cat", Value: 42}))
+
+	cut := New(db, tstOptions(nil))
+	require.NoError(t, cut.DispatchDue(ctx))
+
+	events, err := cut.Events(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "subscriber mail is no longer configured", events[0].LastError)
+}
+
+func TestDispatchDeletesOldDeliveredEvents(t *testing.T) {
+	ctx := context.Background()
+	timestamp.SetFakeNow(tstNow)
+	subscriber := tstNewSubscriber(t)
+	db := database.NewInMemory()
+	options := tstOptions(map[string]Subscriber{
+		"mail": {URL: subscriber.server.URL, Types: []string{AllTypes}},
+	})
+	options.DeliveredRetention = time.Hour
+	cut := New(db, options)
+	require.NoError(t, cut.Publish(ctx, tstValueSet{Category: "cat", Value: 42}))
+	require.NoError(t, cut.DispatchDue(ctx))
+
+	timestamp.SetFakeNow(tstNow.Add(time.Hour))
+	require.NoError(t, cut.DispatchDue(ctx))
+	events, err := cut.Events(ctx, "", 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1, "kept for the retention time")
+
+	timestamp.SetFakeNow(tstNow.Add(time.Hour + time.Millisecond))
+	require.NoError(t, cut.DispatchDue(ctx))
+	events, err = cut.Events(ctx, "", 10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestBackoff(t *testing.T) {
+	cut := &outbox{options: Options{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+
+	require.Equal(t, time.Second, cut.backoff(1))
+	require.Equal(t, 2*time.Second, cut.backoff(2))
+	require.Equal(t, 8*time.Second, cut.backoff(4))
+	require.Equal(t, 10*time.Second, cut.backoff(5))
+	require.Equal(t, 10*time.Second, cut.backoff(100))
+}
+
+func TestParseSubscribers(t *testing.T) {
+	subscribers, err := parseSubscribers(`{"mail": {"url": "https://mail/events", "types": ["example.value.set"]}}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]Subscriber{"mail": {URL: "https://mail/events", Types: []string{"example.value.set"}}}, subscribers)
+
+	for name, value := range map[string]string{
+		"not_json":     `[]`,
+		"relative_url": `{"mail": {"url": "/events", "types": ["*"]}}`,
+		"ftp_url":      `{"mail": {"url": "ftp://mail/events", "types": ["*"]}}`,
+		"no_types":     `{"mail": {"url": "https://mail/events"}}`,
+		"empty_name":   `{"": {"url": "https://mail/events", "types": ["*"]}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseSubscribers(value)
+			require.Error(t, err)
+		})
+	}
+}
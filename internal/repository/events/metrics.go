@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+var (
+	EventsPublishedCounterName = "events_published_total"
+	EventDeliveriesCounterName = "events_deliveries_total"
+	OutboxSizeGaugeName        = "events_outbox_size"
+
+	eventsPublished *prometheus.CounterVec
+	eventDeliveries *prometheus.CounterVec
+	outboxSize      *prometheus.GaugeVec
+
+	// New may be called more than once, but the metrics are registered only once
+	metricsOnce sync.Once
+)
+
+const (
+	resultDelivered = "delivered"
+	resultFailed    = "failed"
+	resultDead      = "dead"
+)
+
+func setupMetrics() {
+	metricsOnce.Do(func() {
+		eventsPublished = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: EventsPublishedCounterName,
+				Help: "Number of events stored for delivery, partitioned by event type and subscriber.",
+			},
+			[]string{"type", "subscriber"},
+		)
+		prometheus.MustRegister(eventsPublished)
+
+		eventDeliveries = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: EventDeliveriesCounterName,
+				Help: "Number of attempts to deliver events, partitioned by event type, subscriber, and result (delivered, failed if it will be retried, or dead if it will not).",
+			},
+			[]string{"type", "subscriber", "result"},
+		)
+		prometheus.MustRegister(eventDeliveries)
+
+		outboxSize = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: OutboxSizeGaugeName,
+				Help: "Number of events in the outbox, partitioned by status (pending, delivered, or dead).",
+			},
+			[]string{"status"},
+		)
+		prometheus.MustRegister(outboxSize)
+	})
+}
+
+func (o *outbox) updateOutboxSizeMetric(ctx context.Context) error {
+	counts, err := o.db.CountOutboxEvents(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range []database.OutboxStatus{database.OutboxPending, database.OutboxDelivered, database.OutboxDead} {
+		outboxSize.WithLabelValues(string(status)).Set(float64(counts[status]))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package idp
+
+import (
+	"context"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	aurestbreakerprometheus "github.com/StephanHCB/go-autumn-restclient-circuitbreaker-prometheus"
+	aurestbreaker "github.com/StephanHCB/go-autumn-restclient-circuitbreaker/implementation/breaker"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	aurestnontripping "github.com/StephanHCB/go-autumn-restclient/implementation/errors/nontrippingerror"
+	"github.com/sony/gobreaker"
+)
+
+// breakerClient logs state changes of the circuit breaker with the request that observed them.
+//
+// gobreaker reports state changes without a context, so we compare the state before and after each request instead.
+type breakerClient struct {
+	wrapped aurestclientapi.Client
+	cb      *gobreaker.CircuitBreaker
+}
+
+// newBreakerClient sets up the go-autumn-restclient circuit breaker, but with configurable trip threshold.
+func newBreakerClient(wrapped aurestclientapi.Client, options Options) aurestclientapi.Client {
+	instance := &aurestbreaker.Impl{
+		Wrapped:             wrapped,
+		Name:                breakerName(options.Name),
+		RequestTimeout:      options.RequestTimeout,
+		StateChangeCallback: func(_ string, _ string) {},
+		CountsCallback:      func(_ string, _ gobreaker.Counts) {},
+	}
+	instance.CB = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        instance.Name,
+		MaxRequests: options.BreakerHalfOpenRequests,
+		Interval:    options.BreakerClearInterval,
+		Timeout:     options.BreakerOpenTime,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= options.BreakerFailureThreshold
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			instance.StateChangeCallback(name, to.String())
+		},
+		IsSuccessful: func(err error) bool {
+			return err == nil || aurestnontripping.Is(err)
+		},
+	})
+	aurestbreakerprometheus.InstrumentCircuitBreakerClient(instance)
+
+	return &breakerClient{
+		wrapped: instance,
+		cb:      instance.CB,
+	}
+}
+
+func (c *breakerClient) Perform(ctx context.Context, method string, requestUrl string, requestBody interface{}, response *aurestclientapi.ParsedResponse) error {
+	before := c.cb.State()
+	err := c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	after := c.cb.State()
+	if before != after {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("circuit breaker %s state change %s -> %s during %s %s", c.cb.Name(), before.String(), after.String(), method, requestUrl)
+	}
+	return err
+}
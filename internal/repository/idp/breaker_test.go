@@ -0,0 +1,52 @@
+package idp
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker_OpensAndCloses(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	idpServer := userinfoIDP(t, &failing, &calls)
+	defer idpServer.Close()
+
+	cut := New(Options{
+		Name:                    "breaker-test",
+		RequestTimeout:          5 * time.Second,
+		OIDCWellKnownURL:        idpServer.URL + "/.well-known/openid-configuration",
+		BreakerFailureThreshold: 3,
+		BreakerHalfOpenRequests: 1,
+		BreakerOpenTime:         50 * time.Millisecond,
+	})
+	require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+
+	failing.Store(true)
+	for range 3 {
+		_, status, err := cut.UserInfo(withToken("valid-token"))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadGateway, status)
+	}
+	require.Equal(t, int32(3), calls.Load())
+
+	_, _, err := cut.UserInfo(withToken("valid-token"))
+	require.ErrorIs(t, err, gobreaker.ErrOpenState)
+	require.Equal(t, int32(3), calls.Load(), "an open circuit breaker must not contact the identity provider")
+
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+
+	for range 2 {
+		result, status, err := cut.UserInfo(withToken("valid-token"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "1234", result.Subject)
+	}
+	require.Equal(t, int32(5), calls.Load(), "a closed circuit breaker must contact the identity provider again")
+}
@@ -6,35 +6,70 @@ import (
 	"fmt"
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
-	aurestbreakerprometheus "github.com/StephanHCB/go-autumn-restclient-circuitbreaker-prometheus"
-	aurestbreaker "github.com/StephanHCB/go-autumn-restclient-circuitbreaker/implementation/breaker"
 	aurestclientprometheus "github.com/StephanHCB/go-autumn-restclient-prometheus"
 	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
-	aurestcaching "github.com/StephanHCB/go-autumn-restclient/implementation/caching"
 	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
 	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
 	"github.com/go-http-utils/headers"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Options struct {
+	// Name identifies the identity provider in logs and metrics. Defaults to DefaultName.
+	Name string
+
 	RequestTimeout time.Duration
 
 	CacheEnabled       bool
 	CacheRetentionTime time.Duration
+	CacheGraceTime     time.Duration
 
 	OIDCWellKnownURL string
 
 	TokenIntrospectionURL string
+
+	ClientID         string
+	ClientSecret     string
+	ClientAuthMethod string
+	ClientScopes     string
+
+	// BreakerFailureThreshold is the number of consecutive failures that opens the circuit breaker.
+	BreakerFailureThreshold uint32
+	// BreakerHalfOpenRequests is the number of successful requests in half open state that close the circuit breaker.
+	BreakerHalfOpenRequests uint32
+	// BreakerClearInterval is how often failure counts are reset while the circuit breaker is closed.
+	BreakerClearInterval time.Duration
+	// BreakerOpenTime is how long the circuit breaker stays open before it lets requests through again.
+	BreakerOpenTime time.Duration
+
+	// RetryCount is how often failed GET requests are repeated. 0 disables retries.
+	RetryCount int
+	// RetryBackoff is the maximum wait before the first retry, doubled for every further retry.
+	RetryBackoff time.Duration
+
+	// DegradedStartup allows the application to start before discovery has succeeded.
+	DegradedStartup bool
+
+	// RediscoveryInterval is how often discovery is repeated. 0 disables rediscovery.
+	RediscoveryInterval time.Duration
 }
 
 // --- instance creation ---
 
 func New(options Options) IdentityProviderClient {
+	if options.Name == "" {
+		options.Name = DefaultName
+	}
+	options.applyBreakerDefaults()
 	instance := Impl{
-		options: options,
+		options:          options,
+		serviceTokenLock: make(chan struct{}, 1),
 	}
 
 	httpClient, err := auresthttpclient.New(0, nil, instance.requestManipulator)
@@ -43,44 +78,79 @@ func New(options Options) IdentityProviderClient {
 	}
 	aurestclientprometheus.InstrumentHttpClient(httpClient)
 
-	requestLoggingClient := aurestlogging.New(httpClient)
+	requestLoggingClient := aurestlogging.New(tracing.NewClient(httpClient, "idp"))
 
-	circuitBreakerClient := aurestbreaker.New(requestLoggingClient,
-		"identity-provider-breaker",
-		10,
-		2*time.Minute,
-		30*time.Second,
-		options.RequestTimeout,
-	)
-	aurestbreakerprometheus.InstrumentCircuitBreakerClient(circuitBreakerClient)
+	circuitBreakerClient := newBreakerClient(requestLoggingClient, options)
 
-	client := circuitBreakerClient
+	instance.client = newRetryClient(newMetricsClient(circuitBreakerClient, options.Name), options)
+	instance.userinfoCache = newTokenCache[UserinfoResponse](options, "userinfo")
+	instance.introspectionCache = newTokenCache[TokenIntrospectionResponse](options, "introspection")
 
-	if options.CacheEnabled {
-		cachingClient := aurestcaching.New(circuitBreakerClient,
-			instance.useCacheCondition,
-			storeResponseCondition,
-			cacheKeyFunction,
-			options.CacheRetentionTime,
-			256,
-		)
-		aurestclientprometheus.InstrumentCacheClient(cachingClient)
-		client = cachingClient
-	}
+	return &instance
+}
 
-	instance.client = client
+// applyBreakerDefaults fills in the circuit breaker settings that were previously fixed, for options not from configuration.
+func (o *Options) applyBreakerDefaults() {
+	if o.BreakerFailureThreshold == 0 {
+		o.BreakerFailureThreshold = 6
+	}
+	if o.BreakerHalfOpenRequests == 0 {
+		o.BreakerHalfOpenRequests = 10
+	}
+	if o.BreakerClearInterval == 0 {
+		o.BreakerClearInterval = 2 * time.Minute
+	}
+	if o.BreakerOpenTime == 0 {
+		o.BreakerOpenTime = 30 * time.Second
+	}
+}
 
-	return &instance
+// breakerName keeps the original breaker name for the default identity provider, so existing dashboards keep working.
+func breakerName(name string) string {
+	if name == DefaultName {
+		return "identity-provider-breaker"
+	}
+	return "identity-provider-" + name + "-breaker"
 }
 
 func OptionsFromConfig() Options {
 	return Options{
-		RequestTimeout:        aToSeconds(auconfigenv.Get(ConfIDPRequestTimeoutSeconds)),
-		CacheEnabled:          auconfigenv.Get(ConfIDPCacheEnabled) == "1",
-		CacheRetentionTime:    aToSeconds(auconfigenv.Get(ConfIDPCacheRetentionSeconds)),
-		OIDCWellKnownURL:      auconfigenv.Get(ConfOIDCWellKnownURL),
-		TokenIntrospectionURL: auconfigenv.Get(ConfTokenIntrospectionURL),
+		Name:                    DefaultName,
+		RequestTimeout:          aToSeconds(auconfigenv.Get(ConfIDPRequestTimeoutSeconds)),
+		CacheEnabled:            auconfigenv.Get(ConfIDPCacheEnabled) == "1",
+		CacheRetentionTime:      aToSeconds(auconfigenv.Get(ConfIDPCacheRetentionSeconds)),
+		CacheGraceTime:          aToSecondsOrZero(auconfigenv.Get(ConfIDPCacheGraceSeconds)),
+		OIDCWellKnownURL:        auconfigenv.Get(ConfOIDCWellKnownURL),
+		TokenIntrospectionURL:   auconfigenv.Get(ConfTokenIntrospectionURL),
+		ClientID:                auconfigenv.Get(ConfIDPClientID),
+		ClientSecret:            auconfigenv.Get(ConfIDPClientSecret),
+		ClientAuthMethod:        auconfigenv.Get(ConfIDPClientAuthMethod),
+		ClientScopes:            auconfigenv.Get(ConfIDPClientScopes),
+		BreakerFailureThreshold: aToUint32(auconfigenv.Get(ConfIDPBreakerFailureThreshold)),
+		BreakerHalfOpenRequests: aToUint32(auconfigenv.Get(ConfIDPBreakerHalfOpenRequests)),
+		BreakerClearInterval:    aToSeconds(auconfigenv.Get(ConfIDPBreakerClearSeconds)),
+		BreakerOpenTime:         aToSeconds(auconfigenv.Get(ConfIDPBreakerOpenSeconds)),
+		RetryCount:              aToIntOrZero(auconfigenv.Get(ConfIDPRetryCount)),
+		RetryBackoff:            time.Duration(aToIntOrZero(auconfigenv.Get(ConfIDPRetryBackoffMillis))) * time.Millisecond,
+		DegradedStartup:         auconfigenv.Get(ConfIDPDegradedStartup) == "1",
+		RediscoveryInterval:     aToSecondsOrZero(auconfigenv.Get(ConfIDPRediscoverySeconds)),
+	}
+}
+
+// AdditionalOptionsFromConfig creates the options for all additional identity providers, in configured order.
+//
+// Apart from the name and the endpoints, they share the settings of the default identity provider.
+func AdditionalOptionsFromConfig() []Options {
+	issuers, _ := parseAdditionalIssuers(auconfigenv.Get(ConfOIDCAdditionalIssuers))
+	result := make([]Options, 0, len(issuers))
+	for _, issuer := range issuers {
+		options := OptionsFromConfig()
+		options.Name = issuer.Name
+		options.OIDCWellKnownURL = issuer.WellKnownURL
+		options.TokenIntrospectionURL = issuer.TokenIntrospectionURL
+		result = append(result, options)
 	}
+	return result
 }
 
 func aToSeconds(s string) time.Duration {
@@ -92,42 +162,85 @@ func aToSeconds(s string) time.Duration {
 	return time.Duration(secs) * time.Second
 }
 
+func aToSecondsOrZero(s string) time.Duration {
+	secs, err := auconfigenv.AToInt(s)
+	if err != nil {
+		// config was validated so should only happen in tests, 0 disables the feature
+		secs = 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func aToUint32(s string) uint32 {
+	// config was validated, 0 means the default is used
+	return uint32(aToIntOrZero(s))
+}
+
+func aToIntOrZero(s string) int {
+	value, err := auconfigenv.AToInt(s)
+	if err != nil || value < 0 {
+		// config was validated so should only happen in tests
+		return 0
+	}
+	return value
+}
+
 type Impl struct {
 	client  aurestclientapi.Client
 	options Options
 
-	oidcUserInfoURL string
-	issuer          string
-}
+	discovered atomic.Pointer[discoveredEndpoints]
 
-// useCacheCondition determines whether the cache should be used for a given request
-//
-// we cache only GET requests to the configured userinfo endpoint, and only for users who present a valid auth token
-func (i *Impl) useCacheCondition(ctx context.Context, method string, url string, requestBody interface{}) bool {
-	return method == http.MethodGet && url == i.oidcUserInfoURL && common.GetAccessToken(ctx) != ""
+	keys         map[string]any
+	keysLoaded   time.Time
+	keysMutex    sync.RWMutex
+	refreshMutex sync.Mutex
+	// keysRequested is when an unknown key id last triggered a reload, successful or not. Guarded by refreshMutex.
+	keysRequested time.Time
+
+	serviceToken        string
+	serviceTokenExpires time.Time
+	// serviceTokenLock holds a value while a token request is in flight. Unlike a mutex, waiting for it can be cancelled.
+	serviceTokenLock chan struct{}
+
+	userinfoCache      *tokenCache[UserinfoResponse]
+	introspectionCache *tokenCache[TokenIntrospectionResponse]
 }
 
-// storeResponseCondition determines whether to store a response in the cache
+// discoveredEndpoints are obtained from the .well-known endpoint.
 //
-// we only cache responses of successful requests to the userinfo endpoint
-func storeResponseCondition(ctx context.Context, method string, url string, requestBody interface{}, response *aurestclientapi.ParsedResponse) bool {
-	return response.Status == http.StatusOK
+// They are replaced as a whole on every successful discovery, so requests never see a mix of old and new values.
+type discoveredEndpoints struct {
+	issuer                string
+	userinfoURL           string
+	tokenIntrospectionURL string
+	tokenURL              string
+	jwksURI               string
 }
 
-// cacheKeyFunction determines the key to cache the response under
-//
-// we cannot use the default cache key function, we must cache per token
-func cacheKeyFunction(ctx context.Context, method string, requestUrl string, requestBody interface{}) string {
-	return fmt.Sprintf("%s %s %s", common.GetAccessToken(ctx), method, requestUrl)
+// endpoints returns the result of the last successful discovery, with all values empty if there was none yet.
+func (i *Impl) endpoints() *discoveredEndpoints {
+	if endpoints := i.discovered.Load(); endpoints != nil {
+		return endpoints
+	}
+	return &discoveredEndpoints{}
 }
 
-// requestManipulator inserts Authorization when we are calling the userinfo endpoint
+// requestManipulator inserts Authorization when we are calling the userinfo, token introspection, or token endpoint
 func (i *Impl) requestManipulator(ctx context.Context, r *http.Request) {
-	if r.Method == http.MethodGet {
-		urlStr := r.URL.String()
-		if urlStr != "" && (urlStr == i.oidcUserInfoURL) {
-			r.Header.Set(headers.Authorization, "Bearer "+common.GetAccessToken(ctx))
-		}
+	tracing.InjectHeaders(ctx, r)
+
+	urlStr := r.URL.String()
+	if urlStr == "" {
+		return
+	}
+	endpoints := i.endpoints()
+	if r.Method == http.MethodGet && urlStr == endpoints.userinfoURL {
+		r.Header.Set(headers.Authorization, "Bearer "+common.GetAccessToken(ctx))
+	}
+	if r.Method == http.MethodPost && (urlStr == endpoints.tokenIntrospectionURL || urlStr == endpoints.tokenURL) && i.options.ClientAuthMethod != ClientAuthMethodPost {
+		// RFC 6749 section 2.3.1: client id and secret are form encoded before basic auth encoding
+		r.SetBasicAuth(url.QueryEscape(i.options.ClientID), url.QueryEscape(i.options.ClientSecret))
 	}
 }
 
@@ -149,18 +262,44 @@ func (i *Impl) SetupFromWellKnown(ctx context.Context) error {
 		return errors.New("failed to obtain issuer or user info endpoint from .well-known endpoint")
 	}
 
-	i.issuer = bodyDto.Issuer
-	i.oidcUserInfoURL = bodyDto.UserinfoEndpoint
+	endpoints := discoveredEndpoints{
+		issuer:                bodyDto.Issuer,
+		userinfoURL:           bodyDto.UserinfoEndpoint,
+		tokenIntrospectionURL: i.options.TokenIntrospectionURL,
+		tokenURL:              bodyDto.TokenEndpoint,
+		jwksURI:               bodyDto.JwksURI,
+	}
+	if endpoints.tokenIntrospectionURL == "" {
+		endpoints.tokenIntrospectionURL = bodyDto.IntrospectionEndpoint
+	}
+	i.discovered.Store(&endpoints)
+
+	if endpoints.jwksURI != "" {
+		if err := i.refreshKeySet(ctx); err != nil {
+			// not fatal, we will try again when the first token needs to be validated locally
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to load key set from identity provider, will retry on demand: %s", err.Error())
+		}
+	}
 
 	return nil
 }
 
+func (i *Impl) Name() string {
+	return i.options.Name
+}
+
 func (i *Impl) Issuer() string {
-	return i.issuer
+	return i.endpoints().issuer
 }
 
 func (i *Impl) UserInfo(ctx context.Context) (*UserinfoResponse, int, error) {
-	userinfoEndpoint := i.oidcUserInfoURL
+	return i.userinfoCache.cached(ctx, func() (*UserinfoResponse, int, error) {
+		return i.userInfo(ctx)
+	})
+}
+
+func (i *Impl) userInfo(ctx context.Context) (*UserinfoResponse, int, error) {
+	userinfoEndpoint := i.endpoints().userinfoURL
 	bodyDto := UserinfoResponse{}
 	response := aurestclientapi.ParsedResponse{
 		Body: &bodyDto,
@@ -194,31 +333,65 @@ func (i *Impl) UserInfo(ctx context.Context) (*UserinfoResponse, int, error) {
 }
 
 func (i *Impl) TokenIntrospection(ctx context.Context) (*TokenIntrospectionResponse, int, error) {
-	tokenIntrospectionEndpoint := i.options.TokenIntrospectionURL
+	return i.introspectionCache.cached(ctx, func() (*TokenIntrospectionResponse, int, error) {
+		return i.tokenIntrospection(ctx)
+	})
+}
+
+func (i *Impl) EvictCachedToken(token string) {
+	i.userinfoCache.evict(token)
+	i.introspectionCache.evict(token)
+}
+
+func (i *Impl) tokenIntrospection(ctx context.Context) (*TokenIntrospectionResponse, int, error) {
+	tokenIntrospectionEndpoint := i.endpoints().tokenIntrospectionURL
+	if tokenIntrospectionEndpoint == "" {
+		return nil, http.StatusBadGateway, errors.New("no token introspection endpoint configured or discovered")
+	}
+
+	requestBody := url.Values{}
+	requestBody.Set("token", common.GetAccessToken(ctx))
+	requestBody.Set("token_type_hint", "access_token")
+	if i.options.ClientAuthMethod == ClientAuthMethodPost {
+		requestBody.Set("client_id", i.options.ClientID)
+		requestBody.Set("client_secret", i.options.ClientSecret)
+	}
+
 	bodyDto := TokenIntrospectionResponse{}
 	response := aurestclientapi.ParsedResponse{
 		Body: &bodyDto,
 	}
-	err := i.client.Perform(ctx, http.MethodGet, tokenIntrospectionEndpoint, nil, &response)
+	err := i.client.Perform(ctx, http.MethodPost, tokenIntrospectionEndpoint, requestBody, &response)
 
 	if err != nil {
-		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("error requesting user info from identity provider: error from response is %s:%v, local error is %s", bodyDto.ErrorMessage, bodyDto.Errors, err.Error())
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("error requesting token introspection from identity provider: error from response is %s, local error is %s", bodyDto.errorDetails(), err.Error())
 		return nil, http.StatusBadGateway, err
 	}
-	if bodyDto.ErrorMessage != "" || len(bodyDto.Errors) > 0 {
-		aulogging.Logger.Ctx(ctx).Error().Printf("received an error response from identity provider: error from response is %s:%v", bodyDto.ErrorMessage, bodyDto.Errors)
+	if bodyDto.hasError() {
+		aulogging.Logger.Ctx(ctx).Error().Printf("received an error response from identity provider: error from response is %s", bodyDto.errorDetails())
 	}
 	if response.Status != http.StatusOK && response.Status != http.StatusUnauthorized && response.Status != http.StatusForbidden {
 		err = fmt.Errorf("unexpected http status %d, was expecting 200, 401, or 403", response.Status)
-		aulogging.Logger.Ctx(ctx).Error().Printf("error requesting user info from identity provider: error from response is %s:%v, local error is %s", bodyDto.ErrorMessage, bodyDto.Errors, err.Error())
+		aulogging.Logger.Ctx(ctx).Error().Printf("error requesting token introspection from identity provider: error from response is %s, local error is %s", bodyDto.errorDetails(), err.Error())
 		return nil, response.Status, err
 	}
 	if response.Status == http.StatusOK {
-		if bodyDto.ErrorMessage != "" || len(bodyDto.Errors) > 0 {
-			err = fmt.Errorf("received an error response from identity provider: error from response is %s:%v", bodyDto.ErrorMessage, bodyDto.Errors)
+		if bodyDto.hasError() {
+			err = fmt.Errorf("received an error response from identity provider: error from response is %s", bodyDto.errorDetails())
 			return nil, response.Status, err
 		}
 	}
 
 	return &bodyDto, response.Status, nil
 }
+
+func (r *TokenIntrospectionResponse) hasError() bool {
+	return r.ErrorCode != "" || r.ErrorDescription != "" || r.ErrorMessage != "" || len(r.Errors) > 0
+}
+
+func (r *TokenIntrospectionResponse) errorDetails() string {
+	if r.ErrorCode != "" || r.ErrorDescription != "" {
+		return fmt.Sprintf("%s:%s", r.ErrorCode, r.ErrorDescription)
+	}
+	return fmt.Sprintf("%s:%v", r.ErrorMessage, r.Errors)
+}
@@ -0,0 +1,215 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/stretchr/testify/require"
+)
+
+// introspectionIDP serves a .well-known endpoint and an RFC 7662 introspection endpoint.
+//
+// Only the token "active-token" is active, and only if the client authenticated as "my-client" with secret "my-secret".
+func introspectionIDP(t *testing.T, discover bool, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	var idpServer *httptest.Server
+	idpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			wellKnown := WellKnownResponse{
+				Issuer:           idpServer.URL,
+				UserinfoEndpoint: idpServer.URL + "/userinfo",
+			}
+			if discover {
+				wellKnown.IntrospectionEndpoint = idpServer.URL + "/introspect"
+			}
+			_ = json.NewEncoder(w).Encode(wellKnown)
+		case "/introspect":
+			calls.Add(1)
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			clientID, clientSecret, ok := r.BasicAuth()
+			if !ok {
+				clientID = r.PostFormValue("client_id")
+				clientSecret = r.PostFormValue("client_secret")
+			}
+			if clientID != "my-client" || clientSecret != "my-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_ = json.NewEncoder(w).Encode(TokenIntrospectionResponse{ErrorCode: "invalid_client"})
+				return
+			}
+			if r.PostFormValue("token") != "active-token" {
+				_ = json.NewEncoder(w).Encode(TokenIntrospectionResponse{Active: false})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(TokenIntrospectionResponse{Active: true, Scope: "openid example", Sub: "1234"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return idpServer
+}
+
+func withToken(token string) context.Context {
+	return context.WithValue(context.Background(), common.CtxKeyAccessToken{}, token)
+}
+
+func TestTokenIntrospection(t *testing.T) {
+	testcases := []struct {
+		name         string
+		discover     bool
+		configureURL bool
+		authMethod   string
+		clientSecret string
+		token        string
+		expectStatus int
+		expectActive bool
+		expectErr    string
+	}{
+		{
+			name:         "basic_auth_active",
+			discover:     true,
+			authMethod:   ClientAuthMethodBasic,
+			clientSecret: "my-secret",
+			token:        "active-token",
+			expectStatus: http.StatusOK,
+			expectActive: true,
+		},
+		{
+			name:         "post_auth_active",
+			discover:     true,
+			authMethod:   ClientAuthMethodPost,
+			clientSecret: "my-secret",
+			token:        "active-token",
+			expectStatus: http.StatusOK,
+			expectActive: true,
+		},
+		{
+			name:         "configured_url_active",
+			configureURL: true,
+			authMethod:   ClientAuthMethodBasic,
+			clientSecret: "my-secret",
+			token:        "active-token",
+			expectStatus: http.StatusOK,
+			expectActive: true,
+		},
+		{
+			name:         "inactive",
+			discover:     true,
+			authMethod:   ClientAuthMethodBasic,
+			clientSecret: "my-secret",
+			token:        "revoked-token",
+			expectStatus: http.StatusOK,
+			expectActive: false,
+		},
+		{
+			name:         "wrong_client_secret",
+			discover:     true,
+			authMethod:   ClientAuthMethodPost,
+			clientSecret: "wrong-secret",
+			token:        "active-token",
+			expectStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "no_endpoint",
+			authMethod:   ClientAuthMethodBasic,
+			clientSecret: "my-secret",
+			token:        "active-token",
+			expectStatus: http.StatusBadGateway,
+			expectErr:    "no token introspection endpoint configured or discovered",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			idpServer := introspectionIDP(t, tc.discover, &calls)
+			defer idpServer.Close()
+
+			options := Options{
+				RequestTimeout:   5 * time.Second,
+				OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+				ClientID:         "my-client",
+				ClientSecret:     tc.clientSecret,
+				ClientAuthMethod: tc.authMethod,
+			}
+			if tc.configureURL {
+				options.TokenIntrospectionURL = idpServer.URL + "/introspect"
+			}
+			cut := New(options)
+			require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+
+			result, status, err := cut.TokenIntrospection(withToken(tc.token))
+			require.Equal(t, tc.expectStatus, status)
+			if tc.expectErr != "" {
+				require.EqualError(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectActive, result.Active)
+		})
+	}
+}
+
+func TestTokenIntrospection_CachedPerToken(t *testing.T) {
+	var calls atomic.Int32
+	idpServer := introspectionIDP(t, true, &calls)
+	defer idpServer.Close()
+
+	cut := New(Options{
+		RequestTimeout:     5 * time.Second,
+		CacheEnabled:       true,
+		CacheRetentionTime: 30 * time.Second,
+		OIDCWellKnownURL:   idpServer.URL + "/.well-known/openid-configuration",
+		ClientID:           "my-client",
+		ClientSecret:       "my-secret",
+		ClientAuthMethod:   ClientAuthMethodBasic,
+	})
+	require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+
+	for range 3 {
+		result, _, err := cut.TokenIntrospection(withToken("active-token"))
+		require.NoError(t, err)
+		require.True(t, result.Active)
+	}
+	require.Equal(t, int32(1), calls.Load(), "repeated introspection of the same token must come from cache")
+
+	result, _, err := cut.TokenIntrospection(withToken("other-token"))
+	require.NoError(t, err)
+	require.False(t, result.Active)
+	require.Equal(t, int32(2), calls.Load(), "other tokens must not share a cache entry")
+}
+
+func TestParseAdditionalIssuers(t *testing.T) {
+	issuers, err := parseAdditionalIssuers(`[{"name": "new-idp", "well_known_url": "https://new.example.com/.well-known/openid-configuration"}]`)
+	require.NoError(t, err)
+	require.Equal(t, []AdditionalIssuer{{Name: "new-idp", WellKnownURL: "https://new.example.com/.well-known/openid-configuration"}}, issuers)
+
+	testcases := []struct {
+		name      string
+		value     string
+		expectErr string
+	}{
+		{name: "not_json", value: `new-idp`, expectErr: "failed to parse additional issuer configuration: invalid character 'e' in literal null (expecting 'u')"},
+		{name: "unknown_field", value: `[{"name": "a", "url": "https://a"}]`, expectErr: `failed to parse additional issuer configuration: json: unknown field "url"`},
+		{name: "invalid_name", value: `[{"name": "New IDP", "well_known_url": "https://a"}]`, expectErr: "invalid additional issuer name 'New IDP'"},
+		{name: "default_name", value: `[{"name": "default", "well_known_url": "https://a"}]`, expectErr: "duplicate additional issuer name 'default'"},
+		{name: "missing_url", value: `[{"name": "a"}]`, expectErr: "invalid well known url for additional issuer a"},
+		{name: "invalid_introspection_url", value: `[{"name": "a", "well_known_url": "https://a", "token_introspection_url": "a"}]`, expectErr: "invalid token introspection url for additional issuer a"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseAdditionalIssuers(tc.value)
+			require.EqualError(t, err, tc.expectErr)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package idp
+
+import (
+	"context"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"time"
+)
+
+// DiscoveryInitialBackoff is the wait before the first retry of a failed discovery. It doubles with every
+// further failure, up to DiscoveryMaxBackoff.
+var DiscoveryInitialBackoff = time.Second
+
+var DiscoveryMaxBackoff = 5 * time.Minute
+
+// DiscoverInBackground keeps the .well-known information of an identity provider current.
+//
+// If the identity provider has not been discovered yet, discovery starts immediately, otherwise after
+// refreshInterval. Failed discoveries are retried with exponential backoff, keeping the previous information
+// in the meantime. After a success, discovery is repeated every refreshInterval, or never if it is 0.
+//
+// Runs until ctx is cancelled. The returned channel is closed once it has stopped.
+func DiscoverInBackground(ctx context.Context, client IdentityProviderClient, refreshInterval time.Duration) <-chan struct{} {
+	initialBackoff := DiscoveryInitialBackoff
+	maxBackoff := DiscoveryMaxBackoff
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		backoff := initialBackoff
+		wait := time.Duration(0)
+		if client.Issuer() != "" {
+			if refreshInterval <= 0 {
+				return
+			}
+			wait = refreshInterval
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			if err := client.SetupFromWellKnown(ctx); err != nil {
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("discovery of identity provider %s failed, retrying in %s: %s", client.Name(), backoff, err.Error())
+				wait = backoff
+				backoff = min(2*backoff, maxBackoff)
+				continue
+			}
+
+			aulogging.Logger.Ctx(ctx).Info().Printf("discovery of identity provider %s successful, issuer is %s", client.Name(), client.Issuer())
+			backoff = initialBackoff
+			if refreshInterval <= 0 {
+				return
+			}
+			wait = refreshInterval
+		}
+	}()
+	return done
+}
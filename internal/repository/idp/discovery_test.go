@@ -0,0 +1,66 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverInBackground_RetriesAndRefreshes(t *testing.T) {
+	var calls atomic.Int32
+	var issuer atomic.Value
+	issuer.Store("first-issuer")
+
+	idpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// unreachable for the first two attempts
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(WellKnownResponse{
+			Issuer:           issuer.Load().(string),
+			UserinfoEndpoint: "http://localhost/userinfo",
+		})
+	}))
+	defer idpServer.Close()
+
+	originalBackoff := DiscoveryInitialBackoff
+	DiscoveryInitialBackoff = 10 * time.Millisecond
+	defer func() {
+		DiscoveryInitialBackoff = originalBackoff
+	}()
+
+	cut := New(Options{
+		RequestTimeout:   5 * time.Second,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+	})
+	require.Equal(t, "", cut.Issuer())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := DiscoverInBackground(ctx, cut, 50*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return cut.Issuer() == "first-issuer"
+	}, 5*time.Second, 5*time.Millisecond)
+	require.GreaterOrEqual(t, calls.Load(), int32(3))
+
+	issuer.Store("second-issuer")
+	require.Eventually(t, func() bool {
+		return cut.Issuer() == "second-issuer"
+	}, 5*time.Second, 5*time.Millisecond, "rediscovery must pick up changes")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "discovery did not stop when cancelled")
+	}
+}
@@ -2,29 +2,66 @@ package idp
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"regexp"
+	"strings"
 )
 
 type IdentityProviderClient interface {
 	// SetupFromWellKnown must be called at least once before any other methods can be used.
 	SetupFromWellKnown(ctx context.Context) error
 
+	// Name identifies the identity provider in logs, metrics, and configuration.
+	Name() string
+
 	Issuer() string
 
+	// SigningKey obtains the public key with the given key id from the key set of the identity provider.
+	//
+	// Keys are cached. An unknown key id triggers a reload of the key set, because the identity
+	// provider may have rotated its keys, but at most once per MinimumKeySetRefreshInterval.
+	SigningKey(ctx context.Context, kid string) (any, error)
+
 	// UserInfo extracts the token from the context and performs a user info lookup
 	UserInfo(ctx context.Context) (*UserinfoResponse, int, error)
 
-	// TokenIntrospection extracts the token from the context and performs a token info lookup
+	// TokenIntrospection extracts the token from the context and performs a token info lookup (RFC 7662).
+	//
+	// Inactive tokens are not an error, check the Active field of the response.
 	TokenIntrospection(ctx context.Context) (*TokenIntrospectionResponse, int, error)
+
+	// ServiceToken obtains an access token for this service itself, using the client credentials grant.
+	//
+	// Tokens are cached until shortly before they expire. Use ServiceTokenSource to send them to other services.
+	ServiceToken(ctx context.Context) (string, error)
+
+	// EvictCachedToken removes all cached responses for the given access token, for example after logout.
+	EvictCachedToken(token string)
 }
 
 const (
-	ConfOIDCWellKnownURL         = "OIDC_WELL_KNOWN_URL"
-	ConfTokenIntrospectionURL    = "IDP_TOKEN_INTROSPECTION_URL"
-	ConfIDPRequestTimeoutSeconds = "IDP_REQUEST_TIMEOUT_SECONDS"
-	ConfIDPCacheEnabled          = "IDP_CACHE_ENABLED"
-	ConfIDPCacheRetentionSeconds = "IDP_CACHE_RETENTION_SECONDS"
+	ConfOIDCWellKnownURL           = "OIDC_WELL_KNOWN_URL"
+	ConfOIDCAdditionalIssuers      = "OIDC_ADDITIONAL_ISSUERS"
+	ConfTokenIntrospectionURL      = "IDP_TOKEN_INTROSPECTION_URL"
+	ConfIDPClientID                = "IDP_CLIENT_ID"
+	ConfIDPClientSecret            = "IDP_CLIENT_SECRET"
+	ConfIDPClientAuthMethod        = "IDP_CLIENT_AUTH_METHOD"
+	ConfIDPClientScopes            = "IDP_CLIENT_SCOPES"
+	ConfIDPRequestTimeoutSeconds   = "IDP_REQUEST_TIMEOUT_SECONDS"
+	ConfIDPCacheEnabled            = "IDP_CACHE_ENABLED"
+	ConfIDPCacheRetentionSeconds   = "IDP_CACHE_RETENTION_SECONDS"
+	ConfIDPCacheGraceSeconds       = "IDP_CACHE_GRACE_SECONDS"
+	ConfIDPBreakerFailureThreshold = "IDP_BREAKER_FAILURE_THRESHOLD"
+	ConfIDPBreakerHalfOpenRequests = "IDP_BREAKER_HALF_OPEN_REQUESTS"
+	ConfIDPBreakerClearSeconds     = "IDP_BREAKER_CLEAR_INTERVAL_SECONDS"
+	ConfIDPBreakerOpenSeconds      = "IDP_BREAKER_OPEN_SECONDS"
+	ConfIDPRetryCount              = "IDP_RETRY_COUNT"
+	ConfIDPRetryBackoffMillis      = "IDP_RETRY_BACKOFF_MILLISECONDS"
+	ConfIDPDegradedStartup         = "IDP_DEGRADED_STARTUP"
+	ConfIDPRediscoverySeconds      = "IDP_REDISCOVERY_INTERVAL_SECONDS"
 )
 
 func ConfigItems() []auconfigapi.ConfigItem {
@@ -35,11 +72,37 @@ func ConfigItems() []auconfigapi.ConfigItem {
 			Description: "URL of the OpenID Connect .well-known endpoint. If set, allows Identity Provider integration with autoconfiguration.",
 			Validate:    auconfigenv.ObtainPatternValidator("^(|https?://.*)$"),
 		},
+		{
+			Key:         ConfOIDCAdditionalIssuers,
+			Default:     "[]",
+			Description: "additional identity providers whose tokens are accepted, for example during a migration. JSON list of {\"name\": ..., \"well_known_url\": ..., \"token_introspection_url\": ...}, the introspection url is optional. Names are used in logs and metrics, and must consist of lowercase letters, digits, and dashes. Tokens are routed to the identity provider matching their issuer, opaque tokens are tried in order, starting with the one from " + ConfOIDCWellKnownURL + ".",
+			Validate:    validateAdditionalIssuers,
+		},
 		{
 			Key:         ConfTokenIntrospectionURL,
 			Default:     "",
-			Description: "URL of the token introspection endpoint. If set, allows Identity Provider to validate scopes.",
+			Description: "URL of the token introspection endpoint. If set, allows Identity Provider to validate scopes. If empty, the introspection_endpoint from the .well-known endpoint is used, if present.",
 			Validate:    auconfigenv.ObtainPatternValidator("^(|https?://.*)$"),
+		}, {
+			Key:         ConfIDPClientID,
+			Default:     "",
+			Description: "client id this service uses to authenticate with the identity provider, for example for token introspection.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfIDPClientSecret,
+			Default:     "",
+			Description: "client secret this service uses to authenticate with the identity provider. Should be loaded from Vault.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfIDPClientAuthMethod,
+			Default:     ClientAuthMethodBasic,
+			Description: "how to present the client credentials to the identity provider, either '" + ClientAuthMethodBasic + "' (http basic auth) or '" + ClientAuthMethodPost + "' (form parameters).",
+			Validate:    auconfigenv.ObtainPatternValidator("^(" + ClientAuthMethodBasic + "|" + ClientAuthMethodPost + ")$"),
+		}, {
+			Key:         ConfIDPClientScopes,
+			Default:     "",
+			Description: "scopes to request when obtaining service tokens for calls to other services. Accepts a space separated list. If empty, the identity provider decides.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
 		}, {
 			Key:         ConfIDPRequestTimeoutSeconds,
 			Default:     "10",
@@ -55,10 +118,106 @@ func ConfigItems() []auconfigapi.ConfigItem {
 			Default:     "5",
 			Description: "cache IDP responses for this many seconds. Please be aware of the security implications, namely that revoked tokens will still be accepted for this many seconds. Tradeoff with performance. Limited to 30 seconds, defaults to 5. Note that the cache is off by default, so this setting only has an effect if IDP_CACHE_ENABLED is set to 1.",
 			Validate:    auconfigenv.ObtainUintRangeValidator(1, 30),
+		}, {
+			Key:         ConfIDPCacheGraceSeconds,
+			Default:     "0",
+			Description: "while the identity provider fails with a 5xx status or cannot be reached, keep serving cached responses for up to this many seconds after they have expired, so an outage does not log out every user at once. Tokens revoked during the outage will still be accepted. Defaults to 0, which disables this. Only has an effect if IDP_CACHE_ENABLED is set to 1.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 3600),
+		}, {
+			Key:         ConfIDPBreakerFailureThreshold,
+			Default:     "6",
+			Description: "open the circuit breaker for the identity provider after this many consecutive failed requests. While open, requests fail immediately without contacting the identity provider.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 1000),
+		}, {
+			Key:         ConfIDPBreakerHalfOpenRequests,
+			Default:     "10",
+			Description: "number of requests let through after the circuit breaker has been open. If they all succeed, the circuit breaker closes again, any failure opens it again.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 1000),
+		}, {
+			Key:         ConfIDPBreakerClearSeconds,
+			Default:     "120",
+			Description: "reset the failure counts of the closed circuit breaker every this many seconds.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 86400),
+		}, {
+			Key:         ConfIDPBreakerOpenSeconds,
+			Default:     "30",
+			Description: "keep the circuit breaker open for this many seconds before letting requests through again.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 3600),
+		}, {
+			Key:         ConfIDPRetryCount,
+			Default:     "2",
+			Description: "repeat GET requests to the identity provider up to this many times if they time out or fail with 502, 503, or 504. Other requests are never repeated. Set to 0 to disable.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 5),
+		}, {
+			Key:         ConfIDPRetryBackoffMillis,
+			Default:     "200",
+			Description: "wait a random time of up to this many milliseconds before the first repeated request, doubled for every further repetition.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(1, 10000),
+		}, {
+			Key:         ConfIDPDegradedStartup,
+			Default:     "0",
+			Description: "start even if the identity provider cannot be reached. Off by default, which makes startup fail. Enable by setting this to '1'. Discovery is then retried in the background, and until it succeeds, all requests that need authentication are answered with 503.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 1),
+		}, {
+			Key:         ConfIDPRediscoverySeconds,
+			Default:     "3600",
+			Description: "repeat discovery of the identity provider every this many seconds, so changes to its endpoints and keys are picked up without a restart. Set to 0 to disable.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 86400),
 		},
 	}
 }
 
+// DefaultName is the name of the identity provider configured by OIDC_WELL_KNOWN_URL.
+const DefaultName = "default"
+
+type AdditionalIssuer struct {
+	Name                  string `json:"name"`
+	WellKnownURL          string `json:"well_known_url"`
+	TokenIntrospectionURL string `json:"token_introspection_url"`
+}
+
+var (
+	issuerNamePattern = regexp.MustCompile("^[a-z0-9-]+$")
+	issuerURLPattern  = regexp.MustCompile("^https?://.*$")
+)
+
+func parseAdditionalIssuers(value string) ([]AdditionalIssuer, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	result := make([]AdditionalIssuer, 0)
+	if err := decoder.Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse additional issuer configuration: %w", err)
+	}
+	seen := map[string]bool{DefaultName: true}
+	for _, issuer := range result {
+		if !issuerNamePattern.MatchString(issuer.Name) {
+			return nil, fmt.Errorf("invalid additional issuer name '%s'", issuer.Name)
+		}
+		if seen[issuer.Name] {
+			return nil, fmt.Errorf("duplicate additional issuer name '%s'", issuer.Name)
+		}
+		seen[issuer.Name] = true
+		if !issuerURLPattern.MatchString(issuer.WellKnownURL) {
+			return nil, fmt.Errorf("invalid well known url for additional issuer %s", issuer.Name)
+		}
+		if issuer.TokenIntrospectionURL != "" && !issuerURLPattern.MatchString(issuer.TokenIntrospectionURL) {
+			return nil, fmt.Errorf("invalid token introspection url for additional issuer %s", issuer.Name)
+		}
+	}
+	return result, nil
+}
+
+func validateAdditionalIssuers(key string) error {
+	_, err := parseAdditionalIssuers(auconfigenv.Get(key))
+	return err
+}
+
+// client authentication methods as registered for OAuth 2.0 (RFC 7591)
+const (
+	ClientAuthMethodBasic = "client_secret_basic"
+	ClientAuthMethodPost  = "client_secret_post"
+)
+
 type UserinfoResponse struct {
 	// can leave out fields - we are using a tolerant reader
 	Audience      []string `json:"aud"`
@@ -91,11 +250,27 @@ type TokenIntrospectionResponse struct {
 	TokenUse  string   `json:"token_use"`
 
 	// in case of error, you get these fields instead
-	ErrorMessage string              `json:"message"`
-	Errors       map[string][]string `json:"errors"`
+	ErrorCode        string              `json:"error"`
+	ErrorDescription string              `json:"error_description"`
+	ErrorMessage     string              `json:"message"`
+	Errors           map[string][]string `json:"errors"`
 }
 
 type WellKnownResponse struct {
 	Issuer           string `json:"issuer"`
 	UserinfoEndpoint string `json:"userinfo_endpoint"`
+	JwksURI          string `json:"jwks_uri"`
+
+	IntrospectionEndpoint string `json:"introspection_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+
+	// in case of error, you get these fields instead
+	ErrorCode        string `json:"error"`
+	ErrorDescription string `json:"error_description"`
 }
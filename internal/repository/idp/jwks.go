@@ -0,0 +1,197 @@
+package idp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// MinimumKeySetRefreshInterval limits how often an unknown key id can trigger a reload of the key set.
+//
+// Without this limit, anyone could make us hammer the identity provider by sending tokens with random key ids.
+var MinimumKeySetRefreshInterval = 30 * time.Second
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JSONWebKey is a public key as served by the jwks_uri of the identity provider (RFC 7517).
+//
+// Only the fields required for RSA and EC signature keys are read.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// PublicKey converts the key into a form that can be used for signature verification.
+func (k JSONWebKey) PublicKey() (any, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent: out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %s", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, errors.New("empty value")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// parseKeySet converts all usable signature keys, skipping (and logging) the others.
+func parseKeySet(ctx context.Context, keySet JSONWebKeySet) map[string]any {
+	result := make(map[string]any)
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.PublicKey()
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("skipping unusable key %s from identity provider key set: %s", key.KeyID, err.Error())
+			continue
+		}
+		result[key.KeyID] = publicKey
+	}
+	return result
+}
+
+// refreshKeySet loads the signing keys from the jwks_uri, replacing the cached key set.
+func (i *Impl) refreshKeySet(ctx context.Context) error {
+	jwksURI := i.endpoints().jwksURI
+	if jwksURI == "" {
+		return errors.New("no jwks_uri available from .well-known endpoint")
+	}
+
+	bodyDto := JSONWebKeySet{}
+	response := aurestclientapi.ParsedResponse{
+		Body: &bodyDto,
+	}
+	err := i.client.Perform(ctx, http.MethodGet, jwksURI, nil, &response)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("error requesting key set from identity provider: %s", err.Error())
+		return err
+	}
+	if response.Status != http.StatusOK {
+		err = fmt.Errorf("unexpected http status %d, was expecting 200", response.Status)
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("error requesting key set from identity provider: %s", err.Error())
+		return err
+	}
+
+	keys := parseKeySet(ctx, bodyDto)
+	if len(keys) == 0 {
+		return errors.New("key set from identity provider contains no usable signing keys")
+	}
+
+	i.keysMutex.Lock()
+	defer i.keysMutex.Unlock()
+
+	i.keys = keys
+	i.keysLoaded = timestamp.Now()
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("loaded %d signing keys from identity provider", len(keys))
+	return nil
+}
+
+func (i *Impl) cachedKey(kid string) (any, bool) {
+	i.keysMutex.RLock()
+	defer i.keysMutex.RUnlock()
+
+	key, ok := i.keys[kid]
+	return key, ok
+}
+
+func (i *Impl) SigningKey(ctx context.Context, kid string) (any, error) {
+	if key, ok := i.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	// unknown key id - the identity provider may have rotated its keys
+	i.refreshMutex.Lock()
+	defer i.refreshMutex.Unlock()
+
+	// another request may have refreshed the key set while we were waiting
+	if key, ok := i.cachedKey(kid); ok {
+		return key, nil
+	}
+
+	// also throttled if the key set has never loaded, or the identity provider would get a request for every token
+	i.keysMutex.RLock()
+	lastRefresh := i.keysLoaded
+	i.keysMutex.RUnlock()
+	if i.keysRequested.After(lastRefresh) {
+		lastRefresh = i.keysRequested
+	}
+	if !lastRefresh.IsZero() && timestamp.Now().Sub(lastRefresh) < MinimumKeySetRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key id '%s'", kid)
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("unknown signing key id '%s', reloading key set from identity provider", kid)
+	i.keysRequested = timestamp.Now()
+	if err := i.refreshKeySet(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := i.cachedKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key id '%s'", kid)
+}
@@ -0,0 +1,176 @@
+package idp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rsaJWK(t *testing.T, kid string) (JSONWebKey, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return JSONWebKey{
+		KeyType: "RSA",
+		KeyID:   kid,
+		Use:     "sig",
+		N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}, key
+}
+
+func TestJSONWebKey_PublicKey(t *testing.T) {
+	rsaKey, rsaPrivate := rsaJWK(t, "rsa")
+	publicKey, err := rsaKey.PublicKey()
+	require.NoError(t, err)
+	require.True(t, rsaPrivate.PublicKey.Equal(publicKey))
+
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecKey := JSONWebKey{
+		KeyType: "EC",
+		KeyID:   "ec",
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(ecPrivate.X.Bytes()),
+		Y:       base64.RawURLEncoding.EncodeToString(ecPrivate.Y.Bytes()),
+	}
+	publicKey, err = ecKey.PublicKey()
+	require.NoError(t, err)
+	require.True(t, ecPrivate.PublicKey.Equal(publicKey))
+
+	_, err = JSONWebKey{KeyType: "oct", KeyID: "hmac"}.PublicKey()
+	require.EqualError(t, err, "unsupported key type oct")
+
+	_, err = JSONWebKey{KeyType: "RSA", KeyID: "broken", N: "!!!", E: "AQAB"}.PublicKey()
+	require.Error(t, err)
+}
+
+func TestParseKeySet_SkipsUnusableKeys(t *testing.T) {
+	sigKey, _ := rsaJWK(t, "sig")
+	encKey, _ := rsaJWK(t, "enc")
+	encKey.Use = "enc"
+
+	keys := parseKeySet(context.Background(), JSONWebKeySet{Keys: []JSONWebKey{
+		sigKey,
+		encKey,
+		{KeyType: "oct", KeyID: "hmac"},
+	}})
+
+	require.Len(t, keys, 1)
+	require.Contains(t, keys, "sig")
+}
+
+func TestSigningKey_RefreshesOnRotation(t *testing.T) {
+	firstKey, _ := rsaJWK(t, "first")
+	secondKey, _ := rsaJWK(t, "second")
+
+	var jwksCalls atomic.Int32
+	current := JSONWebKeySet{Keys: []JSONWebKey{firstKey}}
+
+	var idpServer *httptest.Server
+	idpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(WellKnownResponse{
+				Issuer:           idpServer.URL,
+				UserinfoEndpoint: idpServer.URL + "/userinfo",
+				JwksURI:          idpServer.URL + "/jwks",
+			})
+		case "/jwks":
+			jwksCalls.Add(1)
+			_ = json.NewEncoder(w).Encode(current)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer idpServer.Close()
+
+	cut := New(Options{
+		RequestTimeout:   5 * time.Second,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+	}).(*Impl)
+
+	ctx := context.Background()
+	require.NoError(t, cut.SetupFromWellKnown(ctx))
+	require.Equal(t, int32(1), jwksCalls.Load())
+
+	_, err := cut.SigningKey(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), jwksCalls.Load(), "known key must come from cache")
+
+	// rotation within the minimum refresh interval is not picked up
+	current = JSONWebKeySet{Keys: []JSONWebKey{secondKey}}
+	_, err = cut.SigningKey(ctx, "second")
+	require.EqualError(t, err, "unknown signing key id 'second'")
+	require.Equal(t, int32(1), jwksCalls.Load())
+
+	// pretend the key set is old enough to be refreshed
+	cut.keysLoaded = time.Now().Add(-2 * MinimumKeySetRefreshInterval)
+	_, err = cut.SigningKey(ctx, "second")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), jwksCalls.Load())
+
+	_, err = cut.SigningKey(ctx, "first")
+	require.EqualError(t, err, "unknown signing key id 'first'")
+}
+
+func TestSigningKey_ThrottledWhileNeverLoaded(t *testing.T) {
+	var jwksCalls atomic.Int32
+
+	var idpServer *httptest.Server
+	idpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(WellKnownResponse{
+				Issuer:           idpServer.URL,
+				UserinfoEndpoint: idpServer.URL + "/userinfo",
+				JwksURI:          idpServer.URL + "/jwks",
+			})
+		case "/jwks":
+			jwksCalls.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer idpServer.Close()
+
+	cut := New(Options{
+		RequestTimeout:   5 * time.Second,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+	}).(*Impl)
+
+	ctx := context.Background()
+	_ = cut.SetupFromWellKnown(ctx)
+	initialCalls := jwksCalls.Load()
+
+	_, err := cut.SigningKey(ctx, "first")
+	require.Error(t, err)
+	require.Equal(t, initialCalls+1, jwksCalls.Load(), "first unknown key id must reload the key set")
+
+	for range 3 {
+		_, err = cut.SigningKey(ctx, "first")
+		require.EqualError(t, err, "unknown signing key id 'first'")
+	}
+	require.Equal(t, initialCalls+1, jwksCalls.Load(), "must not reload the key set for every token")
+
+	// pretend the last attempt is old enough to try again
+	cut.keysRequested = time.Now().Add(-2 * MinimumKeySetRefreshInterval)
+	_, err = cut.SigningKey(ctx, "first")
+	require.Error(t, err)
+	require.Equal(t, initialCalls+2, jwksCalls.Load())
+}
@@ -0,0 +1,78 @@
+package idp
+
+import (
+	"context"
+	"fmt"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+var (
+	IDPRequestCounterName     = "identity_provider_requests_total"
+	IDPCacheLookupCounterName = "identity_provider_cache_lookups_total"
+
+	idpRequests     *prometheus.CounterVec
+	idpCacheLookups *prometheus.CounterVec
+
+	// every identity provider client is instrumented, but the metrics are registered only once
+	idpRequestsOnce     sync.Once
+	idpCacheLookupsOnce sync.Once
+)
+
+const (
+	tokenCacheHit   = "hit"
+	tokenCacheMiss  = "miss"
+	tokenCacheStale = "stale"
+)
+
+// metricsClient counts requests per identity provider.
+//
+// The http client metrics of go-autumn-restclient are labelled by hostname, which cannot tell apart
+// identity providers that share a host, such as multiple realms of the same server.
+type metricsClient struct {
+	wrapped aurestclientapi.Client
+	name    string
+}
+
+func newMetricsClient(wrapped aurestclientapi.Client, name string) aurestclientapi.Client {
+	idpRequestsOnce.Do(func() {
+		idpRequests = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: IDPRequestCounterName,
+				Help: "Number of requests to identity providers, partitioned by identity provider name, method, and response status (0 if no response was received).",
+			},
+			[]string{"idp", "method", "status"},
+		)
+		prometheus.MustRegister(idpRequests)
+	})
+
+	return &metricsClient{
+		wrapped: wrapped,
+		name:    name,
+	}
+}
+
+func (c *metricsClient) Perform(ctx context.Context, method string, requestUrl string, requestBody interface{}, response *aurestclientapi.ParsedResponse) error {
+	err := c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	// the circuit breaker turns responses with status >= 500 into errors, but they still were responses
+	status := 0
+	if response != nil {
+		status = response.Status
+	}
+	idpRequests.WithLabelValues(c.name, method, fmt.Sprintf("%d", status)).Inc()
+	return err
+}
+
+func registerTokenCacheMetrics() {
+	idpCacheLookupsOnce.Do(func() {
+		idpCacheLookups = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: IDPCacheLookupCounterName,
+				Help: "Number of token cache lookups, partitioned by identity provider name, endpoint, and result (hit, miss, or stale if a stale entry was served because the identity provider failed).",
+			},
+			[]string{"idp", "endpoint", "result"},
+		)
+		prometheus.MustRegister(idpCacheLookups)
+	})
+}
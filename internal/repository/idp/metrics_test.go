@@ -0,0 +1,32 @@
+package idp
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_CountsFailedResponsesByStatus(t *testing.T) {
+	var calls atomic.Int32
+	idpServer := flakyIDP(t, 1, http.StatusServiceUnavailable, &calls)
+	defer idpServer.Close()
+
+	cut := New(Options{
+		Name:             "metrics-test",
+		RequestTimeout:   100 * time.Millisecond,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+	})
+	require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+
+	_, status, err := cut.UserInfo(withToken("valid-token"))
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadGateway, status)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(idpRequests.WithLabelValues("metrics-test", http.MethodGet, "503")))
+	require.Equal(t, float64(0), testutil.ToFloat64(idpRequests.WithLabelValues("metrics-test", http.MethodGet, "0")))
+}
@@ -0,0 +1,74 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/sony/gobreaker"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// retryClient repeats GET requests that timed out or failed with 502, 503, or 504.
+//
+// Only GET requests are retried, because they are idempotent. Waits between attempts use exponential
+// backoff with full jitter, so many requests failing at the same time do not retry in lockstep.
+type retryClient struct {
+	wrapped aurestclientapi.Client
+	count   int
+	backoff time.Duration
+}
+
+func newRetryClient(wrapped aurestclientapi.Client, options Options) aurestclientapi.Client {
+	if options.RetryCount <= 0 {
+		return wrapped
+	}
+	return &retryClient{
+		wrapped: wrapped,
+		count:   options.RetryCount,
+		backoff: options.RetryBackoff,
+	}
+}
+
+func (c *retryClient) Perform(ctx context.Context, method string, requestUrl string, requestBody interface{}, response *aurestclientapi.ParsedResponse) error {
+	err := c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	if method != http.MethodGet {
+		return err
+	}
+
+	backoff := c.backoff
+	for attempt := 1; attempt <= c.count && shouldRetry(ctx, response, err); attempt++ {
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		aulogging.Logger.Ctx(ctx).Info().Printf("retrying %s %s in %s after attempt %d failed with status %d", method, requestUrl, wait, attempt, response.Status)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+
+		response.Status = 0
+		err = c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	}
+	return err
+}
+
+// shouldRetry is true for timeouts and other failures where no response was received, and for 502, 503, and 504.
+//
+// When the circuit breaker is open, or the caller has given up, retrying is pointless.
+func shouldRetry(ctx context.Context, response *aurestclientapi.ParsedResponse, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return false
+	}
+	switch response.Status {
+	case 0:
+		return err != nil
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyIDP fails the first requests to the userinfo and introspection endpoints with the given status.
+//
+// Status 0 makes them time out instead, by answering only after 200ms.
+func flakyIDP(t *testing.T, failures int32, failStatus int, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	var idpServer *httptest.Server
+	idpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(WellKnownResponse{
+				Issuer:                idpServer.URL,
+				UserinfoEndpoint:      idpServer.URL + "/userinfo",
+				IntrospectionEndpoint: idpServer.URL + "/introspect",
+			})
+		case "/userinfo", "/introspect":
+			if calls.Add(1) <= failures {
+				if failStatus == 0 {
+					time.Sleep(200 * time.Millisecond)
+				} else {
+					w.WriteHeader(failStatus)
+					return
+				}
+			}
+			if r.URL.Path == "/introspect" {
+				_ = json.NewEncoder(w).Encode(TokenIntrospectionResponse{Active: true})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(UserinfoResponse{Subject: "1234"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return idpServer
+}
+
+func tstRetryClient(t *testing.T, idpServer *httptest.Server) IdentityProviderClient {
+	t.Helper()
+
+	cut := New(Options{
+		Name:             "retry-test",
+		RequestTimeout:   100 * time.Millisecond,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+		RetryCount:       2,
+		RetryBackoff:     5 * time.Millisecond,
+	})
+	require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+	return cut
+}
+
+func TestRetry_UserInfo(t *testing.T) {
+	testcases := []struct {
+		name         string
+		failures     int32
+		failStatus   int
+		expectStatus int
+		expectCalls  int32
+	}{
+		{name: "bad_gateway", failures: 2, failStatus: http.StatusBadGateway, expectStatus: http.StatusOK, expectCalls: 3},
+		{name: "service_unavailable", failures: 1, failStatus: http.StatusServiceUnavailable, expectStatus: http.StatusOK, expectCalls: 2},
+		{name: "gateway_timeout", failures: 1, failStatus: http.StatusGatewayTimeout, expectStatus: http.StatusOK, expectCalls: 2},
+		{name: "timeout", failures: 1, failStatus: 0, expectStatus: http.StatusOK, expectCalls: 2},
+		{name: "giving_up", failures: 3, failStatus: http.StatusServiceUnavailable, expectStatus: http.StatusBadGateway, expectCalls: 3},
+		{name: "no_retry_on_500", failures: 1, failStatus: http.StatusInternalServerError, expectStatus: http.StatusBadGateway, expectCalls: 1},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			idpServer := flakyIDP(t, tc.failures, tc.failStatus, &calls)
+			defer idpServer.Close()
+
+			cut := tstRetryClient(t, idpServer)
+
+			_, status, _ := cut.UserInfo(withToken("valid-token"))
+			require.Equal(t, tc.expectStatus, status)
+			require.Equal(t, tc.expectCalls, calls.Load())
+		})
+	}
+}
+
+func TestRetry_NotForPost(t *testing.T) {
+	var calls atomic.Int32
+	idpServer := flakyIDP(t, 1, http.StatusServiceUnavailable, &calls)
+	defer idpServer.Close()
+
+	cut := tstRetryClient(t, idpServer)
+
+	_, status, err := cut.TokenIntrospection(withToken("valid-token"))
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadGateway, status)
+	require.Equal(t, int32(1), calls.Load(), "token introspection must not be repeated")
+}
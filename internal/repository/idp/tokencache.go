@@ -0,0 +1,161 @@
+package idp
+
+import (
+	"context"
+	"crypto/sha256"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenCacheMaxEntries limits the number of tokens a single cache holds.
+//
+// When full, expired entries are dropped first, then the oldest entry.
+var TokenCacheMaxEntries = 256
+
+// tokenCacheKey is the SHA-256 hash of an access token.
+//
+// Tokens are only kept in memory as hashes, so a heap dump of the service does not leak usable tokens.
+type tokenCacheKey [sha256.Size]byte
+
+type tokenCacheEntry[T any] struct {
+	value    T
+	recorded time.Time
+}
+
+// tokenCache keeps the last good response of the identity provider for each token.
+//
+// Entries are fresh for the retention time. After that, they are only used for the grace time
+// while the identity provider is failing, so an outage does not log out every user at once.
+//
+// A nil tokenCache caches nothing.
+type tokenCache[T any] struct {
+	idpName   string
+	endpoint  string
+	retention time.Duration
+	grace     time.Duration
+
+	entries map[tokenCacheKey]tokenCacheEntry[T]
+	mutex   sync.Mutex
+}
+
+func newTokenCache[T any](options Options, endpoint string) *tokenCache[T] {
+	if !options.CacheEnabled {
+		return nil
+	}
+	registerTokenCacheMetrics()
+
+	return &tokenCache[T]{
+		idpName:   options.Name,
+		endpoint:  endpoint,
+		retention: options.CacheRetentionTime,
+		grace:     options.CacheGraceTime,
+		entries:   make(map[tokenCacheKey]tokenCacheEntry[T]),
+	}
+}
+
+func hashToken(token string) tokenCacheKey {
+	return sha256.Sum256([]byte(token))
+}
+
+// lookup returns the cached value for the token if it is not older than maxAge.
+func (c *tokenCache[T]) lookup(token string, maxAge time.Duration) (T, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[hashToken(token)]
+	if !ok || timestamp.Now().Sub(entry.recorded) >= maxAge {
+		var empty T
+		return empty, false
+	}
+	return entry.value, true
+}
+
+func (c *tokenCache[T]) store(token string, value T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := hashToken(token)
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= TokenCacheMaxEntries {
+		c.makeRoom()
+	}
+	c.entries[key] = tokenCacheEntry[T]{
+		value:    value,
+		recorded: timestamp.Now(),
+	}
+}
+
+// makeRoom must be called with the mutex held.
+func (c *tokenCache[T]) makeRoom() {
+	now := timestamp.Now()
+	var oldestKey tokenCacheKey
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if now.Sub(entry.recorded) >= c.retention+c.grace {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest.IsZero() || entry.recorded.Before(oldest) {
+			oldestKey = key
+			oldest = entry.recorded
+		}
+	}
+	if len(c.entries) >= TokenCacheMaxEntries {
+		delete(c.entries, oldestKey)
+	}
+}
+
+func (c *tokenCache[T]) evict(token string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, hashToken(token))
+}
+
+// cached wraps a call to the identity provider made with the access token from the context.
+//
+// Fresh entries are served without calling the identity provider. Successful responses are stored,
+// and tokens the identity provider rejects are evicted. If the identity provider fails with a 5xx status,
+// including when the request fails or the circuit breaker is open, an entry within the grace time is served.
+func (c *tokenCache[T]) cached(ctx context.Context, call func() (*T, int, error)) (*T, int, error) {
+	token := common.GetAccessToken(ctx)
+	if c == nil || token == "" {
+		return call()
+	}
+
+	if value, ok := c.lookup(token, c.retention); ok {
+		c.count(tokenCacheHit)
+		return &value, http.StatusOK, nil
+	}
+
+	result, status, err := call()
+	switch {
+	case err == nil && status == http.StatusOK:
+		c.count(tokenCacheMiss)
+		c.store(token, *result)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		c.count(tokenCacheMiss)
+		c.evict(token)
+	case status >= http.StatusInternalServerError:
+		if value, ok := c.lookup(token, c.retention+c.grace); ok {
+			c.count(tokenCacheStale)
+			aulogging.Logger.Ctx(ctx).Warn().Printf("identity provider %s failed with status %d, serving stale cached %s response", c.idpName, status, c.endpoint)
+			return &value, http.StatusOK, nil
+		}
+		c.count(tokenCacheMiss)
+	default:
+		c.count(tokenCacheMiss)
+	}
+	return result, status, err
+}
+
+func (c *tokenCache[T]) count(result string) {
+	idpCacheLookups.WithLabelValues(c.idpName, c.endpoint, result).Inc()
+}
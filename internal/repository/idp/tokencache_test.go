@@ -0,0 +1,167 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// userinfoIDP serves a .well-known endpoint and a userinfo endpoint that knows the token "valid-token".
+//
+// While failing is set, the userinfo endpoint answers with 503.
+func userinfoIDP(t *testing.T, failing *atomic.Bool, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	var idpServer *httptest.Server
+	idpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(WellKnownResponse{
+				Issuer:           idpServer.URL,
+				UserinfoEndpoint: idpServer.URL + "/userinfo",
+			})
+		case "/userinfo":
+			calls.Add(1)
+			if failing.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer valid-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(UserinfoResponse{Subject: "1234"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return idpServer
+}
+
+func tstUserinfoClient(t *testing.T, idpServer *httptest.Server, retention time.Duration, grace time.Duration) IdentityProviderClient {
+	t.Helper()
+
+	cut := New(Options{
+		RequestTimeout:     5 * time.Second,
+		CacheEnabled:       true,
+		CacheRetentionTime: retention,
+		CacheGraceTime:     grace,
+		OIDCWellKnownURL:   idpServer.URL + "/.well-known/openid-configuration",
+	})
+	require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+	return cut
+}
+
+func TestUserInfo_CachedPerToken(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	idpServer := userinfoIDP(t, &failing, &calls)
+	defer idpServer.Close()
+
+	cut := tstUserinfoClient(t, idpServer, 30*time.Second, 0)
+
+	for range 3 {
+		result, status, err := cut.UserInfo(withToken("valid-token"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, "1234", result.Subject)
+	}
+	require.Equal(t, int32(1), calls.Load(), "repeated user info lookups of the same token must come from cache")
+
+	_, status, _ := cut.UserInfo(withToken("other-token"))
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, int32(2), calls.Load(), "other tokens must not share a cache entry")
+
+	entries := cut.(*Impl).userinfoCache.entries
+	require.Len(t, entries, 1)
+	require.Contains(t, entries, hashToken("valid-token"), "tokens must only be stored as hashes")
+}
+
+func TestUserInfo_ServeStaleDuringOutage(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	idpServer := userinfoIDP(t, &failing, &calls)
+	defer idpServer.Close()
+
+	cut := tstUserinfoClient(t, idpServer, 10*time.Millisecond, time.Hour)
+
+	_, _, err := cut.UserInfo(withToken("valid-token"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	failing.Store(true)
+	result, status, err := cut.UserInfo(withToken("valid-token"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "1234", result.Subject)
+	require.Equal(t, int32(2), calls.Load(), "expired entries must not be served without asking the identity provider")
+
+	_, status, err = cut.UserInfo(withToken("other-token"))
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadGateway, status, "tokens without a cache entry must fail during an outage")
+}
+
+func TestUserInfo_NoStaleAfterGrace(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	idpServer := userinfoIDP(t, &failing, &calls)
+	defer idpServer.Close()
+
+	cut := tstUserinfoClient(t, idpServer, 10*time.Millisecond, 10*time.Millisecond)
+
+	_, _, err := cut.UserInfo(withToken("valid-token"))
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	failing.Store(true)
+	_, status, err := cut.UserInfo(withToken("valid-token"))
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadGateway, status)
+}
+
+func TestUserInfo_EvictCachedToken(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	idpServer := userinfoIDP(t, &failing, &calls)
+	defer idpServer.Close()
+
+	cut := tstUserinfoClient(t, idpServer, 30*time.Second, time.Hour)
+
+	_, _, err := cut.UserInfo(withToken("valid-token"))
+	require.NoError(t, err)
+
+	cut.EvictCachedToken("valid-token")
+
+	failing.Store(true)
+	_, status, err := cut.UserInfo(withToken("valid-token"))
+	require.Error(t, err, "evicted tokens must not be served, not even during an outage")
+	require.Equal(t, http.StatusBadGateway, status)
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestTokenCache_MaxEntries(t *testing.T) {
+	original := TokenCacheMaxEntries
+	TokenCacheMaxEntries = 2
+	defer func() {
+		TokenCacheMaxEntries = original
+	}()
+
+	cut := newTokenCache[UserinfoResponse](Options{CacheEnabled: true, CacheRetentionTime: time.Minute}, "userinfo")
+	cut.store("first", UserinfoResponse{Subject: "1"})
+	time.Sleep(time.Millisecond)
+	cut.store("second", UserinfoResponse{Subject: "2"})
+	cut.store("third", UserinfoResponse{Subject: "3"})
+
+	_, ok := cut.lookup("first", time.Minute)
+	require.False(t, ok, "the oldest entry must make room")
+	_, ok = cut.lookup("third", time.Minute)
+	require.True(t, ok)
+	require.Len(t, cut.entries, 2)
+}
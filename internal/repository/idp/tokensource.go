@@ -0,0 +1,148 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/go-http-utils/headers"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ServiceTokenExpiryMargin is how long before its expiry a cached service token is replaced.
+//
+// This leaves time for the downstream call, and allows for some clock skew between us and the identity provider.
+var ServiceTokenExpiryMargin = 30 * time.Second
+
+// TokenSource supplies the access token to send with a call to another service.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ServiceTokenSource sends a token for this service itself, obtained using the client credentials grant.
+//
+// Use this for calls that are made on behalf of this service, not on behalf of the caller.
+func ServiceTokenSource(client IdentityProviderClient) TokenSource {
+	return &serviceTokenSource{client: client}
+}
+
+type serviceTokenSource struct {
+	client IdentityProviderClient
+}
+
+func (s *serviceTokenSource) Token(ctx context.Context) (string, error) {
+	return s.client.ServiceToken(ctx)
+}
+
+// ForwardedTokenSource sends the access token the current request came with.
+//
+// Use this for calls that are made on behalf of the caller, so the other service sees the same user.
+func ForwardedTokenSource() TokenSource {
+	return &forwardedTokenSource{}
+}
+
+type forwardedTokenSource struct{}
+
+func (s *forwardedTokenSource) Token(ctx context.Context) (string, error) {
+	token := common.GetAccessToken(ctx)
+	if token == "" {
+		return "", errors.New("no access token in context to forward")
+	}
+	return token, nil
+}
+
+// BearerRequestManipulator adds the token from the given source as a bearer token to every request.
+//
+// Pass it to auresthttpclient.New when creating the client for another service. If no token
+// can be obtained, the request is sent without Authorization, and the other service will deny it.
+func BearerRequestManipulator(source TokenSource) aurestclientapi.RequestManipulatorCallback {
+	return func(ctx context.Context, r *http.Request) {
+		token, err := source.Token(ctx)
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to obtain token for downstream %s %s: %s", r.Method, r.URL.String(), err.Error())
+			return
+		}
+		r.Header.Set(headers.Authorization, "Bearer "+token)
+	}
+}
+
+func (i *Impl) cachedServiceToken() (string, bool) {
+	if i.serviceToken == "" || !timestamp.Now().Before(i.serviceTokenExpires) {
+		return "", false
+	}
+	return i.serviceToken, true
+}
+
+func (i *Impl) ServiceToken(ctx context.Context) (string, error) {
+	// also ensures there is only ever one token request in flight, everyone else waits for its result,
+	// or until their own request is cancelled
+	select {
+	case i.serviceTokenLock <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-i.serviceTokenLock }()
+
+	if token, ok := i.cachedServiceToken(); ok {
+		return token, nil
+	}
+
+	tokenURL := i.endpoints().tokenURL
+	if tokenURL == "" {
+		return "", errors.New("no token endpoint available from .well-known endpoint")
+	}
+
+	requestBody := url.Values{}
+	requestBody.Set("grant_type", "client_credentials")
+	if i.options.ClientScopes != "" {
+		requestBody.Set("scope", i.options.ClientScopes)
+	}
+	if i.options.ClientAuthMethod == ClientAuthMethodPost {
+		requestBody.Set("client_id", i.options.ClientID)
+		requestBody.Set("client_secret", i.options.ClientSecret)
+	}
+
+	bodyDto := TokenResponse{}
+	response := aurestclientapi.ParsedResponse{
+		Body: &bodyDto,
+	}
+	err := i.client.Perform(ctx, http.MethodPost, tokenURL, requestBody, &response)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("error requesting service token from identity provider: error from response is %s:%s, local error is %s", bodyDto.ErrorCode, bodyDto.ErrorDescription, err.Error())
+		return "", err
+	}
+	if response.Status != http.StatusOK {
+		err = fmt.Errorf("unexpected http status %d, was expecting 200", response.Status)
+		aulogging.Logger.Ctx(ctx).Error().Printf("error requesting service token from identity provider: error from response is %s:%s, local error is %s", bodyDto.ErrorCode, bodyDto.ErrorDescription, err.Error())
+		return "", err
+	}
+	if bodyDto.AccessToken == "" {
+		return "", errors.New("token response from identity provider contains no access token")
+	}
+
+	i.serviceToken = bodyDto.AccessToken
+	i.serviceTokenExpires = timestamp.Now().Add(serviceTokenCacheDuration(bodyDto.ExpiresIn))
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("obtained service token from identity provider, valid for %d seconds", bodyDto.ExpiresIn)
+	return i.serviceToken, nil
+}
+
+// serviceTokenCacheDuration determines how long a token with the given lifetime may be used.
+//
+// Short-lived tokens are kept for half their lifetime instead, and tokens without a stated lifetime
+// only for the expiry margin.
+func serviceTokenCacheDuration(expiresInSeconds int64) time.Duration {
+	if expiresInSeconds <= 0 {
+		return ServiceTokenExpiryMargin
+	}
+	lifetime := time.Duration(expiresInSeconds) * time.Second
+	if lifetime <= 2*ServiceTokenExpiryMargin {
+		return lifetime / 2
+	}
+	return lifetime - ServiceTokenExpiryMargin
+}
@@ -0,0 +1,152 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tokenIDP(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	var idpServer *httptest.Server
+	idpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(WellKnownResponse{
+				Issuer:           idpServer.URL,
+				UserinfoEndpoint: idpServer.URL + "/userinfo",
+				TokenEndpoint:    idpServer.URL + "/token",
+			})
+		case "/token":
+			call := calls.Add(1)
+			// slow enough that concurrent callers overlap
+			time.Sleep(50 * time.Millisecond)
+			clientID, clientSecret, ok := r.BasicAuth()
+			if !ok || clientID != "my-client" || clientSecret != "my-secret" || r.PostFormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_ = json.NewEncoder(w).Encode(TokenResponse{ErrorCode: "invalid_client"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(TokenResponse{
+				AccessToken: fmt.Sprintf("service-token-%d-%s", call, r.PostFormValue("scope")),
+				TokenType:   "Bearer",
+				ExpiresIn:   300,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return idpServer
+}
+
+func TestServiceToken_CachedUntilShortlyBeforeExpiry(t *testing.T) {
+	var calls atomic.Int32
+	idpServer := tokenIDP(t, &calls)
+	defer idpServer.Close()
+
+	cut := New(Options{
+		RequestTimeout:   5 * time.Second,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+		ClientID:         "my-client",
+		ClientSecret:     "my-secret",
+		ClientAuthMethod: ClientAuthMethodBasic,
+		ClientScopes:     "registration",
+	}).(*Impl)
+	ctx := context.Background()
+	require.NoError(t, cut.SetupFromWellKnown(ctx))
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for n := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := cut.ServiceToken(ctx)
+			require.NoError(t, err)
+			tokens[n] = token
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls.Load(), "concurrent callers must share a single token request")
+	for _, token := range tokens {
+		require.Equal(t, "service-token-1-registration", token)
+	}
+	require.WithinDuration(t, time.Now().Add(300*time.Second-ServiceTokenExpiryMargin), cut.serviceTokenExpires, 5*time.Second)
+
+	// pretend the token is about to expire
+	cut.serviceTokenExpires = time.Now().Add(-time.Second)
+	token, err := cut.ServiceToken(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "service-token-2-registration", token)
+}
+
+func TestServiceToken_WaitingCancelled(t *testing.T) {
+	var calls atomic.Int32
+	idpServer := tokenIDP(t, &calls)
+	defer idpServer.Close()
+
+	cut := New(Options{
+		RequestTimeout:   5 * time.Second,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+		ClientID:         "my-client",
+		ClientSecret:     "my-secret",
+		ClientAuthMethod: ClientAuthMethodBasic,
+	}).(*Impl)
+	require.NoError(t, cut.SetupFromWellKnown(context.Background()))
+
+	// pretend a token request is in flight
+	cut.serviceTokenLock <- struct{}{}
+	defer func() { <-cut.serviceTokenLock }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := cut.ServiceToken(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int32(0), calls.Load())
+}
+
+func TestServiceToken_Denied(t *testing.T) {
+	var calls atomic.Int32
+	idpServer := tokenIDP(t, &calls)
+	defer idpServer.Close()
+
+	cut := New(Options{
+		RequestTimeout:   5 * time.Second,
+		OIDCWellKnownURL: idpServer.URL + "/.well-known/openid-configuration",
+		ClientID:         "my-client",
+		ClientSecret:     "wrong-secret",
+		ClientAuthMethod: ClientAuthMethodBasic,
+	})
+	ctx := context.Background()
+	require.NoError(t, cut.SetupFromWellKnown(ctx))
+
+	_, err := cut.ServiceToken(ctx)
+	require.EqualError(t, err, "unexpected http status 401, was expecting 200")
+}
+
+func TestServiceTokenCacheDuration(t *testing.T) {
+	require.Equal(t, 270*time.Second, serviceTokenCacheDuration(300))
+	require.Equal(t, 20*time.Second, serviceTokenCacheDuration(40))
+	require.Equal(t, ServiceTokenExpiryMargin, serviceTokenCacheDuration(0))
+}
+
+func TestBearerRequestManipulator(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/downstream", nil)
+	BearerRequestManipulator(ForwardedTokenSource())(withToken("caller-token"), request)
+	require.Equal(t, "Bearer caller-token", request.Header.Get("Authorization"))
+
+	request = httptest.NewRequest(http.MethodGet, "/downstream", nil)
+	BearerRequestManipulator(ForwardedTokenSource())(context.Background(), request)
+	require.Equal(t, "", request.Header.Get("Authorization"))
+}
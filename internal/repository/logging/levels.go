@@ -0,0 +1,228 @@
+package logging
+
+import (
+	"context"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/timestamp"
+	"github.com/google/uuid"
+	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// allLevels lets the handlers wrapped by levelHandler accept records of any level.
+const allLevels = slog.Level(math.MinInt)
+
+// globalLevel is the minimum level of all loggers, unless overridden for a request. It can be changed at runtime.
+var globalLevel = new(slog.LevelVar)
+
+// levelHandler filters records by a level that can change at runtime, or that is set for a single request.
+//
+// The wrapped handler must accept all levels.
+type levelHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+// NewLevelHandler wraps a handler that accepts all levels, so it follows the global level set through SetLevel.
+func NewLevelHandler(next slog.Handler) slog.Handler {
+	return &levelHandler{
+		next:  next,
+		level: globalLevel,
+	}
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.next.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{
+		next:  h.next.WithAttrs(attrs),
+		level: h.level,
+	}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{
+		next:  h.next.WithGroup(name),
+		level: h.level,
+	}
+}
+
+// WithLevel returns a logger like logger, but with a fixed minimum level instead of the global one.
+//
+// Loggers not set up by this package are returned unchanged.
+func WithLevel(logger *slog.Logger, level slog.Level) *slog.Logger {
+	h, ok := logger.Handler().(*levelHandler)
+	if !ok {
+		return logger
+	}
+	return slog.New(&levelHandler{
+		next:  h.next,
+		level: level,
+	})
+}
+
+// Unfiltered returns a logger like logger that logs all levels. Use it for messages that must not
+// be lost to a high log level, such as audit events.
+func Unfiltered(logger *slog.Logger) *slog.Logger {
+	return WithLevel(logger, allLevels)
+}
+
+var (
+	levelMutex     sync.Mutex
+	permanentLevel slog.Level
+	revertTimer    *time.Timer
+	revertAt       time.Time
+)
+
+// setInitialLevel sets the global level configured at startup.
+func setInitialLevel(level slog.Level) {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+
+	stopRevert()
+	permanentLevel = level
+	globalLevel.Set(level)
+}
+
+// Level returns the current global level, and the time it reverts at, which is zero if the level is permanent.
+func Level() (slog.Level, time.Time) {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+
+	return globalLevel.Level(), revertAt
+}
+
+// SetLevel changes the global level.
+//
+// If duration is positive, the level reverts to the last level set without a duration after that time.
+// Returns the time it reverts at, which is zero if the change is permanent.
+func SetLevel(level slog.Level, duration time.Duration) time.Time {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+
+	stopRevert()
+	globalLevel.Set(level)
+	if duration <= 0 {
+		permanentLevel = level
+		return revertAt
+	}
+
+	revertAt = timestamp.Now().Add(duration)
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		levelMutex.Lock()
+		defer levelMutex.Unlock()
+
+		if revertTimer != timer {
+			// superseded by a later change
+			return
+		}
+		revertTimer = nil
+		revertAt = time.Time{}
+		globalLevel.Set(permanentLevel)
+	})
+	revertTimer = timer
+	return revertAt
+}
+
+// stopRevert cancels a pending revert. Must be called with the mutex held.
+func stopRevert() {
+	if revertTimer != nil {
+		revertTimer.Stop()
+		revertTimer = nil
+	}
+	revertAt = time.Time{}
+}
+
+// RouteMatcher reports whether a request with this method and route pattern targets a route.
+type RouteMatcher func(method string, routePattern string) bool
+
+// Override sets the level for requests that match all the given criteria.
+type Override struct {
+	ID        string
+	Subject   string
+	Route     string
+	RequestID string
+	Level     slog.Level
+
+	// MatchesRoute is compiled from Route when the override is added. It must be set if Route is.
+	MatchesRoute RouteMatcher
+
+	// ExpiresAt is the time the override stops applying. Zero means never.
+	ExpiresAt time.Time
+}
+
+func (o Override) expired(now time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !now.Before(o.ExpiresAt)
+}
+
+var (
+	overrides      []Override
+	overridesMutex sync.RWMutex
+)
+
+// AddOverride registers an override, assigning it an id.
+func AddOverride(override Override) Override {
+	overridesMutex.Lock()
+	defer overridesMutex.Unlock()
+
+	override.ID = uuid.NewString()
+	overrides = append(removeExpired(overrides, timestamp.Now()), override)
+	return override
+}
+
+// RemoveOverride removes an override and returns it, or returns false if there was none with this id.
+func RemoveOverride(id string) (Override, bool) {
+	overridesMutex.Lock()
+	defer overridesMutex.Unlock()
+
+	index := slices.IndexFunc(overrides, func(o Override) bool {
+		return o.ID == id
+	})
+	if index < 0 {
+		return Override{}, false
+	}
+	removed := overrides[index]
+	overrides = slices.Delete(overrides, index, index+1)
+	return removed, true
+}
+
+// Overrides lists the overrides that have not expired.
+func Overrides() []Override {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	return removeExpired(slices.Clone(overrides), timestamp.Now())
+}
+
+// OverrideLevel returns the lowest level of all overrides that have not expired and for which matches
+// returns true. Returns false if there are none.
+func OverrideLevel(matches func(Override) bool) (slog.Level, bool) {
+	overridesMutex.RLock()
+	defer overridesMutex.RUnlock()
+
+	now := timestamp.Now()
+	found := false
+	result := slog.Level(math.MaxInt)
+	for _, override := range overrides {
+		if !override.expired(now) && override.Level < result && matches(override) {
+			result = override.Level
+			found = true
+		}
+	}
+	return result, found
+}
+
+func removeExpired(list []Override, now time.Time) []Override {
+	return slices.DeleteFunc(list, func(o Override) bool {
+		return o.expired(now)
+	})
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tstLogger() (*slog.Logger, *bytes.Buffer) {
+	buffer := &bytes.Buffer{}
+	return slog.New(NewLevelHandler(slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: allLevels}))), buffer
+}
+
+func TestLevelHandler(t *testing.T) {
+	setInitialLevel(slog.LevelInfo)
+	defer setInitialLevel(slog.LevelInfo)
+
+	logger, logs := tstLogger()
+	logger = logger.With("tst.field", "kept")
+	logger.Debug("debug 1")
+	logger.Info("info 1")
+
+	SetLevel(slog.LevelDebug, 0)
+	logger.Debug("debug 2")
+
+	SetLevel(slog.LevelWarn, 0)
+	logger.Info("info 2")
+	WithLevel(logger, slog.LevelDebug).Debug("debug 3")
+	Unfiltered(logger).Info("info 3")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Contains(t, lines[0], `"msg":"info 1"`)
+	require.Contains(t, lines[1], `"msg":"debug 2"`)
+	require.Contains(t, lines[2], `"msg":"debug 3"`)
+	require.Contains(t, lines[2], `"tst.field":"kept"`, "WithLevel must keep attributes")
+	require.Contains(t, lines[3], `"msg":"info 3"`)
+}
+
+func TestWithLevel_Foreign(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+	require.Same(t, logger, WithLevel(logger, slog.LevelDebug))
+}
+
+func TestSetLevel_Timed(t *testing.T) {
+	setInitialLevel(slog.LevelInfo)
+	defer setInitialLevel(slog.LevelInfo)
+
+	revertAt := SetLevel(slog.LevelDebug, 50*time.Millisecond)
+	require.False(t, revertAt.IsZero())
+
+	current, currentRevertAt := Level()
+	require.Equal(t, slog.LevelDebug, current)
+	require.Equal(t, revertAt, currentRevertAt)
+
+	require.Eventually(t, func() bool {
+		current, currentRevertAt = Level()
+		return current == slog.LevelInfo && currentRevertAt.IsZero()
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSetLevel_Superseded(t *testing.T) {
+	setInitialLevel(slog.LevelInfo)
+	defer setInitialLevel(slog.LevelInfo)
+
+	SetLevel(slog.LevelDebug, 20*time.Millisecond)
+	SetLevel(slog.LevelWarn, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	current, revertAt := Level()
+	require.Equal(t, slog.LevelWarn, current, "permanent change must cancel pending revert")
+	require.True(t, revertAt.IsZero())
+}
+
+func TestOverrides(t *testing.T) {
+	debug := AddOverride(Override{Subject: "1234", Level: slog.LevelDebug})
+	defer RemoveOverride(debug.ID)
+	trace := AddOverride(Override{Subject: "1234", Route: "GET /", Level: slog.LevelDebug - 4})
+	defer RemoveOverride(trace.ID)
+	expired := AddOverride(Override{RequestID: "expired", Level: slog.LevelDebug, ExpiresAt: time.Now().Add(-time.Second)})
+	defer RemoveOverride(expired.ID)
+	require.NotEmpty(t, debug.ID)
+	require.NotEqual(t, debug.ID, trace.ID)
+
+	bySubject := func(o Override) bool { return o.Subject == "1234" }
+	lvl, found := OverrideLevel(bySubject)
+	require.True(t, found)
+	require.Equal(t, slog.LevelDebug-4, lvl, "lowest matching level must win")
+
+	lvl, found = OverrideLevel(func(o Override) bool { return o.Subject == "1234" && o.Route == "" })
+	require.True(t, found)
+	require.Equal(t, slog.LevelDebug, lvl)
+
+	_, found = OverrideLevel(func(o Override) bool { return o.RequestID == "expired" })
+	require.False(t, found, "expired overrides must not match")
+	require.Len(t, Overrides(), 2)
+
+	removed, found := RemoveOverride(trace.ID)
+	require.True(t, found)
+	require.Equal(t, trace, removed)
+	_, found = RemoveOverride(trace.ID)
+	require.False(t, found)
+	lvl, _ = OverrideLevel(bySubject)
+	require.Equal(t, slog.LevelDebug, lvl)
+}
@@ -8,6 +8,7 @@ import (
 	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"io"
 	"log/slog"
 	"os"
 )
@@ -37,6 +38,10 @@ func PreliminarySetup() {
 func Setup() error {
 	aulogging.RequestIdRetriever = common.GetRequestID
 
+	if err := SetupRedaction(); err != nil {
+		return err
+	}
+
 	style := auconfigenv.Get(ConfLogStyle)
 
 	switch style {
@@ -46,7 +51,7 @@ func Setup() error {
 			return err
 		}
 
-		setupPlain(lvl)
+		setupPlain(os.Stderr, lvl)
 	case LogStyleJSON, "":
 		if err := setupJSON(); err != nil {
 			return err
@@ -63,7 +68,7 @@ const (
 )
 
 func ConfigItems() []auconfigapi.ConfigItem {
-	return append(
+	items := append(
 		auslog.NewConfig().ConfigItems(),
 		auconfigapi.ConfigItem{
 			Key:         ConfLogStyle,
@@ -72,6 +77,7 @@ func ConfigItems() []auconfigapi.ConfigItem {
 			Validate:    auconfigapi.ConfigNeedsNoValidation, // validated by logging initialize
 		},
 	)
+	return append(items, redactionConfigItems()...)
 }
 
 func obtainDefaultValue(key string) string {
@@ -90,10 +96,12 @@ func setupJSONWithAllDefaults() {
 	}
 }
 
-func setupPlain(lvl slog.Level) {
-	slog.SetLogLoggerLevel(lvl)
-	plainLogger := slog.Default()
+func setupPlain(w io.Writer, lvl slog.Level) {
+	setInitialLevel(lvl)
+
+	plainLogger := slog.New(newPlainHandler(w))
 	aulogging.Logger = auslog.New().WithLogger(plainLogger)
+	slog.SetDefault(plainLogger)
 }
 
 func setupJSON() error {
@@ -102,8 +110,30 @@ func setupJSON() error {
 		return err
 	}
 
-	structuredLogger := slog.New(slog.NewJSONHandler(os.Stdout, config.HandlerOptions()))
+	setInitialLevel(config.LogLevel())
+
+	structuredLogger := slog.New(newJSONHandler(os.Stdout, config.HandlerOptions()))
 	aulogging.Logger = auslog.New().WithLogger(structuredLogger)
 	slog.SetDefault(structuredLogger)
 	return nil
 }
+
+// newPlainHandler writes text output through its own handler rather than the default one,
+// because after slog.SetDefault the default handler would loop back into the log package.
+func newPlainHandler(w io.Writer) slog.Handler {
+	options := &slog.HandlerOptions{
+		Level: allLevels,
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey {
+				attr.Value = slog.StringValue(level.LevelToString(attr.Value.Any().(slog.Level)))
+			}
+			return attr
+		},
+	}
+	return NewLevelHandler(NewRedactingHandler(slog.NewTextHandler(w, options)))
+}
+
+func newJSONHandler(w io.Writer, options *slog.HandlerOptions) slog.Handler {
+	options.Level = allLevels
+	return NewLevelHandler(NewRedactingHandler(slog.NewJSONHandler(w, options)))
+}
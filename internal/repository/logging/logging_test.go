@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func tstSetupPlain(t *testing.T, lvl slog.Level) *bytes.Buffer {
+	originalLogger := aulogging.Logger
+	originalDefault := slog.Default()
+	t.Cleanup(func() {
+		aulogging.Logger = originalLogger
+		slog.SetDefault(originalDefault)
+		setInitialLevel(slog.LevelInfo)
+	})
+
+	SetRedactionRules(DefaultRedactionRules())
+	output := &bytes.Buffer{}
+	setupPlain(output, lvl)
+	return output
+}
+
+func TestSetupPlain(t *testing.T) {
+	output := tstSetupPlain(t, slog.LevelInfo)
+
+	ctx := context.Background()
+	aulogging.Logger.Ctx(ctx).Debug().Print("debug 1")
+	aulogging.Logger.Ctx(ctx).Info().Print("info 1")
+
+	SetLevel(slog.LevelDebug, 0)
+	aulogging.Logger.Ctx(ctx).Debug().Print("debug 2")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "level=INFO msg=\"info 1\"")
+	require.Contains(t, lines[1], "level=DEBUG msg=\"debug 2\"", "must follow runtime level changes")
+}
+
+func TestSetupPlain_Default(t *testing.T) {
+	output := tstSetupPlain(t, slog.LevelInfo)
+
+	slog.Default().Debug("debug 1")
+	slog.Default().Info("info 1", "authorization", "Bearer abcdefghijk")
+
+	SetLevel(slog.LevelDebug, 0)
+	slog.Default().Debug("debug 2")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 2, "must filter the default logger by the configured level")
+	require.Contains(t, lines[0], "level=INFO msg=\"info 1\" authorization=[REDACTED]", "must redact the default logger")
+	require.NotContains(t, output.String(), "abcdefghijk")
+	require.Contains(t, lines[1], "level=DEBUG msg=\"debug 2\"", "must follow runtime level changes")
+}
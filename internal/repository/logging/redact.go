@@ -0,0 +1,318 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Redacted replaces sensitive values in log output.
+const Redacted = "[REDACTED]"
+
+// minSecretLength avoids masking every occurrence of trivial strings if a secret config value is very short.
+const minSecretLength = 6
+
+// RedactionRules describe the values masked in log output.
+type RedactionRules struct {
+	// Keys are attribute keys whose values are masked completely. They are matched ignoring case, either
+	// against the whole key or its last dot separated part, so "authorization" also masks "http.request.header.authorization".
+	Keys []string
+
+	// Patterns are masked wherever they match in messages and attribute values. If a pattern starts
+	// with a capture group, the text matched by that group is kept, so "(Bearer )\S+" leaves the
+	// word Bearer in place.
+	Patterns []*regexp.Regexp
+
+	// Secrets are literal values masked wherever they occur, such as api keys from the configuration.
+	Secrets []string
+}
+
+// DefaultRedactionRules mask bearer tokens, api keys, vault tokens, tokens in query parameters, and email addresses.
+func DefaultRedactionRules() RedactionRules {
+	return RedactionRules{
+		Keys: []string{
+			"authorization", "cookie", "set-cookie", "x-api-key", "x-vault-token",
+			"password", "secret", "client_secret", "token", "access_token", "id_token", "refresh_token",
+		},
+		Patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9\-._~+/]+=*`),
+			regexp.MustCompile(`(?i)((?:x-api-key|x-vault-token)["']?\s*[:=]\s*\[?["']?)[^\s"',;}\]]+`),
+			regexp.MustCompile(`(?i)([?&](?:access_token|id_token|refresh_token|token|api_key|apikey|client_secret)=)[^&\s#"]+`),
+			regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`),
+		},
+	}
+}
+
+type redactor struct {
+	keys     []string
+	patterns []*regexp.Regexp
+	secrets  *strings.Replacer
+}
+
+// activeRedactor is shared by all loggers, so rules can be completed once secrets have been loaded.
+var activeRedactor atomic.Pointer[redactor]
+
+// SetRedactionRules replaces the rules applied by all loggers set up by this package.
+//
+// Attributes added to a logger with With before the change keep the redaction they got at the time.
+func SetRedactionRules(rules RedactionRules) {
+	r := &redactor{
+		patterns: rules.Patterns,
+	}
+	for _, key := range rules.Keys {
+		r.keys = append(r.keys, strings.ToLower(key))
+	}
+
+	secrets := slices.Clone(rules.Secrets)
+	// longest first, so a secret containing another one is masked completely
+	slices.SortFunc(secrets, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	replacements := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength {
+			replacements = append(replacements, secret, Redacted)
+		}
+	}
+	if len(replacements) > 0 {
+		r.secrets = strings.NewReplacer(replacements...)
+	}
+
+	activeRedactor.Store(r)
+}
+
+func (r *redactor) sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range r.keys {
+		if key == k || strings.HasSuffix(key, "."+k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redactor) redactString(value string) string {
+	if r.secrets != nil {
+		value = r.secrets.Replace(value)
+	}
+	for _, pattern := range r.patterns {
+		value = pattern.ReplaceAllString(value, "${1}"+Redacted)
+	}
+	return value
+}
+
+func (r *redactor) redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		group := value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, member := range group {
+			redacted = append(redacted, r.redactAttr(member))
+		}
+		return slog.Group(attr.Key, redacted...)
+	}
+
+	if r.sensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, r.redactString(value.String()))
+	case slog.KindAny:
+		// only replaced if there is something to mask
+		switch v := value.Any().(type) {
+		case error, fmt.Stringer:
+			formatted := fmt.Sprint(v)
+			if redacted := r.redactString(formatted); redacted != formatted {
+				return slog.String(attr.Key, redacted)
+			}
+		default:
+			if formatted, err := json.Marshal(v); err == nil {
+				if redacted := r.redactString(string(formatted)); redacted != string(formatted) {
+					return slog.Any(attr.Key, redactedJSON(redacted))
+				}
+			} else {
+				formatted := fmt.Sprintf("%+v", v)
+				if redacted := r.redactString(formatted); redacted != formatted {
+					return slog.String(attr.Key, redacted)
+				}
+			}
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// Redact masks a value by the rules set through SetRedactionRules, the same way as a log attribute with this key.
+// Values with nothing to mask are returned unchanged.
+func Redact(key string, value any) any {
+	r := activeRedactor.Load()
+	if r == nil || value == nil {
+		return value
+	}
+	return r.redactAttr(slog.Any(key, value)).Value.Any()
+}
+
+// redactedJSON is a value that has been masked after encoding it as JSON. JSON logs keep it
+// as structured data, plain logs show the JSON.
+type redactedJSON string
+
+func (j redactedJSON) MarshalJSON() ([]byte, error) {
+	return []byte(j), nil
+}
+
+func (j redactedJSON) MarshalText() ([]byte, error) {
+	return []byte(j), nil
+}
+
+// redactingHandler masks sensitive values before passing records on.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler wraps a handler so it never sees values masked by the rules set through SetRedactionRules.
+func NewRedactingHandler(next slog.Handler) slog.Handler {
+	return &redactingHandler{
+		next: next,
+	}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	r := activeRedactor.Load()
+	if r == nil {
+		return h.next.Handle(ctx, record)
+	}
+
+	redacted := slog.NewRecord(record.Time, record.Level, r.redactString(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(r.redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if r := activeRedactor.Load(); r != nil {
+		redacted := make([]slog.Attr, 0, len(attrs))
+		for _, attr := range attrs {
+			redacted = append(redacted, r.redactAttr(attr))
+		}
+		attrs = redacted
+	}
+	return &redactingHandler{
+		next: h.next.WithAttrs(attrs),
+	}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{
+		next: h.next.WithGroup(name),
+	}
+}
+
+const (
+	ConfLogRedactKeys             = "LOG_REDACT_KEYS"
+	ConfLogRedactPatterns         = "LOG_REDACT_PATTERNS"
+	ConfLogRedactSecretConfigKeys = "LOG_REDACT_SECRET_CONFIG_KEYS"
+)
+
+func redactionConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfLogRedactKeys,
+			Default:     "",
+			Description: "space separated list of additional log attribute keys whose values are masked. Authorization, cookies, api keys, vault tokens, passwords and tokens are always masked.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfLogRedactPatterns,
+			Default:     "[]",
+			Description: "JSON list of additional regular expressions masked in log messages and values. If a pattern starts with a capture group, the text it matches is kept. Bearer tokens, api keys, vault tokens, tokens in query parameters and email addresses are always masked.",
+			Validate:    validateRedactPatterns,
+		}, {
+			Key:         ConfLogRedactSecretConfigKeys,
+			Default:     "API_KEY API_KEYS EVENTS_API_KEY IDP_CLIENT_SECRET VAULT_AUTH_TOKEN",
+			Description: "space separated list of configuration keys holding secrets. Their values are masked wherever they occur in logs. For JSON objects such as API_KEYS, each value is masked.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		},
+	}
+}
+
+func validateRedactPatterns(key string) error {
+	_, err := parseRedactPatterns(auconfigenv.Get(key))
+	return err
+}
+
+func parseRedactPatterns(value string) ([]*regexp.Regexp, error) {
+	var sources []string
+	if err := json.Unmarshal([]byte(value), &sources); err != nil {
+		return nil, fmt.Errorf("invalid redaction patterns, must be a JSON list of strings: %w", err)
+	}
+	result := make([]*regexp.Regexp, 0, len(sources))
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %s: %w", source, err)
+		}
+		result = append(result, pattern)
+	}
+	return result, nil
+}
+
+// secretConfigValues lists the secrets held by a config value, which may be a JSON object of
+// space separated secrets, as for API_KEYS.
+func secretConfigValues(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var object map[string]string
+	if err := json.Unmarshal([]byte(value), &object); err == nil {
+		result := make([]string, 0)
+		for _, secrets := range object {
+			result = append(result, strings.Fields(secrets)...)
+		}
+		return result
+	}
+	return []string{value}
+}
+
+// RedactionRulesFromConfig adds the configured keys, patterns and secrets to the default rules.
+//
+// Secrets loaded from Vault are only included if they have been loaded by the time this is called.
+func RedactionRulesFromConfig() (RedactionRules, error) {
+	rules := DefaultRedactionRules()
+	rules.Keys = append(rules.Keys, strings.Fields(auconfigenv.Get(ConfLogRedactKeys))...)
+
+	patterns, err := parseRedactPatterns(auconfigenv.Get(ConfLogRedactPatterns))
+	if err != nil {
+		return rules, err
+	}
+	rules.Patterns = append(rules.Patterns, patterns...)
+
+	for _, key := range strings.Fields(auconfigenv.Get(ConfLogRedactSecretConfigKeys)) {
+		rules.Secrets = append(rules.Secrets, secretConfigValues(auconfigenv.Get(key))...)
+	}
+	return rules, nil
+}
+
+// SetupRedaction applies the redaction rules from the configuration.
+//
+// Setup calls this, but call it again once secrets have been loaded from Vault, so they are masked, too.
+func SetupRedaction() error {
+	rules, err := RedactionRulesFromConfig()
+	if err != nil {
+		return err
+	}
+	SetRedactionRules(rules)
+	return nil
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/url"
+)
+
+type tracingClient struct {
+	wrapped aurestclientapi.Client
+	peer    string
+}
+
+// NewClient wraps a client so each request is recorded as a client span, named after peer.
+//
+// Place it directly around the http client, so each retry gets its own span, and have the http client's
+// request manipulator call InjectHeaders, so the called service continues the trace.
+func NewClient(wrapped aurestclientapi.Client, peer string) aurestclientapi.Client {
+	return &tracingClient{
+		wrapped: wrapped,
+		peer:    peer,
+	}
+}
+
+func (c *tracingClient) Perform(ctx context.Context, method string, requestUrl string, requestBody interface{}, response *aurestclientapi.ParsedResponse) error {
+	attributes := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.String("peer.service", c.peer),
+	}
+	if parsed, err := url.Parse(requestUrl); err == nil {
+		// leave out the query, it may contain secrets
+		attributes = append(attributes, attribute.String("server.address", parsed.Hostname()), attribute.String("url.path", parsed.Path))
+	}
+
+	ctx, span := Tracer().Start(ctx, c.peer+" "+method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	defer span.End()
+
+	err := c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", response.Status))
+	if response.Status >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(response.Status))
+	}
+	return nil
+}
+
+// RequestIDHeader is the header the request id is forwarded in, the same one it is received in.
+const RequestIDHeader = "X-Request-Id"
+
+// InjectHeaders adds the trace context and request id from ctx to an outgoing request. Call it from request manipulators.
+func InjectHeaders(ctx context.Context, r *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	// not common.GetRequestID, which falls back to a placeholder
+	if requestID, ok := ctx.Value(common.CtxKeyRequestID{}).(string); ok {
+		r.Header.Set(RequestIDHeader, requestID)
+	}
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func tstRecordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+	return recorder
+}
+
+func TestClient(t *testing.T) {
+	recorder := tstRecordSpans(t)
+
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	httpClient, err := auresthttpclient.New(0, nil, InjectHeaders)
+	require.NoError(t, err)
+	cut := NewClient(httpClient, "tst")
+
+	ctx := context.WithValue(context.Background(), common.CtxKeyRequestID{}, "0af7651916cd43dd8448eb211c80319c")
+	ctx, parent := Tracer().Start(ctx, "tst-parent")
+	response := aurestclientapi.ParsedResponse{}
+	require.NoError(t, cut.Perform(ctx, http.MethodGet, server.URL+"/some/path?secret=1", nil, &response))
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	client := spans[0]
+	require.Equal(t, "tst GET", client.Name())
+	require.Equal(t, trace.SpanKindClient, client.SpanKind())
+	require.Equal(t, parent.SpanContext().SpanID(), client.Parent().SpanID())
+	require.Equal(t, "Error", client.Status().Code.String())
+
+	header := <-received
+	require.Equal(t, "00-"+parent.SpanContext().TraceID().String()+"-"+client.SpanContext().SpanID().String()+"-01", header.Get("traceparent"),
+		"the called service must continue the trace below the client span")
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", header.Get(RequestIDHeader), "the request id must be forwarded")
+
+	for _, attr := range client.Attributes() {
+		require.NotContains(t, attr.Value.Emit(), "secret", "query parameters must not be recorded")
+	}
+}
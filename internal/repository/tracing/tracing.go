@@ -0,0 +1,203 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	auconfigapi "github.com/StephanHCB/go-autumn-config-api"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// TracerName identifies the spans created by this service, as opposed to those created by libraries.
+const TracerName = "github.com/eurofurence/reg-backend-template-test"
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type Options struct {
+	// Exporter is one of the Exporter... constants.
+	Exporter string
+
+	// OTLPEndpoint is the base URL of the OTLP/HTTP collector, such as http://localhost:4318.
+	// If blank, the standard OTEL_EXPORTER_OTLP_... environment variables apply.
+	OTLPEndpoint string
+
+	// FilePath is the file the file exporter appends spans to.
+	FilePath string
+
+	ServiceName string
+
+	// SampleRatio is the fraction of new traces that are recorded. Traces started by callers follow their decision.
+	SampleRatio float64
+}
+
+var (
+	provider      *sdktrace.TracerProvider
+	providerMutex sync.Mutex
+)
+
+// Setup configures the global tracer provider and the W3C trace context propagator.
+//
+// The propagator is set up even if no exporter is configured, so trace context received from
+// callers is still passed on to the services we call.
+//
+// Calling Setup again replaces the previous configuration, flushing any spans it still holds.
+func Setup(ctx context.Context, options Options) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if err := Shutdown(ctx); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to flush spans of previous tracing setup: %s", err.Error())
+	}
+
+	exporter, err := newExporter(ctx, options)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to set up tracing: %s", err.Error())
+		return err
+	}
+	if exporter == nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return nil
+	}
+
+	providerMutex.Lock()
+	defer providerMutex.Unlock()
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", options.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("tracing set up with %s exporter", options.Exporter)
+	return nil
+}
+
+// Shutdown flushes all spans not yet exported. Tracing is off afterwards.
+func Shutdown(ctx context.Context) error {
+	providerMutex.Lock()
+	defer providerMutex.Unlock()
+
+	if provider == nil {
+		return nil
+	}
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	err := provider.Shutdown(ctx)
+	provider = nil
+	return err
+}
+
+func newExporter(ctx context.Context, options Options) (sdktrace.SpanExporter, error) {
+	switch options.Exporter {
+	case ExporterNone, "":
+		return nil, nil
+	case ExporterOTLP:
+		var exporterOptions []otlptracehttp.Option
+		if options.OTLPEndpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracehttp.WithEndpointURL(options.OTLPEndpoint))
+		}
+		return otlptracehttp.New(ctx, exporterOptions...)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		file, err := os.OpenFile(options.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tracing file %s: %w", options.FilePath, err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return &closingExporter{SpanExporter: exporter, closer: file}, nil
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %s, must be one of %s (default if blank), %s, %s, %s", options.Exporter, ExporterNone, ExporterOTLP, ExporterStdout, ExporterFile)
+	}
+}
+
+// closingExporter closes the file written by the wrapped exporter on shutdown.
+type closingExporter struct {
+	sdktrace.SpanExporter
+	closer io.Closer
+}
+
+func (e *closingExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.closer.Close())
+}
+
+// Tracer creates the spans of this service. It uses whatever provider Setup configured last.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+const (
+	ConfTracingExporter     = "TRACING_EXPORTER"
+	ConfTracingOTLPEndpoint = "TRACING_OTLP_ENDPOINT"
+	ConfTracingFile         = "TRACING_FILE"
+	ConfTracingServiceName  = "TRACING_SERVICE_NAME"
+	ConfTracingSamplePct    = "TRACING_SAMPLE_PERCENT"
+)
+
+func ConfigItems() []auconfigapi.ConfigItem {
+	return []auconfigapi.ConfigItem{
+		{
+			Key:         ConfTracingExporter,
+			Default:     ExporterNone,
+			Description: "where to send OpenTelemetry traces. One of none (default), otlp (OTLP over HTTP), stdout, file. Trace context is passed on to called services in any case.",
+			Validate:    auconfigenv.ObtainPatternValidator("^(|none|otlp|stdout|file)$"),
+		}, {
+			Key:         ConfTracingOTLPEndpoint,
+			Default:     "",
+			Description: "base URL of the OTLP/HTTP collector, such as 'http://localhost:4318'. If blank, the standard OTEL_EXPORTER_OTLP_... environment variables apply.",
+			Validate:    auconfigenv.ObtainPatternValidator("^(|https?://.*)$"),
+		}, {
+			Key:         ConfTracingFile,
+			Default:     "traces.jsonl",
+			Description: "file to append spans to if " + ConfTracingExporter + " is file.",
+			Validate:    auconfigapi.ConfigNeedsNoValidation,
+		}, {
+			Key:         ConfTracingServiceName,
+			Default:     "reg-backend-template-test",
+			Description: "service name reported with all spans.",
+			Validate:    auconfigenv.ObtainPatternValidator("^[a-zA-Z0-9._-]+$"),
+		}, {
+			Key:         ConfTracingSamplePct,
+			Default:     "100",
+			Description: "percentage of new traces to record. Traces started by callers follow their sampling decision.",
+			Validate:    auconfigenv.ObtainUintRangeValidator(0, 100),
+		},
+	}
+}
+
+func OptionsFromConfig() Options {
+	samplePercent, err := strconv.Atoi(auconfigenv.Get(ConfTracingSamplePct))
+	if err != nil {
+		// config was validated so should only happen in tests, but use sensible value
+		samplePercent = 100
+	}
+	return Options{
+		Exporter:     auconfigenv.Get(ConfTracingExporter),
+		OTLPEndpoint: auconfigenv.Get(ConfTracingOTLPEndpoint),
+		FilePath:     auconfigenv.Get(ConfTracingFile),
+		ServiceName:  auconfigenv.Get(ConfTracingServiceName),
+		SampleRatio:  float64(samplePercent) / 100,
+	}
+}
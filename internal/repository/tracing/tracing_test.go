@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetup_File(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	require.NoError(t, Setup(ctx, Options{Exporter: ExporterFile, FilePath: path, ServiceName: "tst-service", SampleRatio: 1}))
+
+	_, span := Tracer().Start(ctx, "tst-span")
+	span.End()
+	require.NoError(t, Shutdown(ctx))
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), `"Name":"tst-span"`)
+	require.Contains(t, string(contents), `"Value":"tst-service"`)
+	require.Contains(t, string(contents), span.SpanContext().TraceID().String())
+}
+
+func TestSetup_None(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, Setup(ctx, Options{Exporter: ExporterNone}))
+
+	_, span := Tracer().Start(ctx, "tst-span")
+	require.False(t, span.IsRecording())
+	span.End()
+	require.NoError(t, Shutdown(ctx))
+}
+
+func TestSetup_Invalid(t *testing.T) {
+	ctx := context.Background()
+	require.EqualError(t, Setup(ctx, Options{Exporter: "jaeger"}), "invalid tracing exporter jaeger, must be one of none (default if blank), otlp, stdout, file")
+	require.ErrorContains(t, Setup(ctx, Options{Exporter: ExporterFile, FilePath: filepath.Join(t.TempDir(), "missing", "traces.jsonl")}), "failed to open tracing file")
+}
@@ -12,6 +12,7 @@ import (
 	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
 	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
 	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
 	"github.com/go-http-utils/headers"
 	"net/http"
 	"os"
@@ -158,7 +159,7 @@ func (v *Impl) Setup(ctx context.Context) error {
 	}
 	aurestclientprometheus.InstrumentHttpClient(client)
 
-	logWrapper := aurestlogging.New(client)
+	logWrapper := aurestlogging.New(tracing.NewClient(client, "vault"))
 
 	v.VaultClient = logWrapper
 	return nil
@@ -166,6 +167,7 @@ func (v *Impl) Setup(ctx context.Context) error {
 
 func (v *Impl) vaultRequestHeaderManipulator() func(ctx context.Context, r *http.Request) {
 	return func(ctx context.Context, r *http.Request) {
+		tracing.InjectHeaders(ctx, r)
 		r.Header.Set(headers.Accept, aurestclientapi.ContentTypeApplicationJson)
 		if v.VaultAuthToken != "" {
 			r.Header.Set("X-Vault-Token", v.VaultAuthToken)
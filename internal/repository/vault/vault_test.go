@@ -155,3 +155,19 @@ func Test_appendSecretToMap(t *testing.T) {
 		})
 	}
 }
+
+func TestImpl_ObtainSecretsAddsToExistingMap(t *testing.T) {
+	cut := setupTest()
+	auconfigenv.Set(mapKey, `{"configured": "value0"}`)
+
+	assert.NoError(t, cut.ObtainSecrets(context.Background()))
+
+	secretMap := map[string]string{}
+	if assert.NoError(t, json.Unmarshal([]byte(auconfigenv.Get(mapKey)), &secretMap)) {
+		assert.Equal(t, map[string]string{
+			"configured": "value0",
+			key2:         testValues[key2],
+			key3:         testValues[key3],
+		}, secretMap)
+	}
+}
@@ -2,42 +2,111 @@ package example
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	apierrors "github.com/eurofurence/reg-backend-template-test/internal/application/common"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/events"
 	"net/url"
 )
 
+// DefaultCategory is used if no category is given.
+const DefaultCategory = "default"
+
+// MaxCategoryLength matches the size of the category column in the database.
+const MaxCategoryLength = 64
+
+// initialValue is the value of a category before it is first set.
+const initialValue = 100
+
 // Example is a really dumb example for some business logic.
+//
+// It is safe for concurrent use. Each category counts separately, and no two callers ever obtain the same value
+// for a category, unless it is set back in between.
 type Example interface {
-	ObtainNextValue(ctx context.Context, minValue int64) (int64, error)
-	ProvideStartValue(ctx context.Context, value int64) error
+	ObtainNextValue(ctx context.Context, category string, minValue int64) (int64, error)
+	ProvideStartValue(ctx context.Context, category string, value int64) error
+}
+
+// ValueSet is published when the value of a category is set.
+type ValueSet struct {
+	Category string `json:"category"`
+	Value    int64  `json:"value"`
 }
 
-func New() Example {
+func (e ValueSet) EventType() string {
+	return "example.value.set"
+}
+
+func New(db database.Repository, publisher events.Publisher) Example {
 	return &impl{
-		value: 100,
+		db:        db,
+		publisher: publisher,
 	}
 }
 
+// impl keeps no state of its own, all values live in the database.
 type impl struct {
-	value int64
+	db        database.Repository
+	publisher events.Publisher
 }
 
-func (i *impl) ObtainNextValue(ctx context.Context, minValue int64) (int64, error) {
+func (i *impl) ObtainNextValue(ctx context.Context, category string, minValue int64) (int64, error) {
 	aulogging.Info(ctx, "obtaining next value")
 
-	i.value++
-	if i.value < minValue {
+	if err := validateCategory(ctx, category); err != nil {
+		return 0, err
+	}
+
+	var value int64
+	// read and increment in one transaction, so concurrent requests never get the same value
+	err := i.db.RunInTransaction(ctx, func(ctx context.Context) error {
+		current, err := i.db.GetExampleValue(ctx, category)
+		if errors.Is(err, database.ErrNotFound) {
+			current = initialValue
+		} else if err != nil {
+			return err
+		}
+
+		value = current + 1
+		return i.db.SetExampleValue(ctx, category, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// the value is used up even if it is too low
+	if value < minValue {
 		return 0, apierrors.NewConflict(ctx, apierrors.ValueTooLow, url.Values{"minimum": []string{"the current value is too low"}})
 	}
 
-	return i.value, nil
+	return value, nil
 }
 
-func (i *impl) ProvideStartValue(ctx context.Context, value int64) error {
+func (i *impl) ProvideStartValue(ctx context.Context, category string, value int64) error {
+	if err := validateCategory(ctx, category); err != nil {
+		return err
+	}
 	if value > 100 {
 		return apierrors.NewBadRequest(ctx, apierrors.ValueTooHigh, url.Values{"details": []string{"the value must be less than 100"}})
 	}
-	i.value = value
+
+	// the event is only published if the value is stored, and vice versa
+	return i.db.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := i.db.SetExampleValue(ctx, category, value); err != nil {
+			return err
+		}
+		return i.publisher.Publish(ctx, ValueSet{
+			Category: category,
+			Value:    value,
+		})
+	})
+}
+
+func validateCategory(ctx context.Context, category string) error {
+	if category == "" || len(category) > MaxCategoryLength {
+		return apierrors.NewBadRequest(ctx, apierrors.CategoryInvalid, url.Values{"details": []string{fmt.Sprintf("the category must be 1 to %d characters long", MaxCategoryLength)}})
+	}
 	return nil
 }
@@ -0,0 +1,71 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// ---------------------------------------------------
+// acceptance tests for authentication using api keys
+// ---------------------------------------------------
+
+func TestApiKey_Success(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an api key that may only read the example resource")
+	token := tstApiKey("reader-key")
+
+	docs.When("when it requests the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then a valid response is sent with the next value")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
+}
+
+func TestApiKey_SetSuccessDuringRotation(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an api key in the admin group that is being rotated")
+	for _, token := range []string{tstApiKey("backend-key-old"), tstApiKey("backend-key-new")} {
+		docs.When("when it sets the example resource using either the old or the new key")
+		response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+
+		docs.Then("then the request is successful")
+		require.Equal(t, http.StatusNoContent, response.status)
+	}
+}
+
+// security tests
+
+func TestApiKey_DenyUnknownKey(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a caller with an api key that is not configured")
+	token := tstApiKey("backend-key")
+
+	docs.When("when they request the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid api token")
+}
+
+func TestApiKey_SetDenyRouteNotAllowed(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an api key that may only read the example resource")
+	token := tstApiKey("reader-key")
+
+	docs.When("when it attempts to set the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+
+	docs.Then("then the request is denied as forbidden (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "api key not authorized for this operation")
+}
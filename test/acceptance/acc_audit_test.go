@@ -0,0 +1,64 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// ---------------------------------------------
+// acceptance tests for auditing of all requests
+// ---------------------------------------------
+
+func TestAudit_RequestsSuccess(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfAuditRequests: "1",
+	})
+	defer tstShutdown()
+
+	docs.Given("given auditing of all requests is enabled")
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they read and then set the example resource")
+	tstPerformGet("/api/rest/v1/example", token)
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+	require.Equal(t, http.StatusNoContent, response.status)
+
+	docs.Then("then only the change is audited, both by the controller and for the request")
+	events := auditEvents.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, "example.set", events[0].Action)
+	require.Equal(t, "POST /api/rest/v1/example/{category}", events[1].Action)
+	require.Equal(t, "/api/rest/v1/example/cat", events[1].Resource)
+	require.Equal(t, audit.OutcomeSuccess, events[1].Outcome)
+	require.Equal(t, "101", events[1].Subject)
+	require.Equal(t, response.header.Get("X-Request-Id"), events[1].RequestID)
+}
+
+func TestAudit_RequestsDenied(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfAuditRequests: "1",
+	})
+	defer tstShutdown()
+
+	docs.Given("given auditing of all requests is enabled")
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"staff"})
+
+	docs.When("when they attempt to set the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+	require.Equal(t, http.StatusForbidden, response.status)
+
+	docs.Then("then the attempt is audited as denied")
+	events := auditEvents.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, audit.OutcomeDenied, events[0].Outcome)
+	require.Equal(t, "101", events[0].Subject)
+}
@@ -0,0 +1,165 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// --------------------------------------------------------------------
+// acceptance tests for cookie based authentication (browser frontends)
+// --------------------------------------------------------------------
+
+const (
+	tstAccessTokenCookie = "JWT"
+	tstIDTokenCookie     = "AUTH"
+	tstIDTokenAudience   = "tst-frontend"
+)
+
+func tstSetupWithCookies(t *testing.T) {
+	t.Helper()
+
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfOIDCAccessTokenCookieName: tstAccessTokenCookie,
+		middleware.ConfOIDCIDTokenCookieName:     tstIDTokenCookie,
+		middleware.ConfOIDCIDTokenAudiences:      tstIDTokenAudience,
+	})
+}
+
+func TestCookies_Success(t *testing.T) {
+	tstSetupWithCookies(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in regular user that sends both tokens as cookies only")
+	tstSetupIDPResponse(t, 101, nil)
+	cookies := map[string]string{
+		tstAccessTokenCookie: tstValidUserToken(t, 101),
+		tstIDTokenCookie:     tstSignedIDToken(t, 101),
+	}
+
+	docs.When("when they request the example resource")
+	response := tstPerformGetWithCookies("/api/rest/v1/example", cookies)
+
+	docs.Then("then a valid response is sent with the next value")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
+}
+
+func TestCookies_SuccessAccessTokenOnly(t *testing.T) {
+	tstSetupWithCookies(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in regular user that sends only the access token cookie")
+	tstSetupIDPResponse(t, 101, nil)
+	cookies := map[string]string{
+		tstAccessTokenCookie: tstValidUserToken(t, 101),
+	}
+
+	docs.When("when they request the example resource")
+	response := tstPerformGetWithCookies("/api/rest/v1/example", cookies)
+
+	docs.Then("then a valid response is sent with the next value")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
+}
+
+func TestCookies_SuccessScopesAndGroups(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfOIDCAccessTokenCookieName: tstAccessTokenCookie,
+		middleware.ConfOIDCIDTokenCookieName:     tstIDTokenCookie,
+		middleware.ConfOIDCIDTokenAudiences:      tstIDTokenAudience,
+		middleware.ConfOIDCRequiredScopes:        "groups",
+	})
+	defer tstShutdown()
+
+	docs.Given("given a route that requires a scope and a group")
+	tstRouter.With(web.RequireScopes("fun"), web.RequireGroups("staff")).Get("/api/rest/v1/scoped", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	docs.Given("given a logged in staff user whose access token has the scope, and who sends both tokens as cookies only")
+	tstSetupIDPResponse(t, 101, []string{"staff"})
+	cookies := map[string]string{
+		tstAccessTokenCookie: tstValidUserToken(t, 101),
+		tstIDTokenCookie:     tstSignedIDToken(t, 101),
+	}
+
+	docs.When("when they request the route")
+	response := tstPerformGetWithCookies("/api/rest/v1/scoped", cookies)
+
+	docs.Then("then the request is allowed")
+	require.Equal(t, http.StatusNoContent, response.status)
+}
+
+// security tests
+
+func TestCookies_DenySubjectMismatch(t *testing.T) {
+	tstSetupWithCookies(t)
+	defer tstShutdown()
+
+	docs.Given("given a user whose id token cookie belongs to a different user than their access token cookie")
+	tstSetupIDPResponse(t, 101, nil)
+	cookies := map[string]string{
+		tstAccessTokenCookie: tstValidUserToken(t, 101),
+		tstIDTokenCookie:     tstSignedIDToken(t, 202),
+	}
+
+	docs.When("when they request the example resource")
+	response := tstPerformGetWithCookies("/api/rest/v1/example", cookies)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid id token")
+}
+
+func TestCookies_DenyForgedIDToken(t *testing.T) {
+	tstSetupWithCookies(t)
+	defer tstShutdown()
+
+	docs.Given("given a user that sends an id token cookie that was not signed by the identity provider")
+	tstSetupIDPResponse(t, 101, nil)
+	cookies := map[string]string{
+		tstAccessTokenCookie: tstValidUserToken(t, 101),
+		tstIDTokenCookie:     "eyJhbGciOiJub25lIn0.eyJzdWIiOiIxMDEifQ.",
+	}
+
+	docs.When("when they request the example resource")
+	response := tstPerformGetWithCookies("/api/rest/v1/example", cookies)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid id token")
+}
+
+func TestCookies_DenyUnknownAccessToken(t *testing.T) {
+	tstSetupWithCookies(t)
+	defer tstShutdown()
+
+	docs.Given("given a user that sends an access token cookie the identity provider does not know")
+	cookies := map[string]string{
+		tstAccessTokenCookie: tstValidUserToken(t, 101),
+		tstIDTokenCookie:     tstSignedIDToken(t, 101),
+	}
+
+	docs.When("when they request the example resource")
+	response := tstPerformGetWithCookies("/api/rest/v1/example", cookies)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid bearer token")
+}
+
+func TestCookies_DenyIDTokenOnly(t *testing.T) {
+	tstSetupWithCookies(t)
+	defer tstShutdown()
+
+	docs.Given("given a user that sends only a valid id token cookie")
+	cookies := map[string]string{
+		tstIDTokenCookie: tstSignedIDToken(t, 101),
+	}
+
+	docs.When("when they request the example resource")
+	response := tstPerformGetWithCookies("/api/rest/v1/example", cookies)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
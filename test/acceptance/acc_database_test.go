@@ -0,0 +1,93 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/database"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// -------------------------------------------
+// acceptance tests for the sqlite persistence
+// -------------------------------------------
+
+func tstSQLiteConfig(t *testing.T) map[string]string {
+	return map[string]string{
+		database.ConfDatabaseType: database.TypeSQLite,
+		database.ConfDatabaseDSN:  "file:" + filepath.Join(t.TempDir(), "acceptance.db") + "?_pragma=busy_timeout(5000)",
+	}
+}
+
+func tstRequireNextExampleValue(t *testing.T, category string, token string, expected int64) {
+	t.Helper()
+
+	response := tstPerformGet("/api/rest/v1/example?category="+category, token)
+	actual := apimodel.Example{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, expected, actual.Value)
+}
+
+func TestDatabase_PersistsAcrossRestart(t *testing.T) {
+	config := tstSQLiteConfig(t)
+	tstSetupWithConfig(t, config)
+
+	docs.Given("given the service stores its data in sqlite")
+	docs.Given("given a backend service with an api key")
+	token := tstApiKey("reader-key")
+
+	docs.Given("given it has obtained values for a category")
+	tstRequireNextExampleValue(t, "cat", token, 101)
+	tstRequireNextExampleValue(t, "cat", token, 102)
+
+	docs.When("when the service is restarted")
+	tstShutdown()
+	tstSetupWithConfig(t, config)
+	defer tstShutdown()
+
+	docs.Then("then the values continue where they left off")
+	tstRequireNextExampleValue(t, "cat", token, 103)
+	tstRequireNextExampleValue(t, "dog", token, 101)
+}
+
+func TestDatabase_ConcurrentSuccess(t *testing.T) {
+	tstSetupWithConfig(t, tstSQLiteConfig(t))
+	defer tstShutdown()
+
+	docs.Given("given the service stores its data in sqlite")
+	tstRequireConcurrentExampleRequests(t)
+}
+
+func TestDatabase_HealthOK(t *testing.T) {
+	tstSetupWithConfig(t, tstSQLiteConfig(t))
+	defer tstShutdown()
+
+	docs.Given("given the service stores its data in sqlite")
+
+	docs.When("when the health endpoint is requested")
+	response := tstPerformGet("/", tstNoToken())
+
+	docs.Then("then the service reports it is healthy")
+	actual := apimodel.Health{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, "OK", actual.Status)
+}
+
+func TestDatabase_HealthUnavailable(t *testing.T) {
+	tstSetupWithConfig(t, tstSQLiteConfig(t))
+	defer tstShutdown()
+
+	docs.Given("given the service stores its data in sqlite")
+	docs.Given("given the database cannot be reached")
+	require.NoError(t, application.Database.Close())
+
+	docs.When("when the health endpoint is requested")
+	response := tstPerformGet("/", tstNoToken())
+
+	docs.Then("then the service reports the database is unavailable")
+	actual := apimodel.Health{}
+	tstRequireSuccessResponse(t, response, http.StatusServiceUnavailable, &actual)
+	require.Equal(t, "DATABASE_UNAVAILABLE", actual.Status)
+}
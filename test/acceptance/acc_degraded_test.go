@@ -0,0 +1,101 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
+	"github.com/eurofurence/reg-backend-template-test/test/mocks/idpmock"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ------------------------------------------------------------------------
+// acceptance tests for starting while the identity provider is unreachable
+// ------------------------------------------------------------------------
+
+func tstSetupUnreachableIDP(t *testing.T) idp.IdentityProviderClient {
+	t.Helper()
+
+	originalBackoff := idp.DiscoveryInitialBackoff
+	idp.DiscoveryInitialBackoff = 10 * time.Millisecond
+	t.Cleanup(func() {
+		idp.DiscoveryInitialBackoff = originalBackoff
+	})
+
+	idpClient := idpmock.New()
+	idpmock.SetDiscoveryFailing(idpClient, true)
+	tstSetupWithIDP(t, map[string]string{
+		idp.ConfIDPDegradedStartup: "1",
+	}, idpClient)
+	return idpClient
+}
+
+func TestDegraded_OpenEndpointSuccess(t *testing.T) {
+	tstSetupUnreachableIDP(t)
+	defer tstShutdown()
+
+	docs.Given("given the service was started while the identity provider is unreachable")
+
+	docs.When("when the health endpoint is requested")
+	response := tstPerformGet("/", tstNoToken())
+
+	docs.Then("then a valid response is sent")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Health{})
+}
+
+func TestDegraded_ApiKeySuccess(t *testing.T) {
+	tstSetupUnreachableIDP(t)
+	defer tstShutdown()
+
+	docs.Given("given the service was started while the identity provider is unreachable")
+	docs.Given("given a backend service with an api key")
+	token := tstApiKey("reader-key")
+
+	docs.When("when it requests the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then a valid response is sent with the next value")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
+}
+
+func TestDegraded_RecoversWhenIDPReachable(t *testing.T) {
+	idpClient := tstSetupUnreachableIDP(t)
+	defer tstShutdown()
+
+	docs.Given("given the service was started while the identity provider is unreachable")
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, nil)
+
+	docs.When("when the identity provider becomes reachable")
+	idpmock.SetDiscoveryFailing(idpClient, false)
+	require.Eventually(t, func() bool {
+		return idpClient.Issuer() != ""
+	}, 5*time.Second, 10*time.Millisecond)
+
+	docs.When("and the user requests the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then a valid response is sent with the next value")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
+}
+
+// security tests
+
+func TestDegraded_DenyUntilDiscovered(t *testing.T) {
+	tstSetupUnreachableIDP(t)
+	defer tstShutdown()
+
+	docs.Given("given the service was started while the identity provider is unreachable")
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, nil)
+
+	docs.When("when they request the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then the request is denied as temporarily unavailable (503)")
+	tstRequireErrorResponse(t, response, http.StatusServiceUnavailable, "auth.unavailable", "identity provider not available yet, please try again later")
+}
@@ -0,0 +1,269 @@
+package acceptance
+
+import (
+	"encoding/json"
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/events"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ---------------------------------------------
+// acceptance tests for domain event publishing
+// ---------------------------------------------
+
+// tstEventSubscriber answers with status, and records the events it receives.
+type tstEventSubscriber struct {
+	server   *httptest.Server
+	status   int
+	received []events.Envelope
+	mutex    sync.Mutex
+}
+
+func tstSetupWithEventSubscriber(t *testing.T, configOverrides map[string]string) *tstEventSubscriber {
+	t.Helper()
+
+	subscriber := &tstEventSubscriber{status: http.StatusNoContent}
+	subscriber.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		envelope := events.Envelope{}
+		_ = json.Unmarshal(body, &envelope)
+
+		subscriber.mutex.Lock()
+		defer subscriber.mutex.Unlock()
+		subscriber.received = append(subscriber.received, envelope)
+		w.WriteHeader(subscriber.status)
+	}))
+	t.Cleanup(subscriber.server.Close)
+
+	config := map[string]string{
+		events.ConfEventsSubscribers: tstRenderJson(map[string]events.Subscriber{
+			"mail": {URL: subscriber.server.URL, Types: []string{"example.value.set"}},
+		}),
+		events.ConfEventsDispatchIntervalMillis: "20",
+	}
+	for key, value := range configOverrides {
+		config[key] = value
+	}
+	tstSetupWithConfig(t, config)
+	return subscriber
+}
+
+func (s *tstEventSubscriber) setStatus(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = status
+}
+
+func (s *tstEventSubscriber) events() []events.Envelope {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]events.Envelope{}, s.received...)
+}
+
+func tstListEvents(t *testing.T, query string, token string) []apimodel.OutboxEvent {
+	t.Helper()
+
+	response := tstPerformGet("/api/rest/v1/admin/events"+query, token)
+	actual := apimodel.OutboxEventList{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	return actual.Events
+}
+
+func TestEvents_DeliverySuccess(t *testing.T) {
+	subscriber := tstSetupWithEventSubscriber(t, nil)
+	defer tstShutdown()
+
+	docs.Given("given a subscriber for example events")
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it sets the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+	require.Equal(t, http.StatusNoContent, response.status)
+
+	docs.Then("then the subscriber receives the event")
+	require.Eventually(t, func() bool {
+		return len(subscriber.events()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	envelope := subscriber.events()[0]
+	require.Equal(t, "example.value.set", envelope.Type)
+	require.Equal(t, response.header.Get("X-Request-Id"), envelope.RequestID)
+	require.JSONEq(t, `{"category":"cat","value":42}`, string(envelope.Data))
+
+	docs.Then("and the event is listed as delivered")
+	require.Eventually(t, func() bool {
+		return len(tstListEvents(t, "?status=delivered", token)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	delivered := tstListEvents(t, "?status=delivered", token)
+	require.Equal(t, envelope.ID, delivered[0].EventId)
+	require.Equal(t, "mail", delivered[0].Subscriber)
+	require.EqualValues(t, 1, delivered[0].Attempts)
+	require.Equal(t, map[string]interface{}{"category": "cat", "value": float64(42)}, delivered[0].Payload["data"])
+}
+
+func TestEvents_DeadLetterAndReplaySuccess(t *testing.T) {
+	subscriber := tstSetupWithEventSubscriber(t, map[string]string{
+		events.ConfEventsMaxAttempts:               "2",
+		events.ConfEventsRetryInitialBackoffMillis: "10",
+	})
+	defer tstShutdown()
+
+	docs.Given("given a subscriber for example events that is failing")
+	subscriber.setStatus(http.StatusServiceUnavailable)
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.Given("given an event that could not be delivered")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+	require.Equal(t, http.StatusNoContent, response.status)
+	require.Eventually(t, func() bool {
+		return len(tstListEvents(t, "?status=dead", token)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	dead := tstListEvents(t, "?status=dead", token)[0]
+	require.EqualValues(t, 2, dead.Attempts)
+	require.Equal(t, "subscriber responded with status 503", dead.LastError)
+	require.Len(t, subscriber.events(), 2)
+
+	docs.When("when the subscriber has recovered and the event is replayed")
+	subscriber.setStatus(http.StatusNoContent)
+	response = tstPerformPostNoBody("/api/rest/v1/admin/events/"+dead.Id+"/replay", token)
+
+	docs.Then("then the event is pending again")
+	replayed := apimodel.OutboxEvent{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &replayed)
+	require.Equal(t, "pending", replayed.Status)
+	require.EqualValues(t, 0, replayed.Attempts)
+
+	docs.Then("and the subscriber receives it")
+	require.Eventually(t, func() bool {
+		return len(tstListEvents(t, "?status=delivered", token)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, subscriber.events(), 3)
+
+	docs.Then("and the replay is audited")
+	auditActions := make([]string, 0)
+	for _, event := range auditEvents.Events() {
+		auditActions = append(auditActions, event.Action)
+	}
+	require.Contains(t, auditActions, "event.replay")
+}
+
+func TestEvents_NotPublishedOnFailure(t *testing.T) {
+	subscriber := tstSetupWithEventSubscriber(t, nil)
+	defer tstShutdown()
+
+	docs.Given("given a subscriber for example events")
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it fails to set the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 101}), token)
+	require.Equal(t, http.StatusBadRequest, response.status)
+
+	docs.Then("then no event is published")
+	require.Empty(t, tstListEvents(t, "", token))
+	require.Never(t, func() bool {
+		return len(subscriber.events()) > 0
+	}, 100*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestEvents_ListInvalid(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it lists events with an invalid status")
+	response := tstPerformGet("/api/rest/v1/admin/events?status=lost", token)
+
+	docs.Then("then the request fails as a bad request (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "request.parse.failed", url.Values{"status": []string{"must be one of pending, delivered, dead"}})
+
+	docs.When("when it lists events with an invalid limit")
+	response = tstPerformGet("/api/rest/v1/admin/events?limit=0", token)
+
+	docs.Then("then the request fails as a bad request (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "request.parse.failed", url.Values{"limit": []string{"must be between 1 and 1000"}})
+}
+
+func TestEvents_ReplayNotFound(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it replays an event that does not exist")
+	response := tstPerformPostNoBody("/api/rest/v1/admin/events/unknown/replay", token)
+
+	docs.Then("then the request fails as not found (404)")
+	tstRequireErrorResponse(t, response, http.StatusNotFound, "event.notfound", url.Values{"id": []string{"no event with this id"}})
+}
+
+func TestEvents_ReplayNotDead(t *testing.T) {
+	subscriber := tstSetupWithEventSubscriber(t, nil)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.Given("given an event that has been delivered")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+	require.Equal(t, http.StatusNoContent, response.status)
+	require.Eventually(t, func() bool {
+		return len(tstListEvents(t, "?status=delivered", token)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, subscriber.events(), 1)
+	delivered := tstListEvents(t, "", token)[0]
+
+	docs.When("when it replays the event")
+	response = tstPerformPostNoBody("/api/rest/v1/admin/events/"+delivered.Id+"/replay", token)
+
+	docs.Then("then the request fails as a conflict (409)")
+	tstRequireErrorResponse(t, response, http.StatusConflict, "event.not.dead", url.Values{"id": []string{"only dead events can be replayed"}})
+}
+
+// security tests
+
+func TestEvents_DenyRegularUser(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"staff"})
+
+	docs.When("when they attempt to list events")
+	response := tstPerformGet("/api/rest/v1/admin/events", token)
+
+	docs.Then("then the request is denied as forbidden (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.When("when they attempt to replay an event")
+	response = tstPerformPostNoBody("/api/rest/v1/admin/events/unknown/replay", token)
+
+	docs.Then("then the request is denied as forbidden (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
+
+func TestEvents_DenyUnauthorized(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous user")
+
+	docs.When("when they attempt to list events")
+	response := tstPerformGet("/api/rest/v1/admin/events", tstNoToken())
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
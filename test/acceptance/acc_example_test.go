@@ -3,8 +3,16 @@ package acceptance
 import (
 	"github.com/eurofurence/reg-backend-template-test/docs"
 	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
+	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // ------------------------------------------
@@ -26,6 +34,229 @@ func TestExample_Success(t *testing.T) {
 	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
 }
 
+func TestExample_SuccessLocalTokenValidation(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfOIDCTokenValidation: middleware.TokenValidationJWKS,
+	})
+	defer tstShutdown()
+
+	docs.Given("given local token validation is configured")
+	docs.Given("given a logged in regular user with a signed access token")
+	token := tstSignedUserToken(t, 101, nil, time.Hour)
+
+	docs.When("when they request the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then a valid response is sent with the next value")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{Value: 42})
+}
+
+func TestExample_SetSuccess(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they set the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+
+	docs.Then("then the request is successful")
+	require.Equal(t, http.StatusNoContent, response.status)
+
+	docs.Then("and the change is audited")
+	events := auditEvents.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, "example.set", events[0].Action)
+	require.Equal(t, "example/cat", events[0].Resource)
+	require.Equal(t, audit.OutcomeSuccess, events[0].Outcome)
+	require.Equal(t, "101", events[0].Subject)
+	require.Equal(t, apimodel.Example{Value: 42}, events[0].After)
+}
+
+func TestExample_CategoriesSuccess(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an api key")
+	token := tstApiKey("reader-key")
+
+	docs.Given("given it has obtained a value for a category")
+	tstRequireNextExampleValue(t, "cat", token, 101)
+
+	docs.When("when it requests values for that and another category")
+	docs.Then("then each category counts separately")
+	tstRequireNextExampleValue(t, "cat", token, 102)
+	tstRequireNextExampleValue(t, "dog", token, 101)
+}
+
+func TestExample_SetThenGetSuccess(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.Given("given it has set the value of a category")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+	require.Equal(t, http.StatusNoContent, response.status)
+
+	docs.When("when it requests values for that and another category")
+	docs.Then("then only that category continues from the value set")
+	tstRequireNextExampleValue(t, "cat", token, 43)
+	tstRequireNextExampleValue(t, "dog", token, 101)
+}
+
+func TestExample_ConcurrentSuccess(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	tstRequireConcurrentExampleRequests(t)
+}
+
+// tstRequireConcurrentExampleRequests obtains values for one category while setting another. Run with -race.
+func tstRequireConcurrentExampleRequests(t *testing.T) {
+	t.Helper()
+
+	docs.Given("given a backend service with an admin api key")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it concurrently requests values for one category and sets another")
+	const gets = 40
+	const sets = 20
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var values []int64
+	var getStatuses, setStatuses []int
+	for i := 0; i < gets; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response := tstPerformGet("/api/rest/v1/example?category=counted", token)
+			actual := apimodel.Example{}
+			tstParseJson(response.body, &actual)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			getStatuses = append(getStatuses, response.status)
+			values = append(values, actual.Value)
+		}()
+	}
+	for i := 0; i < sets; i++ {
+		wg.Add(1)
+		go func(value int64) {
+			defer wg.Done()
+			response := tstPerformPost("/api/rest/v1/example/set", tstRenderJson(apimodel.Example{Value: value}), token)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			setStatuses = append(setStatuses, response.status)
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	docs.Then("then all requests are successful")
+	require.Len(t, getStatuses, gets)
+	for _, status := range getStatuses {
+		require.Equal(t, http.StatusOK, status)
+	}
+	require.Len(t, setStatuses, sets)
+	for _, status := range setStatuses {
+		require.Equal(t, http.StatusNoContent, status)
+	}
+
+	docs.Then("and every request obtained a different value, without gaps")
+	slices.Sort(values)
+	expected := make([]int64, 0, gets)
+	for i := int64(1); i <= gets; i++ {
+		expected = append(expected, 100+i)
+	}
+	require.Equal(t, expected, values)
+
+	docs.Then("and the other category continues from one of the values set")
+	response := tstPerformGet("/api/rest/v1/example?category=set", token)
+	actual := apimodel.Example{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.GreaterOrEqual(t, actual.Value, int64(2))
+	require.LessOrEqual(t, actual.Value, int64(sets+1))
+}
+
+func TestExample_InvalidCategory(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an api key")
+	token := tstApiKey("reader-key")
+
+	docs.When("when it requests the example resource with a category that is too long")
+	response := tstPerformGet("/api/rest/v1/example?category="+strings.Repeat("x", 65), token)
+
+	docs.Then("then the request fails as a bad request (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "category.invalid", "the category must be 1 to 64 characters long")
+}
+
+func TestExample_SetInvalidCategory(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they attempt to set the example resource with a category that is too long")
+	response := tstPerformPost("/api/rest/v1/example/"+strings.Repeat("x", 65), tstRenderJson(apimodel.Example{Value: 42}), token)
+
+	docs.Then("then the request fails as a bad request (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "category.invalid", "the category must be 1 to 64 characters long")
+
+	docs.Then("and the attempt is audited as failed")
+	events := auditEvents.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, "example.set", events[0].Action)
+	require.Equal(t, audit.OutcomeFailure, events[0].Outcome)
+}
+
+func TestExample_SetValueTooHigh(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they attempt to set the example resource to a value that is too high")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 101}), token)
+
+	docs.Then("then the request fails as a bad request (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "value.too.high", "the value must be less than 100")
+
+	docs.Then("and the attempt is audited as failed")
+	events := auditEvents.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, "example/cat", events[0].Resource)
+	require.Equal(t, audit.OutcomeFailure, events[0].Outcome)
+	require.Equal(t, apimodel.Example{Value: 101}, events[0].After)
+
+	docs.Then("and the value is unchanged")
+	tstRequireNextExampleValue(t, "cat", tstApiKey("reader-key"), 101)
+}
+
+func TestExample_SetInvalidBody(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they attempt to set the example resource with an invalid body")
+	response := tstPerformPost("/api/rest/v1/example/cat", `{"value": "high"}`, token)
+
+	docs.Then("then the request fails as a bad request (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "request.parse.failed", url.Values{"request": []string{"request body invalid"}})
+}
+
 // security tests
 
 func TestExample_DenyUnauthorized(t *testing.T) {
@@ -40,3 +271,66 @@ func TestExample_DenyUnauthorized(t *testing.T) {
 	docs.Then("then the request is denied as unauthorized (401)")
 	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
 }
+
+func TestExample_DenyExpiredLocalToken(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfOIDCTokenValidation: middleware.TokenValidationJWKS,
+	})
+	defer tstShutdown()
+
+	docs.Given("given local token validation is configured")
+	docs.Given("given a user with an expired signed access token")
+	token := tstSignedUserToken(t, 101, nil, -time.Minute)
+
+	docs.When("when they request the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid bearer token")
+}
+
+func TestExample_DenyOpaqueTokenWithLocalValidation(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfOIDCTokenValidation: middleware.TokenValidationJWKS,
+	})
+	defer tstShutdown()
+
+	docs.Given("given local token validation is configured")
+	docs.Given("given a user with an opaque access token known to the identity provider")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, nil)
+
+	docs.When("when they request the example resource")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid bearer token")
+}
+
+func TestExample_SetDenyRegularUser(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"staff"})
+
+	docs.When("when they attempt to set the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), token)
+
+	docs.Then("then the request is denied as forbidden (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
+
+func TestExample_SetDenyUnauthorized(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous user")
+
+	docs.When("when they attempt to set the example resource")
+	response := tstPerformPost("/api/rest/v1/example/cat", tstRenderJson(apimodel.Example{Value: 42}), tstNoToken())
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
@@ -0,0 +1,133 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/web"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// -------------------------------------------------
+// acceptance tests for impersonation of other users
+// -------------------------------------------------
+
+func tstSetupWithImpersonation(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfImpersonationGroup: "admin",
+	})
+}
+
+func TestImpersonation_Success(t *testing.T) {
+	tstSetupWithImpersonation(t)
+	defer tstShutdown()
+
+	docs.Given("given impersonation is enabled for the admin group")
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they request their effective roles while impersonating another user")
+	response := tstPerformImpersonating(http.MethodGet, "/api/rest/v1/info/roles", token, "202")
+
+	docs.Then("then the response shows the other user, without the roles of the admin")
+	actual := apimodel.EffectiveRoles{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, apimodel.EffectiveRoles{
+		Subject:     "202",
+		Roles:       []string{},
+		Permissions: []string{},
+	}, actual)
+}
+
+func TestImpersonation_SetDenyAdminOperation(t *testing.T) {
+	tstSetupWithImpersonation(t)
+	defer tstShutdown()
+
+	docs.Given("given impersonation is enabled for the admin group")
+	docs.Given("given a logged in admin impersonating a regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they try to set the example resource")
+	response := tstPerformImpersonating(http.MethodPost, "/api/rest/v1/example/cat", token, "202")
+
+	docs.Then("then the request is denied, because the regular user may not do this (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
+
+// security tests
+
+func TestImpersonation_DenyScopesOfAdmin(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfImpersonationGroup: "admin",
+		middleware.ConfOIDCRequiredScopes: "groups",
+	})
+	defer tstShutdown()
+
+	docs.Given("given impersonation is enabled for the admin group")
+	docs.Given("given a route that requires a scope")
+	tstRouter.With(web.RequireScopes("fun")).Get("/api/rest/v1/scoped", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	docs.Given("given a logged in admin whose access token has the scope")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+	require.Equal(t, http.StatusNoContent, tstPerformGet("/api/rest/v1/scoped", token).status)
+
+	docs.When("when they request the route while impersonating another user")
+	response := tstPerformImpersonating(http.MethodGet, "/api/rest/v1/scoped", token, "202")
+
+	docs.Then("then the request is denied, because the scopes of the admin's token do not carry over (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
+
+func TestImpersonation_DenyRegularUser(t *testing.T) {
+	tstSetupWithImpersonation(t)
+	defer tstShutdown()
+
+	docs.Given("given impersonation is enabled for the admin group")
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"staff"})
+
+	docs.When("when they try to impersonate another user")
+	response := tstPerformImpersonating(http.MethodGet, "/api/rest/v1/info/roles", token, "202")
+
+	docs.Then("then the request is denied (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not allowed to impersonate other users")
+}
+
+func TestImpersonation_DenyApiKey(t *testing.T) {
+	tstSetupWithImpersonation(t)
+	defer tstShutdown()
+
+	docs.Given("given impersonation is enabled for the admin group")
+	docs.Given("given a backend service with an api key in the admin group")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it tries to impersonate a user")
+	response := tstPerformImpersonating(http.MethodGet, "/api/rest/v1/info/roles", token, "202")
+
+	docs.Then("then the request is denied (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you must be logged in to impersonate another user")
+}
+
+func TestImpersonation_DenyByDefault(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given impersonation has not been configured")
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they try to impersonate another user")
+	response := tstPerformImpersonating(http.MethodGet, "/api/rest/v1/info/roles", token, "202")
+
+	docs.Then("then the request is denied (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "impersonation is not enabled")
+}
@@ -0,0 +1,172 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	applogging "github.com/eurofurence/reg-backend-template-test/internal/repository/logging"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// ----------------------------------------------
+// acceptance tests for the logging admin endpoint
+// ----------------------------------------------
+
+// tstResetLogLevels undoes log level changes made through the admin endpoint, which outlive the test server.
+func tstResetLogLevels() {
+	applogging.SetLevel(slog.LevelInfo, 0)
+	for _, override := range applogging.Overrides() {
+		applogging.RemoveOverride(override.ID)
+	}
+}
+
+func TestLogging_SetLevelSuccess(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+	defer tstResetLogLevels()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they change the log level to DEBUG for 10 minutes")
+	response := tstPerformPut("/api/rest/v1/admin/logging/level", tstRenderJson(apimodel.LogLevelChange{Level: "DEBUG", DurationSeconds: 600}), token)
+
+	docs.Then("then the request is successful and the time the level reverts at is returned")
+	actual := apimodel.LogLevelSettings{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, "DEBUG", actual.Level)
+	require.NotNil(t, actual.RevertAt)
+
+	docs.Then("and the change is audited")
+	events := auditEvents.Events()
+	require.Len(t, events, 1)
+	require.Equal(t, "log.level.change", events[0].Action)
+	require.Equal(t, "101", events[0].Subject)
+	require.Equal(t, apimodel.LogLevelSettings{Level: "INFO"}, events[0].Before)
+	require.JSONEq(t, tstRenderJson(actual), tstRenderJson(events[0].After))
+
+	docs.Then("and the new level is listed")
+	listed := apimodel.LogLevelSettings{}
+	tstRequireSuccessResponse(t, tstPerformGet("/api/rest/v1/admin/logging", token), http.StatusOK, &listed)
+	require.Equal(t, actual, listed)
+}
+
+func TestLogging_OverrideSuccess(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+	defer tstResetLogLevels()
+
+	docs.Given("given a backend service with an api key in the admin group")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it adds a log level override for a user on a route")
+	response := tstPerformPost("/api/rest/v1/admin/logging/overrides", tstRenderJson(apimodel.LogLevelOverride{
+		Subject:         "202",
+		Route:           "POST /api/rest/v1/example/{category}",
+		Level:           "TRACE",
+		DurationSeconds: 600,
+	}), token)
+
+	docs.Then("then the override is created with an id and expiry time")
+	created := apimodel.LogLevelOverride{}
+	tstRequireSuccessResponse(t, response, http.StatusCreated, &created)
+	require.NotEmpty(t, created.Id)
+	require.NotNil(t, created.ExpiresAt)
+	require.Equal(t, "TRACE", created.Level)
+
+	docs.Then("and it is listed")
+	listed := apimodel.LogLevelSettings{}
+	tstRequireSuccessResponse(t, tstPerformGet("/api/rest/v1/admin/logging", token), http.StatusOK, &listed)
+	require.Equal(t, []apimodel.LogLevelOverride{created}, listed.Overrides)
+
+	docs.Then("and it can be removed, but only once")
+	require.Equal(t, http.StatusNoContent, tstPerformDelete("/api/rest/v1/admin/logging/overrides/"+created.Id, token).status)
+	response = tstPerformDelete("/api/rest/v1/admin/logging/overrides/"+created.Id, token)
+	tstRequireErrorResponse(t, response, http.StatusNotFound, "log.override.notfound", url.Values{"id": []string{"no log level override with this id"}})
+
+	docs.Then("and adding and removing it are audited")
+	events := auditEvents.Events()
+	require.Len(t, events, 2)
+	require.Equal(t, "log.override.add", events[0].Action)
+	require.Equal(t, "backend", events[0].APIKeyName)
+	require.JSONEq(t, tstRenderJson(created), tstRenderJson(events[0].After))
+	require.Equal(t, "log.override.remove", events[1].Action)
+	require.JSONEq(t, tstRenderJson(created), tstRenderJson(events[1].Before))
+}
+
+func TestLogging_SetLevelInvalid(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+	defer tstResetLogLevels()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they attempt to change the log level to an unknown level")
+	response := tstPerformPut("/api/rest/v1/admin/logging/level", tstRenderJson(apimodel.LogLevelChange{Level: "CHATTY"}), token)
+
+	docs.Then("then the request is rejected as invalid (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "request.parse.failed", url.Values{"level": []string{"must be one of TRACE, DEBUG, INFO, WARN, ERROR, FATAL, PANIC, SILENT"}})
+}
+
+func TestLogging_OverrideInvalid(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+	defer tstResetLogLevels()
+
+	docs.Given("given a logged in admin")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"admin"})
+
+	docs.When("when they attempt to add a log level override that would match all requests")
+	response := tstPerformPost("/api/rest/v1/admin/logging/overrides", tstRenderJson(apimodel.LogLevelOverride{Level: "DEBUG"}), token)
+
+	docs.Then("then the request is rejected as invalid (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "request.parse.failed", url.Values{"request": []string{"at least one of subject, route, request_id is required"}})
+	require.Empty(t, applogging.Overrides())
+
+	docs.When("when they attempt to add a log level override for a route pattern chi cannot parse")
+	response = tstPerformPost("/api/rest/v1/admin/logging/overrides", tstRenderJson(apimodel.LogLevelOverride{Route: "GET /api/rest/v1/example/{category", Level: "DEBUG"}), token)
+
+	docs.Then("then the request is rejected as invalid (400)")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "request.parse.failed", url.Values{"route": []string{"must be a method or * followed by a route pattern, such as 'GET /api/rest/v1/example'"}})
+	require.Empty(t, applogging.Overrides())
+}
+
+// security tests
+
+func TestLogging_DenyRegularUser(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+	defer tstResetLogLevels()
+
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"staff"})
+
+	docs.When("when they attempt to change the log level")
+	response := tstPerformPut("/api/rest/v1/admin/logging/level", tstRenderJson(apimodel.LogLevelChange{Level: "DEBUG"}), token)
+
+	docs.Then("then the request is denied as forbidden (403)")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+	current, _ := applogging.Level()
+	require.Equal(t, slog.LevelInfo, current)
+}
+
+func TestLogging_DenyUnauthorized(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous user")
+
+	docs.When("when they attempt to list the log levels")
+	response := tstPerformGet("/api/rest/v1/admin/logging", tstNoToken())
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
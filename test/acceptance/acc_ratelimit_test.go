@@ -0,0 +1,79 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/middleware"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// ----------------------------------
+// acceptance tests for rate limiting
+// ----------------------------------
+
+func tstSetupWithRateLimit(t *testing.T) {
+	tstSetupWithConfig(t, map[string]string{
+		middleware.ConfRateLimitRequestsPerMinute: "60",
+		middleware.ConfRateLimitBurst:             "2",
+		middleware.ConfRateLimitRoutes:            `[{"route": "GET /", "requests_per_minute": 0}]`,
+	})
+}
+
+func TestRateLimit_Success(t *testing.T) {
+	tstSetupWithRateLimit(t)
+	defer tstShutdown()
+
+	docs.Given("given a rate limit of 2 requests in quick succession")
+	docs.Given("given two logged in users")
+	token1 := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, nil)
+	token2 := tstValidUserToken(t, 102)
+	tstSetupIDPResponse(t, 102, nil)
+
+	docs.When("when both of them request the example resource twice")
+	for _, token := range []string{token1, token1, token2, token2} {
+		response := tstPerformGet("/api/rest/v1/example", token)
+
+		docs.Then("then all requests are successful")
+		tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Example{})
+	}
+}
+
+func TestRateLimit_SuccessUnlimitedRoute(t *testing.T) {
+	tstSetupWithRateLimit(t)
+	defer tstShutdown()
+
+	docs.Given("given a rate limit of 2 requests in quick succession, which does not apply to the health endpoint")
+
+	docs.When("when the health endpoint is requested many times")
+	for range 5 {
+		response := tstPerformGet("/", tstNoToken())
+
+		docs.Then("then all requests are successful")
+		tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Health{})
+	}
+}
+
+// security tests
+
+func TestRateLimit_DenyTooManyRequests(t *testing.T) {
+	tstSetupWithRateLimit(t)
+	defer tstShutdown()
+
+	docs.Given("given a rate limit of 2 requests in quick succession")
+	docs.Given("given a logged in user who has already made 2 requests")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, nil)
+	for range 2 {
+		require.Equal(t, http.StatusOK, tstPerformGet("/api/rest/v1/example", token).status)
+	}
+
+	docs.When("when they request the example resource again")
+	response := tstPerformGet("/api/rest/v1/example", token)
+
+	docs.Then("then the request is denied as too many requests (429) with a hint when to retry")
+	tstRequireErrorResponse(t, response, http.StatusTooManyRequests, "request.rate.limited", "rate limit exceeded, please slow down")
+	require.Equal(t, "1", response.header.Get("Retry-After"))
+}
@@ -0,0 +1,91 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+// -------------------------------------------------
+// acceptance tests for the effective roles endpoint
+// -------------------------------------------------
+
+func TestRoles_Success(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in user who is in the staff and regdesk groups")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, []string{"regdesk", "staff", "unrelated"})
+
+	docs.When("when they request their effective roles")
+	response := tstPerformGet("/api/rest/v1/info/roles", token)
+
+	docs.Then("then both roles are listed with the combined permissions")
+	actual := apimodel.EffectiveRoles{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, apimodel.EffectiveRoles{
+		Subject:     "101",
+		Roles:       []string{"regdesk", "staff"},
+		Permissions: []string{"attendee.checkin", "attendee.read"},
+	}, actual)
+}
+
+func TestRoles_SuccessNoRoles(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a logged in regular user")
+	token := tstValidUserToken(t, 101)
+	tstSetupIDPResponse(t, 101, nil)
+
+	docs.When("when they request their effective roles")
+	response := tstPerformGet("/api/rest/v1/info/roles", token)
+
+	docs.Then("then no roles or permissions are listed")
+	actual := apimodel.EffectiveRoles{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, apimodel.EffectiveRoles{
+		Subject:     "101",
+		Roles:       []string{},
+		Permissions: []string{},
+	}, actual)
+}
+
+func TestRoles_SuccessApiKey(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given a backend service with an api key in the admin group")
+	token := tstApiKey("backend-key-new")
+
+	docs.When("when it requests its effective roles")
+	response := tstPerformGet("/api/rest/v1/info/roles", token)
+
+	docs.Then("then the admin role is listed with its permissions")
+	actual := apimodel.EffectiveRoles{}
+	tstRequireSuccessResponse(t, response, http.StatusOK, &actual)
+	require.Equal(t, apimodel.EffectiveRoles{
+		Subject:     "",
+		Roles:       []string{"admin"},
+		Permissions: []string{"attendee.read", "attendee.write", "events.manage", "example.write", "logging.manage"},
+	}, actual)
+}
+
+// security tests
+
+func TestRoles_DenyUnauthorized(t *testing.T) {
+	tstSetup(t)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous user")
+	token := tstNoToken()
+
+	docs.When("when they request their effective roles")
+	response := tstPerformGet("/api/rest/v1/info/roles", token)
+
+	docs.Then("then the request is denied as unauthorized (401)")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
@@ -0,0 +1,52 @@
+package acceptance
+
+import (
+	"github.com/eurofurence/reg-backend-template-test/docs"
+	"github.com/eurofurence/reg-backend-template-test/internal/apimodel"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/tracing"
+	"github.com/stretchr/testify/require"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ----------------------------
+// acceptance tests for tracing
+// ----------------------------
+
+func TestTracing_Success(t *testing.T) {
+	tracesFile := filepath.Join(t.TempDir(), "traces.jsonl")
+	tstSetupWithConfig(t, map[string]string{
+		tracing.ConfTracingExporter: tracing.ExporterFile,
+		tracing.ConfTracingFile:     tracesFile,
+	})
+
+	docs.Given("given tracing to a file is enabled")
+
+	docs.When("when an anonymous user accesses the health endpoint as part of an existing trace")
+	request, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	request.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rawResponse, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	response := tstWebResponseFromResponse(rawResponse)
+	tstShutdown()
+
+	docs.Then("then the operation is successful")
+	tstRequireSuccessResponse(t, response, http.StatusOK, &apimodel.Health{Status: "OK"})
+
+	docs.Then("and the request id is the trace id")
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", response.header.Get("X-Request-Id"))
+
+	docs.Then("and a span named after the route is recorded as part of the existing trace")
+	traces, err := os.ReadFile(tracesFile)
+	require.NoError(t, err)
+	require.Contains(t, string(traces), `"Name":"GET /"`)
+	require.Contains(t, string(traces), `"TraceID":"0af7651916cd43dd8448eb211c80319c"`)
+}
@@ -2,10 +2,14 @@ package acceptance
 
 import (
 	"context"
+	auconfigenv "github.com/StephanHCB/go-autumn-config-env"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/app"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/server"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/audit"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/configuration"
+	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
 	"github.com/eurofurence/reg-backend-template-test/test/mocks/idpmock"
+	"github.com/go-chi/chi/v5"
 	"net/http/httptest"
 	"testing"
 )
@@ -13,9 +17,29 @@ import (
 var ts *httptest.Server
 var application *app.Application
 
+// tstRouter is the router of the application, so tests can add routes that the application does not have yet.
+var tstRouter chi.Router
+
+// auditEvents receives the audit events of the application.
+var auditEvents *audit.MemorySink
+
 func tstSetup(t *testing.T) {
 	t.Helper()
 
+	tstSetupWithConfig(t, nil)
+}
+
+// tstSetupWithConfig is tstSetup, but allows overriding configuration values for a single test.
+func tstSetupWithConfig(t *testing.T, configOverrides map[string]string) {
+	t.Helper()
+
+	tstSetupWithIDP(t, configOverrides, idpmock.New())
+}
+
+// tstSetupWithIDP is tstSetupWithConfig, but allows preparing the identity provider mock before startup.
+func tstSetupWithIDP(t *testing.T, configOverrides map[string]string, idpClient idp.IdentityProviderClient) {
+	t.Helper()
+
 	ctx := context.TODO()
 
 	application = app.New()
@@ -23,10 +47,15 @@ func tstSetup(t *testing.T) {
 		t.Error("failed to read acceptance test configuration")
 		t.FailNow()
 	}
+	for key, value := range configOverrides {
+		auconfigenv.Set(key, value)
+	}
 
 	// pre-populate component mocks here
 
-	application.IDPClient = idpmock.New()
+	application.IDPClient = idpClient
+	auditEvents = audit.NewMemorySink()
+	application.Auditor = audit.New(auditEvents)
 
 	// now duplicating application setup (see app.Application.Run()) with required changes for test server
 
@@ -40,7 +69,7 @@ func tstSetup(t *testing.T) {
 		t.FailNow()
 	}
 
-	router, err := server.Router(ctx, application.IDPClient)
+	router, err := server.Router(ctx, application.ErrorReporter, application.Auditor, application.IDPClient, application.AdditionalIDPClients...)
 	if err != nil {
 		t.Error("failed to create router")
 		t.FailNow()
@@ -51,9 +80,11 @@ func tstSetup(t *testing.T) {
 		t.FailNow()
 	}
 
+	tstRouter = router
 	ts = httptest.NewServer(router)
 }
 
 func tstShutdown() {
 	ts.Close()
+	application.ShutdownRepositories(context.TODO())
 }
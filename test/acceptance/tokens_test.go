@@ -2,21 +2,73 @@ package acceptance
 
 import (
 	"fmt"
+	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
 	"github.com/eurofurence/reg-backend-template-test/test/mocks/idpmock"
+	"github.com/golang-jwt/jwt/v5"
 	"testing"
+	"time"
 )
 
 func tstNoToken() string {
 	return ""
 }
 
+// tstApiKeyPrefix marks tokens that tstAddAuth sends as api key instead of bearer token.
+const tstApiKeyPrefix = "apikey:"
+
+// tstApiKey returns a token that makes the request authenticate with the given api key.
+//
+// The available api keys are configured in local-config.yaml.
+func tstApiKey(secret string) string {
+	return tstApiKeyPrefix + secret
+}
+
 func tstValidUserToken(t *testing.T, id uint) string {
 	t.Helper()
 
 	return fmt.Sprintf("valid_user_token_%d", id)
 }
 
+// tstSignedUserToken mints a JWT access token signed by the idp mock, for use with local token validation.
+func tstSignedUserToken(t *testing.T, id uint, groups []string, validFor time.Duration) string {
+	t.Helper()
+
+	return idpmock.SignToken(application.IDPClient, common.AllClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprintf("%d", id),
+			Audience:  jwt.ClaimStrings{"14d9f37a-1eec-47c9-a949-5f1ebdf9c8e5"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(validFor)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		CustomClaims: common.CustomClaims{
+			EMail:         "demouser@example.com",
+			EMailVerified: true,
+			Groups:        groups,
+			Name:          "John Doe",
+		},
+	})
+}
+
+// tstSignedIDToken mints an ID token signed by the idp mock, as a browser frontend would send it in a cookie.
+func tstSignedIDToken(t *testing.T, id uint) string {
+	t.Helper()
+
+	return idpmock.SignToken(application.IDPClient, common.AllClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprintf("%d", id),
+			Audience:  jwt.ClaimStrings{tstIDTokenAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+		CustomClaims: common.CustomClaims{
+			EMail:         "demouser@example.com",
+			EMailVerified: true,
+			Name:          "John Doe",
+		},
+	})
+}
+
 func tstSetupIDPResponse(t *testing.T, id uint, groups []string) {
 	t.Helper()
 
@@ -52,8 +52,11 @@ func tstWebResponseFromResponse(response *http.Response) tstWebResponse {
 }
 
 func tstAddAuth(request *http.Request, token string) {
+	if apiKey, ok := strings.CutPrefix(token, tstApiKeyPrefix); ok {
+		request.Header.Set("X-Api-Key", apiKey)
+		return
+	}
 	request.Header.Set(headers.Authorization, "Bearer "+token)
-	// TODO also support api token
 }
 
 func tstPerformGet(relativeUrlWithLeadingSlash string, token string) tstWebResponse {
@@ -69,6 +72,37 @@ func tstPerformGet(relativeUrlWithLeadingSlash string, token string) tstWebRespo
 	return tstWebResponseFromResponse(response)
 }
 
+// tstPerformImpersonating performs a request without body in which the caller acts as the user with the given subject.
+func tstPerformImpersonating(method string, relativeUrlWithLeadingSlash string, token string, subject string) tstWebResponse {
+	request, err := http.NewRequest(method, ts.URL+relativeUrlWithLeadingSlash, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tstAddAuth(request, token)
+	request.Header.Set("X-Impersonate-Subject", subject)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tstWebResponseFromResponse(response)
+}
+
+// tstPerformGetWithCookies performs a GET request that authenticates via cookies only, like a browser frontend would.
+func tstPerformGetWithCookies(relativeUrlWithLeadingSlash string, cookies map[string]string) tstWebResponse {
+	request, err := http.NewRequest(http.MethodGet, ts.URL+relativeUrlWithLeadingSlash, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, value := range cookies {
+		request.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tstWebResponseFromResponse(response)
+}
+
 func tstPerformPut(relativeUrlWithLeadingSlash string, requestBody string, token string) tstWebResponse {
 	request, err := http.NewRequest(http.MethodPut, ts.URL+relativeUrlWithLeadingSlash, strings.NewReader(requestBody))
 	if err != nil {
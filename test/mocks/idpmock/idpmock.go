@@ -2,16 +2,40 @@ package idpmock
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"errors"
+	"fmt"
 	"github.com/eurofurence/reg-backend-template-test/internal/application/common"
 	"github.com/eurofurence/reg-backend-template-test/internal/repository/idp"
+	"github.com/golang-jwt/jwt/v5"
 	"net/http"
+	"sync"
 )
 
+// KeyID is the key id of the signing key the mock publishes and signs tokens with.
+const KeyID = "mock-key"
+
+// ServiceTokenValue is the token the mock hands out for service to service calls.
+const ServiceTokenValue = "mock-service-token"
+
 func New() idp.IdentityProviderClient {
+	return NewNamed(idp.DefaultName, "mock-issuer")
+}
+
+// NewNamed creates a mock for one of several identity providers, which must have distinct issuers.
+func NewNamed(name string, issuer string) idp.IdentityProviderClient {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("failed to generate mock signing key: " + err.Error())
+	}
+
 	return &impl{
 		userInfo:   make(map[string]idp.UserinfoResponse),
 		tokenIntro: make(map[string]idp.TokenIntrospectionResponse),
+		signingKey: signingKey,
+		name:       name,
+		mockIssuer: issuer,
 	}
 }
 
@@ -19,17 +43,44 @@ type impl struct {
 	userInfo   map[string]idp.UserinfoResponse
 	tokenIntro map[string]idp.TokenIntrospectionResponse
 	issuer     string
+	signingKey *rsa.PrivateKey
+	name       string
+	mockIssuer string
+
+	// discovery may happen in the background, so these are protected
+	discoveryFailing bool
+	mu               sync.RWMutex
 }
 
 func (i *impl) SetupFromWellKnown(ctx context.Context) error {
-	i.issuer = "mock-issuer"
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.discoveryFailing {
+		return errors.New("mock identity provider unreachable")
+	}
+	i.issuer = i.mockIssuer
 	return nil
 }
 
+func (i *impl) Name() string {
+	return i.name
+}
+
 func (i *impl) Issuer() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
 	return i.issuer
 }
 
+func (i *impl) SigningKey(ctx context.Context, kid string) (any, error) {
+	if kid != KeyID {
+		return nil, fmt.Errorf("unknown signing key id '%s'", kid)
+	}
+	return &i.signingKey.PublicKey, nil
+}
+
 func (i *impl) UserInfo(ctx context.Context) (*idp.UserinfoResponse, int, error) {
 	token := common.GetAccessToken(ctx)
 
@@ -52,6 +103,26 @@ func (i *impl) TokenIntrospection(ctx context.Context) (*idp.TokenIntrospectionR
 	return &tInt, http.StatusOK, nil
 }
 
+// ServiceToken always returns the same token, ServiceTokenValue.
+func (i *impl) ServiceToken(ctx context.Context) (string, error) {
+	return ServiceTokenValue, nil
+}
+
+// EvictCachedToken does nothing, the mock does not cache.
+func (i *impl) EvictCachedToken(token string) {
+}
+
+// SetDiscoveryFailing makes discovery fail until called again with false, simulating an unreachable identity provider.
+func SetDiscoveryFailing(instance idp.IdentityProviderClient, failing bool) {
+	mock, ok := instance.(*impl)
+	if ok {
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+
+		mock.discoveryFailing = failing
+	}
+}
+
 func SetupResponse(instance idp.IdentityProviderClient, token string, userInfo idp.UserinfoResponse, tokenIntro idp.TokenIntrospectionResponse) {
 	mock, ok := instance.(*impl)
 	if ok {
@@ -59,3 +130,25 @@ func SetupResponse(instance idp.IdentityProviderClient, token string, userInfo i
 		mock.tokenIntro[token] = tokenIntro
 	}
 }
+
+// SignToken mints a JWT with the given claims, signed with the mock's signing key.
+//
+// Leave the issuer empty to get the issuer of the mock.
+func SignToken(instance idp.IdentityProviderClient, claims common.AllClaims) string {
+	mock, ok := instance.(*impl)
+	if !ok {
+		return ""
+	}
+
+	if claims.Issuer == "" {
+		claims.Issuer = mock.mockIssuer
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KeyID
+	signed, err := token.SignedString(mock.signingKey)
+	if err != nil {
+		panic("failed to sign mock token: " + err.Error())
+	}
+	return signed
+}